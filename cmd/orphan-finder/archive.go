@@ -0,0 +1,88 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// archiveWriter writes the DER of processed orphans into a gzipped tar
+// archive, one entry per orphan named by its type and serial, so that large
+// extractions end up in a single file rather than a directory of tiny ones.
+// Entries are written as orphans are processed, so an archive that wasn't
+// closed holds every entry but the last one being written.
+type archiveWriter struct {
+	sync.Mutex
+	f      *os.File
+	gz     *gzip.Writer
+	tw     *tar.Writer
+	closed bool
+}
+
+// openArchiveOut creates the archive at path. It refuses to overwrite an
+// existing file, as that may be the archive of an earlier run.
+func openArchiveOut(path string) (*archiveWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &archiveWriter{f: f, gz: gz, tw: tar.NewWriter(gz)}, nil
+}
+
+// archiveEntryName returns the name of the archive entry of an orphan.
+func archiveEntryName(typ orphanType, serial string) string {
+	return fmt.Sprintf("%s/%s.der", typ, serial)
+}
+
+// write adds cert to the archive. It does nothing if a is nil.
+func (a *archiveWriter) write(cert *x509.Certificate, typ orphanType) error {
+	if a == nil {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return errors.New("archive output already closed")
+	}
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archiveEntryName(typ, core.SerialToString(cert.SerialNumber)),
+		Mode:     0600,
+		Size:     int64(len(cert.Raw)),
+		ModTime:  cert.NotBefore,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = a.tw.Write(cert.Raw)
+	return err
+}
+
+// Close completes the archive and closes the underlying file. Only the first
+// call has any effect, and it does nothing if a is nil.
+func (a *archiveWriter) Close() error {
+	if a == nil {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+	err := a.tw.Close()
+	if gzErr := a.gz.Close(); err == nil {
+		err = gzErr
+	}
+	if fErr := a.f.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestArchiveOut(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir failed")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "orphans.tar.gz")
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.archiveOut, err = openArchiveOut(path)
+	test.AssertNotError(t, err, "opening archive failed")
+
+	_, err = openArchiveOut(path)
+	test.AssertError(t, err, "existing archive overwritten")
+
+	log.Clear()
+	_ = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	_ = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, len(log.GetAllMatching("archive")), 0)
+
+	// Finishing the run completes the archive
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: &summary{}, opts: newTestOptions(rp)}
+	inv.finish(0, "")
+	test.AssertError(t, rp.archiveOut.write(nil, certOrphan), "write to closed archive accepted")
+	test.AssertNotError(t, rp.archiveOut.Close(), "closing archive twice failed")
+
+	f, err := os.Open(path)
+	test.AssertNotError(t, err, "opening archive failed")
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	test.AssertNotError(t, err, "reading gzip failed")
+	tr := tar.NewReader(gz)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		test.AssertNotError(t, err, "reading tar failed")
+		names = append(names, hdr.Name)
+		der, err := ioutil.ReadAll(tr)
+		test.AssertNotError(t, err, "reading entry failed")
+		_, err = x509.ParseCertificate(der)
+		test.AssertNotError(t, err, "entry isn't a certificate")
+	}
+	test.AssertDeepEquals(t, names, []string{
+		"certificate/ffa0160630d618b2eb5c0510824b14274856.der",
+		"precertificate/03e1dea6f3349009a90e0306dbb39c3e7ca2.der",
+	})
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// defaultPanicStatus is the exit status of a run that panicked, EX_SOFTWARE
+// from sysexits.h, unless --panic-status sets another.
+const defaultPanicStatus = 70
+
+// exit exits the process. It is replaced in tests.
+var exit = os.Exit
+
+// auditRecovered writes the audit entry tagging the orphan with the given
+// serial as added by this run. The SA schema has nowhere to store such an
+// annotation with the row itself, so the audit log, whose entries carry a
+// timestamp, is the record of which rows orphan-finder inserted.
+func auditRecovered(logger blog.Logger, runID string, typ orphanType, serial, reason string) {
+	logger.AuditInfof("orphan-finder recovered %s: serial=[%s] runID=[%s] reason=[%s]",
+		typ, serial, runID, reason)
+}
+
+// invokingUser returns the user running orphan-finder as best it can be
+// determined from the environment, preferring the user who invoked sudo.
+func invokingUser() string {
+	for _, name := range []string{"SUDO_USER", "USER", "LOGNAME"} {
+		if user := os.Getenv(name); user != "" {
+			return user
+		}
+	}
+	return "unknown"
+}
+
+// secretFlags are the flags whose values are credentials, such as a webhook
+// URL embedding its token, which redactArgs hides from the audit log.
+var secretFlags = map[string]bool{
+	"notify-slack": true,
+}
+
+// redactArgs returns a copy of args with the value of every flag in
+// secretFlags replaced, whether it is passed as -flag=value or -flag value.
+func redactArgs(args []string) []string {
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if eq := strings.Index(name, "="); eq >= 0 {
+			if secretFlags[name[:eq]] {
+				redacted[i] = arg[:len(arg)-len(name)+eq+1] + "REDACTED"
+			}
+			continue
+		}
+		if secretFlags[name] && i+1 < len(redacted) {
+			i++
+			redacted[i] = "REDACTED"
+		}
+	}
+	return redacted
+}
+
+// auditInvocation writes the audit entry recording that the run with the given
+// ID was invoked, by whom, and against which services. The values of secret
+// flags are redacted.
+func auditInvocation(logger blog.Logger, runID, configFile string, conf config) {
+	var saAddr, saReadAddr, caAddr string
+	if conf.SAService != nil {
+		saAddr = conf.SAService.ServerAddress
+	}
+	if conf.SAReadService != nil {
+		saReadAddr = conf.SAReadService.ServerAddress
+	}
+	if conf.OCSPGeneratorService != nil {
+		caAddr = conf.OCSPGeneratorService.ServerAddress
+	}
+	logger.AuditInfof("orphan-finder invoked: user=[%s] args=%q config=[%s] saService=[%s] saReadService=[%s] ocspGeneratorService=[%s] runID=[%s]",
+		invokingUser(), redactArgs(os.Args), configFile, saAddr, saReadAddr, caAddr, runID)
+}
+
+// invocation tracks a single run of orphan-finder so that its end is always
+// recorded in the audit log, whether it succeeds, fails or is interrupted.
+type invocation struct {
+	logger  blog.Logger
+	command string
+	start   time.Time
+	summary *summary
+	// opts are the options of the run, whose outputs are closed and whose
+	// failures are reported when it ends.
+	opts *options
+	once sync.Once
+}
+
+// newInvocation returns the invocation of the command run with opts, which it
+// makes the invocation of their reprocessor so that a panic records its end.
+func newInvocation(logger blog.Logger, command string, start time.Time, sum *summary, opts *options) *invocation {
+	inv := &invocation{
+		logger:  logger,
+		command: command,
+		start:   start,
+		summary: sum,
+		opts:    opts,
+	}
+	opts.rp.inv = inv
+	go inv.catchSignals()
+	return inv
+}
+
+// finish completes the archive, rejects, PEM and SQLite outputs, if any,
+// writes the audit entry recording how the run ended, posts it to the
+// notification webhook, if any, and writes the summary to stdout if --output
+// asks for it. As it runs before every exit, which skips deferred calls, the
+// outputs are closed here rather than by main. Only the first call has any
+// effect.
+func (inv *invocation) finish(status int, reason string) {
+	inv.once.Do(func() {
+		opts := inv.opts
+		runID := opts.rp.runID
+		opts.rp.closeOutputs(inv.logger)
+		elapsed := time.Since(inv.start)
+		failed := atomic.LoadInt64(&opts.rp.failedOrphans)
+		parseFailed := atomic.LoadInt64(&opts.rp.parseFailures)
+		defer notifyRun(inv.logger, opts.notifyURL, inv.command, runID, status, reason, inv.summary, elapsed)
+		defer opts.rp.stats.push(inv.logger, inv.command)
+		defer writeSummary(inv.logger, os.Stdout, opts.outputFormat, inv.command, runID, status, inv.summary, failed, parseFailed, elapsed)
+		if status == 0 {
+			inv.logger.AuditInfof("orphan-finder finished: command=[%s] status=0 %s elapsed=%s",
+				inv.command, inv.summary, elapsed)
+			return
+		}
+		inv.logger.AuditErrf("orphan-finder finished: command=[%s] status=%d reason=[%s] %s elapsed=%s",
+			inv.command, status, reason, inv.summary, elapsed)
+	})
+}
+
+// failOnOrphanFailures ends the run with an error if any orphan couldn't be
+// added, other than because it was already stored, so that wrappers treating a
+// zero exit status as success notice. As nothing is added in a dry run or with
+// --sqlite-only, failures are only warned about then.
+func (inv *invocation) failOnOrphanFailures() {
+	rp := inv.opts.rp
+	n := atomic.LoadInt64(&rp.failedOrphans)
+	if n == 0 {
+		return
+	}
+	if rp.dryRun || rp.analysisOnly {
+		inv.logger.Warningf("%d orphans wouldn't have been added", n)
+		return
+	}
+	atomic.StoreInt64(&inv.summary.orphansFailed, n)
+	inv.failOnError(fmt.Errorf("%d orphans couldn't be added", n), "Failed to add orphans")
+}
+
+// failOnError is like cmd.FailOnError but records the end of the run first
+// and exits through exit, so that tests can observe it.
+func (inv *invocation) failOnError(err error, msg string) {
+	if err == nil {
+		return
+	}
+	reason := fmt.Sprintf("%s: %s", msg, err)
+	inv.finish(1, reason)
+	inv.logger.AuditErr(reason)
+	fmt.Fprintln(os.Stderr, reason)
+	exit(1)
+}
+
+// interrupted returns true if the run was interrupted by a signal and should
+// stop at the current line.
+func (rp *reprocessor) interrupted() bool {
+	select {
+	case <-rp.interruptStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// signalStatus returns the exit status for a run ended by sig, which follows
+// the shell convention of 128 plus the signal number.
+func signalStatus(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// catchSignals records the end of the run and exits when a SIGTERM, SIGINT or
+// SIGHUP is received. If the log is followed, the first SIGTERM or SIGINT only
+// stops following it, so that the run ends normally, and if interruptStop is
+// set it stops the run at the current line. Either way a second signal exits
+// at once.
+func (inv *invocation) catchSignals() {
+	rp := inv.opts.rp
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
+	sig := <-sigChan
+	switch {
+	case sig == syscall.SIGHUP:
+	case inv.opts.followStop != nil:
+		inv.logger.Infof("Caught %s, stopping following the log", sig)
+		close(inv.opts.followStop)
+		sig = <-sigChan
+	case rp.interruptStop != nil:
+		inv.logger.Warningf("Caught %s, stopping at the current line, signal again to stop at once", sig)
+		rp.interruptSignal = sig
+		close(rp.interruptStop)
+		sig = <-sigChan
+	}
+	status := signalStatus(sig)
+	inv.finish(status, fmt.Sprintf("caught %s", sig))
+	os.Exit(status)
+}
+
+// failOnInterrupt records the end of a run stopped early by a signal and exits
+// with the status the signal would have ended it with. It does nothing if the
+// run wasn't interrupted.
+func (inv *invocation) failOnInterrupt() {
+	rp := inv.opts.rp
+	if !rp.interrupted() {
+		return
+	}
+	status := signalStatus(rp.interruptSignal)
+	reason := fmt.Sprintf("interrupted by %s", rp.interruptSignal)
+	fmt.Fprintf(os.Stderr, "orphan-finder %s, partial summary: %s\n", reason, inv.summary)
+	inv.finish(status, reason)
+	exit(status)
+}
+
+// workerPanic is a panic of a worker goroutine raised again by the goroutine
+// that waited for it, carrying the stack of the worker where it happened.
+type workerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// recoverPanic is deferred by main to record the end of a run that panicked
+// along with its partial summary, which would otherwise be lost. The panic and
+// its stack are logged at audit level so that the bug isn't hidden, and the
+// process exits with the run's --panic-status. A *workerPanic is reported with
+// the value and stack of the worker that panicked. If *inv is nil because no
+// command has started yet, the panic is only printed to stderr and the process
+// exits with defaultPanicStatus.
+func recoverPanic(inv **invocation) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	stack := debug.Stack()
+	if p, ok := r.(*workerPanic); ok {
+		r, stack = p.value, p.stack
+	}
+	reason := fmt.Sprintf("panic: %v", r)
+	if *inv == nil {
+		fmt.Fprintf(os.Stderr, "orphan-finder %s\n%s", reason, stack)
+		exit(defaultPanicStatus)
+		return
+	}
+	status := (*inv).opts.panicStatus
+	(*inv).logger.AuditErrf("orphan-finder %s\n%s", reason, stack)
+	fmt.Fprintf(os.Stderr, "orphan-finder %s, partial summary: %s\n", reason, (*inv).summary)
+	(*inv).finish(status, reason)
+	exit(status)
+}
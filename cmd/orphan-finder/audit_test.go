@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestAuditInvocation(t *testing.T) {
+	log.Clear()
+	conf := config{}
+	auditInvocation(log, "testrun", "orphan-finder.json", conf)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] orphan-finder invoked: user=\[.+\] args=.* config=\[orphan-finder.json\] .* runID=\[testrun\]`)), 1)
+}
+
+func TestRedactArgs(t *testing.T) {
+	url := "https://hooks.slack.com/services/T000/B000/secret"
+	args := []string{"orphan-finder", "parse-ca-log", "--notify-slack", url, "-notify-slack=" + url, "--log-file", "ca.log", "--", "--notify-slack", url}
+	test.AssertDeepEquals(t, redactArgs(args), []string{"orphan-finder", "parse-ca-log", "--notify-slack", "REDACTED", "-notify-slack=REDACTED",
+		"--log-file", "ca.log", "--", "--notify-slack", url})
+	test.AssertEquals(t, args[3], url)
+
+	defer func(args []string) { os.Args = args }(os.Args)
+	os.Args = []string{"orphan-finder", "parse-ca-log", "--notify-slack=" + url}
+	log.Clear()
+	auditInvocation(log, "testrun", "orphan-finder.json", config{})
+	test.AssertEquals(t, len(log.GetAllMatching(`secret`)), 0)
+	test.AssertEquals(t, len(log.GetAllMatching(`--notify-slack=REDACTED`)), 1)
+}
+
+func TestAuditRecovered(t *testing.T) {
+	log.Clear()
+	auditRecovered(log, "testrun", precertOrphan, "abcd", "incident-1234")
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] orphan-finder recovered precertificate: serial=\[abcd\] `+
+		`runID=\[testrun\] reason=\[incident-1234\]`)), 1)
+}
+
+func TestInvocationFinish(t *testing.T) {
+	sum := &summary{}
+	sum.count(certOrphan, true)
+	sum.count(precertOrphan, false)
+
+	log.Clear()
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: sum, opts: newOptions("parse-ca-log")}
+	inv.finish(0, "")
+	// Only the first call to finish is recorded
+	inv.finish(1, "too late")
+	infos := log.GetAllMatching(`INFO: \[AUDIT\] orphan-finder finished: command=\[parse-ca-log\] status=0 ` +
+		`certOrphansFound=1 certOrphansAdded=1 precertOrphansFound=1 precertOrphansAdded=0 elapsed=`)
+	test.AssertEquals(t, len(infos), 1)
+	test.AssertEquals(t, len(log.GetAllMatching("ERR:")), 0)
+
+	log.Clear()
+	inv = &invocation{logger: log, command: "parse-der", start: time.Now(), summary: &summary{}, opts: newOptions("parse-der")}
+	inv.failOnError(nil, "not an error")
+	test.AssertEquals(t, len(log.GetAll()), 0)
+	inv.finish(130, "caught interrupt")
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder finished: command=\[parse-der\] status=130 reason=\[caught interrupt\]`)), 1)
+}
+
+func TestRecoverPanic(t *testing.T) {
+	var status int
+	exit = func(code int) { status = code }
+	defer func() { exit = os.Exit }()
+
+	sum := &summary{}
+	sum.count(certOrphan, true)
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: sum, opts: newOptions("parse-ca-log")}
+	log.Clear()
+	func() {
+		defer recoverPanic(&inv)
+		panic("nil pointer somewhere")
+	}()
+	test.AssertEquals(t, status, defaultPanicStatus)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder panic: nil pointer somewhere`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder finished: command=\[parse-ca-log\] status=70 `+
+		`reason=\[panic: nil pointer somewhere\] certOrphansFound=1 certOrphansAdded=1`)), 1)
+
+	// Without a panic nothing happens
+	status = 0
+	log.Clear()
+	func() {
+		defer recoverPanic(&inv)
+	}()
+	test.AssertEquals(t, status, 0)
+	test.AssertEquals(t, len(log.GetAll()), 0)
+}
+
+func TestInterrupt(t *testing.T) {
+	var status int
+	exit = func(code int) { status = code }
+	defer func() { exit = os.Exit }()
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+	test.Assert(t, !rp.interrupted(), "interrupted without interruptStop")
+	rp.interruptStop = make(chan struct{})
+	test.Assert(t, !rp.interrupted(), "interrupted before a signal")
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: &summary{}, opts: newTestOptions(rp)}
+	inv.failOnInterrupt()
+	test.AssertEquals(t, status, 0)
+
+	rp.interruptSignal = syscall.SIGTERM
+	close(rp.interruptStop)
+	// Processing stops at the next line
+	log.Clear()
+	logData := logLine(certOrphan, testCertDER, "1001", "1") + "\n"
+	err := rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), inv.summary, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertNotError(t, err, "parsing interrupted log")
+	test.AssertEquals(t, len(sa.certificates), 0)
+	test.AssertEquals(t, len(log.GetAllMatching("INFO: Found 0 certificate orphans and added 0 to the database")), 1)
+
+	inv.failOnInterrupt()
+	test.AssertEquals(t, status, 143)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder finished: command=\[parse-ca-log\] status=143 reason=\[interrupted by terminated\]`)), 1)
+}
+
+func TestFailOnOrphanFailures(t *testing.T) {
+	var status int
+	exit = func(code int) { status = code }
+	defer func() {
+		exit = os.Exit
+	}()
+	sum := &summary{}
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: sum, opts: newTestOptions(rp)}
+
+	// Without failures the run isn't ended
+	log.Clear()
+	inv.failOnOrphanFailures()
+	test.AssertEquals(t, len(log.GetAll()), 0)
+	test.AssertEquals(t, status, 0)
+
+	// A dry run only warns about them
+	rp.failedOrphans = 2
+	rp.dryRun = true
+	inv.failOnOrphanFailures()
+	test.AssertEquals(t, len(log.GetAllMatching(`WARNING: 2 orphans wouldn't have been added`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`orphan-finder finished`)), 0)
+	test.AssertEquals(t, sum.orphansFailed, int64(0))
+	test.AssertEquals(t, status, 0)
+
+	// Otherwise the run ends with an error
+	rp.dryRun = false
+	log.Clear()
+	inv.failOnOrphanFailures()
+	test.AssertEquals(t, status, 1)
+	test.AssertEquals(t, sum.orphansFailed, int64(2))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder finished: command=\[parse-ca-log\] status=1 reason=\[Failed to add orphans: 2 orphans couldn't be added\]`)), 1)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/x509"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// The actions an orphanEvent records.
+const (
+	actionStored   = "stored"
+	actionSkipped  = "skipped"
+	actionRejected = "rejected"
+)
+
+// orphanEvent is the structured audit event recording what a run did with an
+// orphan. Unlike the human readable messages logged alongside it, it is meant
+// to be ingested as the audit trail of which serials orphan-finder inserted.
+type orphanEvent struct {
+	Serial     string `json:"serial"`
+	RegID      int64  `json:"regID"`
+	Type       string `json:"type"`
+	IssuedDate string `json:"issuedDate,omitempty"`
+	Action     string `json:"action"`
+	// Reason is why a rejected orphan wasn't stored.
+	Reason string `json:"reason,omitempty"`
+	RunID  string `json:"runID"`
+}
+
+// auditOrphanEvent logs the orphanEvent for the result of storing cert in the
+// run with the given ID. The cert is nil if its DER couldn't be parsed, and
+// its issued date is its NotBefore plus backdate. A queued orphan's event is
+// only logged once its batch has been stored.
+func auditOrphanEvent(logger blog.Logger, runID string, cert *x509.Certificate, regID int64, backdate time.Duration, res orphanResult) {
+	event := orphanEvent{
+		Serial: res.serial,
+		RegID:  regID,
+		Type:   res.typ.String(),
+		RunID:  runID,
+	}
+	if cert != nil {
+		event.Serial = core.SerialToString(cert.SerialNumber)
+		event.IssuedDate = issuedDateForCert(cert, backdate).Format(time.RFC3339)
+	}
+	switch {
+	case res.stored:
+		event.Action = actionStored
+	case res.err != nil:
+		event.Action = actionRejected
+		event.Reason = res.err.Error()
+	case res.skipped:
+		event.Action = actionSkipped
+	default:
+		return
+	}
+	logger.AuditObject("Orphan event", event)
+}
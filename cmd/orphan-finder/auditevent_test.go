@@ -0,0 +1,39 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestAuditOrphanEvent(t *testing.T) {
+	cert := mustParseHexCert(t, testCertDER)
+
+	for _, tc := range []struct {
+		res  orphanResult
+		want string
+	}{
+		{
+			res:  orphanResult{stored: true, typ: certOrphan},
+			want: `{"serial":"ffa0160630d618b2eb5c0510824b14274856","regID":1001,"type":"certificate","issuedDate":"2015-10-03T05:21:00Z","action":"stored","runID":"eventrun"}`,
+		},
+		{
+			res:  orphanResult{skipped: true, typ: certOrphan},
+			want: `{"serial":"ffa0160630d618b2eb5c0510824b14274856","regID":1001,"type":"certificate","issuedDate":"2015-10-03T05:21:00Z","action":"skipped","runID":"eventrun"}`,
+		},
+		{
+			res:  orphanResult{err: errors.New("nope"), typ: certOrphan},
+			want: `{"serial":"ffa0160630d618b2eb5c0510824b14274856","regID":1001,"type":"certificate","issuedDate":"2015-10-03T05:21:00Z","action":"rejected","reason":"nope","runID":"eventrun"}`,
+		},
+	} {
+		log.Clear()
+		auditOrphanEvent(log, "eventrun", cert, 1001, 0, tc.res)
+		test.AssertDeepEquals(t, log.GetAll(), []string{"INFO: [AUDIT] Orphan event JSON=" + tc.want})
+	}
+
+	// An orphan whose DER couldn't be parsed has no serial or issued date
+	log.Clear()
+	auditOrphanEvent(log, "eventrun", nil, 1001, 0, orphanResult{err: errors.New("bad DER")})
+	test.AssertDeepEquals(t, log.GetAll(), []string{`INFO: [AUDIT] Orphan event JSON={"serial":"","regID":1001,"type":"unknown","action":"rejected","reason":"bad DER","runID":"eventrun"}`})
+}
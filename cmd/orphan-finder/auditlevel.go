@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Categories of expected audit errors about malformed log lines, which can be
+// downgraded with the config's downgradeAuditErrors. Every error is marked as
+// an audit entry by the logger, so downgraded messages are logged as warnings.
+const (
+	// auditUnmatchedCert is a line whose cert= field doesn't match the regex.
+	auditUnmatchedCert = "unmatched-cert"
+	// auditBadHex is a line whose cert= field isn't valid hex.
+	auditBadHex = "bad-hex"
+	// auditMissingRegID is a line without a regID.
+	auditMissingRegID = "missing-regid"
+	// auditBadRegID is a line whose regID can't be parsed.
+	auditBadRegID = "bad-regid"
+	// auditInvalidRegID is a line whose regID is rejected by --strict-regid.
+	auditInvalidRegID = "invalid-regid"
+	// auditBadStatus is a line whose ocspStatus fields can't be parsed.
+	auditBadStatus = "bad-status"
+)
+
+// auditCategories is the set of categories that can be downgraded.
+var auditCategories = map[string]bool{
+	auditUnmatchedCert: true,
+	auditBadHex:        true,
+	auditMissingRegID:  true,
+	auditBadRegID:      true,
+	auditInvalidRegID:  true,
+	auditBadStatus:     true,
+}
+
+// buildDowngradedAuditErrors returns the set of the given categories,
+// returning an error if any of them isn't known.
+func buildDowngradedAuditErrors(categories []string) (map[string]bool, error) {
+	downgraded := make(map[string]bool, len(categories))
+	for _, category := range categories {
+		if !auditCategories[category] {
+			var known []string
+			for c := range auditCategories {
+				known = append(known, c)
+			}
+			sort.Strings(known)
+			return nil, fmt.Errorf("unknown audit error category %q, expected one of %s",
+				category, strings.Join(known, ", "))
+		}
+		downgraded[category] = true
+	}
+	return downgraded, nil
+}
+
+// auditErrf logs an audit error of the given category, or a warning if the
+// category is downgraded. Callers count the orphan as failed themselves, as
+// downgrading only changes how the failure is logged.
+func (rp *reprocessor) auditErrf(category string, format string, a ...interface{}) {
+	if rp.downgradedAuditErrors[category] {
+		rp.logger.Warningf(format, a...)
+		return
+	}
+	rp.logger.AuditErrf(format, a...)
+}
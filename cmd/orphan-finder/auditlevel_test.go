@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestDowngradeAuditErrors(t *testing.T) {
+	_, err := buildDowngradedAuditErrors([]string{"store-failure"})
+	test.AssertError(t, err, "unknown category accepted")
+
+	downgraded, err := buildDowngradedAuditErrors([]string{auditMissingRegID})
+	test.AssertNotError(t, err, "known category rejected")
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.downgradedAuditErrors = downgraded
+
+	// A downgraded category is logged as a warning
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(log.GetAllMatching(`WARNING: regID variable is empty`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR:`)), 0)
+
+	// Other categories are still audit errors
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(certOrphan, "abc", "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Couldn't decode hex`)), 1)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// backfillResult counts the outcomes of regenerating the OCSP responses of
+// stored certificates.
+type backfillResult struct {
+	refreshed int
+	notStored int
+	failed    int
+}
+
+func (r backfillResult) String() string {
+	return fmt.Sprintf("refreshed=%d notStored=%d failed=%d", r.refreshed, r.notStored, r.failed)
+}
+
+// storedStatus returns the orphanStatus asserting the status the SA records
+// for a certificate, so that refreshing its response doesn't unrevoke it.
+func storedStatus(status core.CertificateStatus) orphanStatus {
+	if status.Status != core.OCSPStatusRevoked {
+		return goodStatus
+	}
+	return orphanStatus{revoked: true, reason: status.RevokedReason, revokedAt: status.RevokedDate}
+}
+
+// backfillOCSP generates a fresh OCSP response for every serial that is stored
+// and stores it in place of the current one, e.g. after the OCSP signer's key
+// was rotated. Each response asserts the status the SA records for the serial.
+// It needs an SA able to look up certificate statuses and update OCSP
+// responses, which the SA's gRPC interface doesn't offer.
+func (rp *reprocessor) backfillOCSP(ctx context.Context, serials []string) (backfillResult, error) {
+	var res backfillResult
+	getter, ok := rp.sa.(certificateStatusGetter)
+	if !ok {
+		return res, errors.New("the SA does not support looking up certificate statuses")
+	}
+	updater, ok := primaryStorage(rp.sa).(ocspUpdater)
+	if !ok {
+		return res, errors.New("the SA does not support updating OCSP responses")
+	}
+	for _, serial := range serials {
+		var status core.CertificateStatus
+		err := rp.callRPC(ctx, func(ctx context.Context) error {
+			var err error
+			status, err = getter.GetCertificateStatus(ctx, serial)
+			return err
+		})
+		if berrors.Is(err, berrors.NotFound) {
+			rp.logger.Infof("Skipping %s, which isn't stored", serial)
+			res.notStored++
+			continue
+		}
+		if err != nil {
+			rp.logger.Errf("Failed to look up status of %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		der, err := rp.storedDER(ctx, serial)
+		if err != nil {
+			rp.logger.Errf("Failed to look up DER of %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		response, err := rp.generateOCSP(ctx, der, storedStatus(status))
+		if err != nil {
+			rp.logger.AuditErrf("Couldn't generate OCSP for %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		// Storing the same response twice is harmless, so the update is
+		// retried like a lookup
+		err = rp.callRPC(ctx, func(ctx context.Context) error {
+			return updater.UpdateOCSP(ctx, serial, response)
+		})
+		if err != nil {
+			rp.logger.AuditErrf("Failed to store OCSP response for %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		rp.logger.AuditInfof("orphan-finder refreshed OCSP response: serial=[%s] status=[%s] runID=[%s]", serial, status.Status, rp.runID)
+		res.refreshed++
+	}
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/revocation"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestBackfillOCSP(t *testing.T) {
+	ctx := context.Background()
+	certSerial := "ffa0160630d618b2eb5c0510824b14274856"
+	precertSerial := "03e1dea6f3349009a90e0306dbb39c3e7ca2"
+	sa := &statusSA{
+		mockSA:    mockSA{clk: clock.NewFake()},
+		responses: map[string][]byte{certSerial: []byte("old"), precertSerial: []byte("old")},
+		revoked:   map[string]revocation.Reason{precertSerial: ocsp.KeyCompromise},
+	}
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	regID := int64(1001)
+	_, err := sa.AddCertificate(ctx, &sapb.AddCertificateRequest{Der: certDER, RegID: &regID})
+	test.AssertNotError(t, err, "adding certificate failed")
+	_, err = sa.AddPrecertificate(ctx, &sapb.AddCertificateRequest{Der: precertDER, RegID: &regID})
+	test.AssertNotError(t, err, "adding precertificate failed")
+	serials := []string{certSerial, precertSerial, "00"}
+
+	_, err = newTestReprocessor(&mockSA{}, &mockCA{}).backfillOCSP(ctx, serials)
+	test.AssertError(t, err, "SA without status lookups accepted")
+	readOnly := struct {
+		certificateStorage
+		certificateStatusGetter
+	}{&sa.mockSA, sa}
+	_, err = newTestReprocessor(readOnly, &mockCA{}).backfillOCSP(ctx, serials)
+	test.AssertError(t, err, "SA without OCSP updates accepted")
+
+	// Every stored serial gets a fresh response asserting its stored status
+	log.Clear()
+	res, err := newTestReprocessor(sa, &mockCA{}).backfillOCSP(ctx, serials)
+	test.AssertNotError(t, err, "backfilling OCSP failed")
+	test.AssertEquals(t, res.String(), "refreshed=2 notStored=1 failed=0")
+	test.AssertNotError(t, checkOCSPResponse(certDER, sa.responses[certSerial], goodStatus), "refreshed certificate response is wrong")
+	revoked := orphanStatus{revoked: true, reason: ocsp.KeyCompromise, revokedAt: sa.clk.Now()}
+	test.AssertNotError(t, checkOCSPResponse(precertDER, sa.responses[precertSerial], revoked), "refreshed precertificate response is wrong")
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] orphan-finder refreshed OCSP response: serial=\[`+precertSerial+`\] status=\[revoked\]`)), 1)
+
+	// With a read replica the response is updated on the primary
+	sa.responses[certSerial] = []byte("old")
+	split := splitStorage{certificateStorage: sa, reader: sa}
+	res, err = newTestReprocessor(split, &mockCA{}).backfillOCSP(ctx, []string{certSerial})
+	test.AssertNotError(t, err, "backfilling OCSP through a read replica failed")
+	test.AssertEquals(t, res.String(), "refreshed=1 notStored=0 failed=0")
+
+	// A serial whose response can't be generated is counted as failed and
+	// keeps its response
+	sa.responses[certSerial] = []byte("old")
+	res, err = newTestReprocessor(sa, &failingCA{}).backfillOCSP(ctx, []string{certSerial})
+	test.AssertNotError(t, err, "backfilling OCSP failed")
+	test.AssertEquals(t, res.String(), "refreshed=0 notStored=0 failed=1")
+	test.AssertEquals(t, string(sa.responses[certSerial]), "old")
+}
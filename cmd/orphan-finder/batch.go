@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// batchStorage is implemented by storage authorities able to add many
+// certificates or precertificates in a single call. It isn't part of the SA's
+// gRPC interface, so orphans are added one at a time unless the configured SA
+// supports it. Both methods return an error for every request, nil if it was
+// added, or a single error if the whole batch failed.
+type batchStorage interface {
+	AddCertificatesBatch(ctx context.Context, reqs []*sapb.AddCertificateRequest) ([]error, error)
+	AddPrecertificatesBatch(ctx context.Context, reqs []*sapb.AddCertificateRequest) ([]error, error)
+}
+
+// pendingOrphan is an orphan waiting to be added in a batch.
+type pendingOrphan struct {
+	req    *sapb.AddCertificateRequest
+	typ    orphanType
+	key    serialKey
+	cert   *x509.Certificate
+	issued time.Time
+	origin string
+	line   string
+}
+
+// orphanBatcher buffers orphans to add them in batches. Every orphan keeps its
+// claim on its serial until its batch has been stored, so that the result of
+// the batch decides whether later lines with the serial are skipped. It is safe
+// for concurrent use, storing one batch at a time.
+type orphanBatcher struct {
+	sync.Mutex
+	rp      *reprocessor
+	adder   batchStorage
+	sum     *summary
+	size    int
+	pending map[orphanType][]pendingOrphan
+}
+
+// newOrphanBatcher returns an orphanBatcher adding batches of up to size
+// orphans of a type to the SA of rp, or nil if it doesn't support batches.
+// Successfully added orphans are counted in sum.
+func newOrphanBatcher(rp *reprocessor, sum *summary, size int) *orphanBatcher {
+	adder, ok := primaryStorage(rp.sa).(batchStorage)
+	if !ok {
+		return nil
+	}
+	return &orphanBatcher{
+		rp:      rp,
+		adder:   adder,
+		sum:     sum,
+		size:    size,
+		pending: make(map[orphanType][]pendingOrphan),
+	}
+}
+
+// add queues an orphan, storing the batch of its type if it is full.
+func (b *orphanBatcher) add(ctx context.Context, orphan pendingOrphan) {
+	b.Lock()
+	defer b.Unlock()
+	b.pending[orphan.typ] = append(b.pending[orphan.typ], orphan)
+	if len(b.pending[orphan.typ]) >= b.size {
+		b.store(ctx, orphan.typ)
+	}
+}
+
+// flush stores every queued orphan. It does nothing if b is nil.
+func (b *orphanBatcher) flush(ctx context.Context) {
+	if b == nil {
+		return
+	}
+	b.Lock()
+	defer b.Unlock()
+	for _, typ := range []orphanType{certOrphan, precertOrphan} {
+		b.store(ctx, typ)
+	}
+}
+
+// store adds the queued orphans of the given type in one batch and reports the
+// result for each of them. b must be locked.
+func (b *orphanBatcher) store(ctx context.Context, typ orphanType) {
+	batch := b.pending[typ]
+	if len(batch) == 0 {
+		return
+	}
+	delete(b.pending, typ)
+	rp := b.rp
+	reqs := make([]*sapb.AddCertificateRequest, len(batch))
+	for i, orphan := range batch {
+		reqs[i] = orphan.req
+	}
+	addBatch := b.adder.AddCertificatesBatch
+	if typ == precertOrphan {
+		addBatch = b.adder.AddPrecertificatesBatch
+	}
+	var errs []error
+	err := rp.storeWhenWritable(ctx, func(ctx context.Context) error {
+		var err error
+		errs, err = addBatch(ctx, reqs)
+		return err
+	})
+	if err == nil && len(errs) != len(batch) {
+		err = fmt.Errorf("SA returned %d results for a batch of %d", len(errs), len(batch))
+	}
+	for i, orphan := range batch {
+		orphanErr := err
+		if orphanErr == nil {
+			orphanErr = errs[i]
+		}
+		regID := orphan.req.GetRegID()
+		added := rp.reportStored(ctx, typ, orphan.cert, regID, orphan.issued, orphan.origin, orphanErr)
+		res := orphanResult{stored: added, typ: typ, serial: orphan.key.serial}
+		if !added {
+			res.err = orphanErr
+		}
+		auditOrphanEvent(rp.logger, rp.runID, orphan.cert, regID, rp.backdateDuration, res)
+		b.sum.serials.record(res)
+		if added {
+			b.sum.countAdded(typ)
+		} else if err := rp.rejects.add(orphan.line, orphanErr); err != nil {
+			rp.logger.Errf("Failed to write rejected line: %s, [%s]", err, orphan.origin)
+		}
+		rp.seen.release(orphan.key, added)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/core"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// batchSA is a mockSA able to add orphans in batches, failing those with a
+// serial in fail.
+type batchSA struct {
+	mockSA
+	fail    map[string]bool
+	batches [][]*sapb.AddCertificateRequest
+}
+
+func (m *batchSA) addBatch(ctx context.Context, reqs []*sapb.AddCertificateRequest, add func(*sapb.AddCertificateRequest) error) ([]error, error) {
+	m.batches = append(m.batches, reqs)
+	errs := make([]error, len(reqs))
+	for i, req := range reqs {
+		cert, err := x509.ParseCertificate(req.Der)
+		if err != nil {
+			return nil, err
+		}
+		if m.fail[core.SerialToString(cert.SerialNumber)] {
+			errs[i] = errors.New("duplicate entry")
+			continue
+		}
+		errs[i] = add(req)
+	}
+	return errs, nil
+}
+
+func (m *batchSA) AddCertificatesBatch(ctx context.Context, reqs []*sapb.AddCertificateRequest) ([]error, error) {
+	return m.addBatch(ctx, reqs, func(req *sapb.AddCertificateRequest) error {
+		_, err := m.AddCertificate(ctx, req)
+		return err
+	})
+}
+
+func (m *batchSA) AddPrecertificatesBatch(ctx context.Context, reqs []*sapb.AddCertificateRequest) ([]error, error) {
+	return m.addBatch(ctx, reqs, func(req *sapb.AddCertificateRequest) error {
+		_, err := m.AddPrecertificate(ctx, req)
+		return err
+	})
+}
+
+func TestNewOrphanBatcher(t *testing.T) {
+	batcher := newOrphanBatcher(newTestReprocessor(&mockSA{}, &mockCA{}), &summary{}, 10)
+	test.Assert(t, batcher == nil, "batcher created for an SA without batch support")
+	split := splitStorage{certificateStorage: &batchSA{}, reader: &mockSA{}}
+	batcher = newOrphanBatcher(newTestReprocessor(split, &mockCA{}), &summary{}, 10)
+	test.Assert(t, batcher != nil, "batcher not created for a primary SA with batch support")
+}
+
+func TestReprocessLogBatches(t *testing.T) {
+	issuer, issuerKey := issueTestCert(t, 1, "issuer", true, nil, nil)
+	first, _ := issueTestCert(t, 10, "first.example.com", false, issuer, issuerKey)
+	second, _ := issueTestCert(t, 11, "second.example.com", false, issuer, issuerKey)
+	third, _ := issueTestCert(t, 12, "third.example.com", false, issuer, issuerKey)
+
+	sa := &batchSA{fail: map[string]bool{core.SerialToString(second.SerialNumber): true}}
+	rp := newTestReprocessor(sa, &mockCA{})
+	// The test certificates aren't backdated
+	rp.backdateDuration = 0
+	rp.seen = newSerialCache(false)
+	sum := &summary{}
+	rp.batcher = newOrphanBatcher(rp, sum, 2)
+
+	var logData string
+	for _, cert := range []string{hex.EncodeToString(first.Raw), hex.EncodeToString(second.Raw), hex.EncodeToString(third.Raw)} {
+		logData += logLine(certOrphan, cert, "1001", "0") + "\n"
+	}
+	logData += logLine(precertOrphan, testPreCertDER, "1001", "0") + "\n"
+	// A line repeating a queued orphan is skipped
+	logData += logLine(certOrphan, hex.EncodeToString(third.Raw), "1001", "0") + "\n"
+
+	log.Clear()
+	err := rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertNotError(t, err, "reprocessing log failed")
+
+	// The first batch is stored once full and the rest once the log is done
+	test.AssertEquals(t, len(sa.batches), 3)
+	test.AssertEquals(t, len(sa.batches[0]), 2)
+	test.AssertEquals(t, len(sa.certificates), 2)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, sum.String(), "certOrphansFound=4 certOrphansAdded=2 precertOrphansFound=1 precertOrphansAdded=1 duplicatesSkipped=1 pendingSkipped=1 "+
+		"funnel=scanned:5,marker:5,cert:5,decoded:5,parsed:5,checked:4,stored:3")
+	// The failure is reported for the serial it concerns
+	failures := log.GetAllMatching(`ERR: \[AUDIT\] Failed to store certificate: duplicate entry`)
+	test.AssertEquals(t, len(failures), 1)
+	test.AssertContains(t, failures[0], hex.EncodeToString(second.Raw))
+	test.AssertEquals(t, len(log.GetAllMatching("Skipping certificate already pending in this run")), 1)
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+
+	// The orphan that failed to be added can be retried
+	_, claimed := rp.seen.claim(serialKey{serial: core.SerialToString(second.SerialNumber), typ: certOrphan})
+	test.AssertEquals(t, claimed, true)
+	_, claimed = rp.seen.claim(serialKey{serial: core.SerialToString(first.SerialNumber), typ: certOrphan})
+	test.AssertEquals(t, claimed, false)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmhodges/clock"
+)
+
+const (
+	// budgetReserve is the fraction of the budget below which no new lines are
+	// started, leaving the rest to finish the run.
+	budgetReserve = 0.01
+	// budgetSlack is the number of times its share of the remaining budget a
+	// single line may take, as most lines need no RPCs at all and leave their
+	// share to the orphans.
+	budgetSlack = 10
+)
+
+// runBudget spreads a wall-clock budget for a whole run over its lines. Each
+// line's share of the remaining budget is proportional to its share of the
+// remaining input, so if early lines are slow later ones get tighter
+// deadlines, and lines stop being started once the budget is nearly gone.
+type runBudget struct {
+	clk        clock.Clock
+	total      time.Duration
+	start      time.Time
+	deadline   time.Time
+	totalBytes int64
+	bytes      int64
+	// skipped counts the lines not started because the budget was exhausted.
+	skipped int64
+}
+
+func newRunBudget(clk clock.Clock, total time.Duration, totalBytes int64) *runBudget {
+	now := clk.Now()
+	return &runBudget{
+		clk:        clk,
+		total:      total,
+		start:      now,
+		deadline:   now.Add(total),
+		totalBytes: totalBytes,
+	}
+}
+
+// startLine returns true if a line of lineBytes bytes, including its newline,
+// may be started, along with the deadline of its operations. The deadline is
+// returned rather than kept, as with --workers a line is processed while the
+// next ones are read. It is only called by the goroutine reading the input,
+// and always returns true and a zero deadline if b is nil.
+func (b *runBudget) startLine(lineBytes int) (time.Time, bool) {
+	if b == nil {
+		return time.Time{}, true
+	}
+	now := b.clk.Now()
+	remaining := b.deadline.Sub(now)
+	if remaining <= time.Duration(float64(b.total)*budgetReserve) {
+		atomic.AddInt64(&b.skipped, 1)
+		return time.Time{}, false
+	}
+	share := remaining
+	if remainingBytes := b.totalBytes - b.bytes; remainingBytes > int64(lineBytes) {
+		share = time.Duration(float64(remaining) * float64(lineBytes) / float64(remainingBytes) * budgetSlack)
+		if share > remaining {
+			share = remaining
+		}
+	}
+	b.bytes += int64(lineBytes)
+	return now.Add(share), true
+}
+
+// lineContext returns a context for the operations of a line, which expires at
+// the deadline startLine returned for it. A zero deadline, as for orphans not
+// read from a log or a run without a budget, means the context has none.
+func lineContext(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	if deadline.IsZero() {
+		return context.WithCancel(parent)
+	}
+	return context.WithDeadline(parent, deadline)
+}
+
+// runContext returns a context that expires at the end of the budget, for
+// work done once all lines are processed. Without a budget the context has no
+// deadline.
+func (b *runBudget) runContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if b == nil {
+		return context.WithCancel(parent)
+	}
+	return context.WithDeadline(parent, b.deadline)
+}
+
+// String describes how the budget was consumed.
+func (b *runBudget) String() string {
+	return fmt.Sprintf("budget=%s budgetUsed=%s budgetSkippedLines=%d",
+		b.total, b.clk.Since(b.start).Round(time.Millisecond), atomic.LoadInt64(&b.skipped))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestRunBudget(t *testing.T) {
+	var nilBudget *runBudget
+	deadline, ok := nilBudget.startLine(100)
+	test.AssertEquals(t, ok, true)
+	ctx, cancel := lineContext(context.Background(), deadline)
+	_, ok = ctx.Deadline()
+	test.AssertEquals(t, ok, false)
+	cancel()
+
+	fc := clock.NewFake()
+	b := newRunBudget(fc, 100*time.Second, 1000)
+
+	// A line of a tenth of the input gets ten times its tenth of the budget,
+	// capped at the remaining budget
+	deadline, ok = b.startLine(100)
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, deadline, fc.Now().Add(100*time.Second))
+
+	// Slow early lines leave less for later ones
+	fc.Add(50 * time.Second)
+	deadline, ok = b.startLine(9)
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, deadline, fc.Now().Add(5*time.Second))
+	ctx, cancel = lineContext(context.Background(), deadline)
+	ctxDeadline, ok := ctx.Deadline()
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, ctxDeadline, deadline)
+	cancel()
+
+	// No new lines are started once the budget is nearly exhausted
+	fc.Add(49 * time.Second)
+	_, ok = b.startLine(9)
+	test.AssertEquals(t, ok, false)
+	_, ok = b.startLine(9)
+	test.AssertEquals(t, ok, false)
+	test.AssertEquals(t, b.String(), "budget=1m40s budgetUsed=1m39s budgetSkippedLines=2")
+
+	sum := &summary{budget: b}
+	test.AssertEquals(t, sum.String(), "certOrphansFound=0 certOrphansAdded=0 precertOrphansFound=0 precertOrphansAdded=0 "+
+		"budget=1m40s budgetUsed=1m39s budgetSkippedLines=2")
+}
+
+func TestLogSourceDeadlines(t *testing.T) {
+	fc := clock.NewFake()
+	// Unrelated lines after the orphans keep their shares below the budget
+	logData := logLine(certOrphan, testCertDER, "1001", "0") + "\n" + logLine(precertOrphan, testPreCertDER, "1001", "0") + "\n" +
+		strings.Repeat("unrelated\n", 10000)
+	sa := &lockedSA{mockSA: mockSA{clk: fc}}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.budget = newRunBudget(fc, 100*time.Second, int64(len(logData)))
+
+	// Each orphan carries the deadline of its own line, so that a worker
+	// storing it isn't affected by the lines read after it
+	src := rp.newLogSource(newProgress(log, fc, 0, 0), &summary{}, strings.NewReader(logData), "")
+	_, _, first, err := src.Next()
+	test.AssertNotError(t, err, "reading first orphan")
+	fc.Add(10 * time.Second)
+	_, _, second, err := src.Next()
+	test.AssertNotError(t, err, "reading second orphan")
+	test.Assert(t, !first.deadline.IsZero(), "first orphan has no deadline")
+	test.Assert(t, first.deadline.Before(fc.Now().Add(90*time.Second)), "first orphan's deadline isn't its share")
+	test.Assert(t, second.deadline.After(first.deadline), "second orphan's deadline isn't its own")
+
+	// Orphans stored concurrently each get their own deadline
+	rp.workers = 4
+	rp.budget = newRunBudget(fc, 100*time.Second, int64(len(logData)))
+	rp.seen = newSerialCache(false)
+	src = rp.newLogSource(newProgress(log, fc, 0, 0), &summary{}, strings.NewReader(logData), "")
+	err = rp.processSource(context.Background(), &summary{}, src)
+	test.AssertNotError(t, err, "processing source failed")
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/letsencrypt/boulder/cmd"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// caDefaultBackdate is the backdate the CA uses if its config doesn't set one.
+const caDefaultBackdate = time.Hour
+
+// caBackdateConfig is the part of the boulder-ca config holding its backdate.
+type caBackdateConfig struct {
+	CA struct {
+		Backdate cmd.ConfigDuration
+	}
+}
+
+// loadCABackdate returns the backdate used by a CA run with the boulder-ca
+// config at path, which like the CA defaults to caDefaultBackdate.
+func loadCABackdate(path string) (time.Duration, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var c caBackdateConfig
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		return 0, fmt.Errorf("parsing CA config %s: %s", path, err)
+	}
+	if c.CA.Backdate.Duration == 0 {
+		return caDefaultBackdate, nil
+	}
+	return c.CA.Backdate.Duration, nil
+}
+
+// resolveBackdate returns the backdate to find the issued date of orphans
+// with. That is the CA's, taken from the boulder-ca config at caConfigPath,
+// with a warning if the configured backdate disagrees with it. If caConfigPath
+// is empty or the CA's backdate can't be loaded from it, the configured
+// backdate is used as is.
+func resolveBackdate(logger blog.Logger, configured time.Duration, caConfigPath string) time.Duration {
+	if caConfigPath == "" {
+		return configured
+	}
+	caBackdate, err := loadCABackdate(caConfigPath)
+	if err != nil {
+		logger.Warningf("Couldn't load the CA's backdate, using the configured backdate %s: %s", configured, err)
+		return configured
+	}
+	if caBackdate != configured {
+		logger.Warningf("Configured backdate %s disagrees with the CA's backdate %s, using the CA's", configured, caBackdate)
+	}
+	return caBackdate
+}
+
+// checkBackdate warns about a zero backdate, prominently by also writing the
+// warning to w, or with strict returns it as an error. A CA that backdates
+// issuance makes a zero backdate almost always a mistake, e.g. a config missing
+// the key, which would store every orphan with an issued date off by the CA's
+// real backdate.
+func checkBackdate(logger blog.Logger, w io.Writer, backdate time.Duration, strict bool) error {
+	if backdate != 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("Backdate is zero or unset, so orphans are stored as issued at their NotBefore, "+
+		"which is likely off by the CA's backdate, %s unless its config sets another; "+
+		"set the config's backdate or caConfig", caDefaultBackdate)
+	if strict {
+		return errors.New(msg)
+	}
+	logger.Warningf("%s", msg)
+	fmt.Fprintf(w, "*** orphan-finder: %s ***\n", msg)
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestResolveBackdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	writeConfig := func(name, data string) string {
+		path := filepath.Join(dir, name)
+		test.AssertNotError(t, ioutil.WriteFile(path, []byte(data), 0600), "writing CA config")
+		return path
+	}
+
+	// Without a CA config the configured backdate is used
+	log.Clear()
+	test.AssertEquals(t, resolveBackdate(log, 2*time.Hour, ""), 2*time.Hour)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING")), 0)
+
+	// The CA's backdate is used, with a warning if the configured one differs
+	caA := "../../test/config/ca-a.json"
+	test.AssertEquals(t, resolveBackdate(log, time.Hour, caA), time.Hour)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING")), 0)
+	test.AssertEquals(t, resolveBackdate(log, 0, caA), time.Hour)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Configured backdate 0s disagrees with the CA's backdate 1h0m0s")), 1)
+
+	// Like the CA, a config without a backdate means the default
+	log.Clear()
+	path := writeConfig("no-backdate.json", `{"ca": {"expiry": "2160h"}}`)
+	test.AssertEquals(t, resolveBackdate(log, time.Hour, path), caDefaultBackdate)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING")), 0)
+
+	// A CA config that can't be loaded falls back to the configured backdate
+	path = writeConfig("bad.json", `{"ca": `)
+	test.AssertEquals(t, resolveBackdate(log, 30*time.Minute, path), 30*time.Minute)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Couldn't load the CA's backdate, using the configured backdate 30m0s: parsing CA config")), 1)
+	test.AssertEquals(t, resolveBackdate(log, 30*time.Minute, filepath.Join(dir, "missing.json")), 30*time.Minute)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Couldn't load the CA's backdate")), 2)
+}
+
+func TestCheckBackdate(t *testing.T) {
+	var stderr bytes.Buffer
+	log.Clear()
+	test.AssertNotError(t, checkBackdate(log, &stderr, time.Hour, true), "non-zero backdate refused")
+	test.AssertEquals(t, stderr.Len(), 0)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING")), 0)
+
+	// A zero backdate is warned about, naming the CA's default backdate
+	test.AssertNotError(t, checkBackdate(log, &stderr, 0, false), "zero backdate refused without strict")
+	test.AssertContains(t, stderr.String(), "Backdate is zero or unset")
+	test.AssertEquals(t, len(log.GetAllMatching(`WARNING: Backdate is zero or unset, .* CA's backdate, 1h0m0s`)), 1)
+
+	// or refused with strict
+	log.Clear()
+	stderr.Reset()
+	err := checkBackdate(log, &stderr, 0, true)
+	test.AssertError(t, err, "zero backdate accepted with strict")
+	test.AssertContains(t, err.Error(), "Backdate is zero or unset")
+	test.AssertEquals(t, stderr.Len(), 0)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING")), 0)
+}
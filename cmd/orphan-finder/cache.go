@@ -0,0 +1,123 @@
+package main
+
+import "sync"
+
+// serialState is the last known state of a serial within a single run.
+type serialState int
+
+const (
+	// serialUnknown indicates the serial hasn't been looked up yet
+	serialUnknown serialState = iota
+	// serialAbsent indicates the serial was confirmed missing from the DB but
+	// hasn't been added yet
+	serialAbsent
+	// serialPresent indicates the serial was already present in the DB
+	serialPresent
+	// serialAdded indicates the serial was added to the DB by this run
+	serialAdded
+	// serialPending indicates the serial is being handled by another line
+	serialPending
+)
+
+// String returns a human representation of the serialState.
+func (s serialState) String() string {
+	switch s {
+	case serialAbsent:
+		return "absent"
+	case serialPresent:
+		return "present"
+	case serialAdded:
+		return "added"
+	case serialPending:
+		return "pending"
+	default:
+		return "unknown"
+	}
+}
+
+// serialKey identifies an orphan in the serialCache. Precertificates and
+// certificates share serials, so the orphan type is part of the key unless the
+// cache ignores types.
+type serialKey struct {
+	serial string
+	typ    orphanType
+}
+
+// serialCache remembers the last known state of every serial handled in a run
+// so that a serial appearing on more than one line is only looked up and added
+// once. It is safe for concurrent use. A nil *serialCache remembers nothing
+// and claims every serial.
+type serialCache struct {
+	sync.Mutex
+	states  map[serialKey]serialState
+	pending map[serialKey]bool
+	// ignoreTypes makes the precertificate and certificate for a serial share
+	// one entry, so that once either has been handled the other is skipped.
+	// Normally both are stored.
+	ignoreTypes bool
+}
+
+func newSerialCache(ignoreTypes bool) *serialCache {
+	return &serialCache{
+		states:      make(map[serialKey]serialState),
+		pending:     make(map[serialKey]bool),
+		ignoreTypes: ignoreTypes,
+	}
+}
+
+// normalize returns the key used to store the given key.
+func (c *serialCache) normalize(key serialKey) serialKey {
+	if c.ignoreTypes {
+		key.typ = unknownOrphan
+	}
+	return key
+}
+
+// claim returns the last known state of the serial and whether the caller is
+// now responsible for it. A serial that is present, added or pending can't be
+// claimed. A pending serial isn't waited for, so if the store of its claimant
+// fails the callers turned away meanwhile don't get to retry it. Every
+// successful claim must be followed by a call to release.
+func (c *serialCache) claim(key serialKey) (serialState, bool) {
+	if c == nil {
+		return serialUnknown, true
+	}
+	key = c.normalize(key)
+	c.Lock()
+	defer c.Unlock()
+	if c.pending[key] {
+		return serialPending, false
+	}
+	state := c.states[key]
+	if state == serialPresent || state == serialAdded {
+		return state, false
+	}
+	c.pending[key] = true
+	return state, true
+}
+
+// mark records the result of looking up a claimed serial in the DB.
+func (c *serialCache) mark(key serialKey, state serialState) {
+	if c == nil {
+		return
+	}
+	key = c.normalize(key)
+	c.Lock()
+	defer c.Unlock()
+	c.states[key] = state
+}
+
+// release gives up the claim on a serial, recording it as added if the caller
+// stored it.
+func (c *serialCache) release(key serialKey, added bool) {
+	if c == nil {
+		return
+	}
+	key = c.normalize(key)
+	c.Lock()
+	defer c.Unlock()
+	if added {
+		c.states[key] = serialAdded
+	}
+	delete(c.pending, key)
+}
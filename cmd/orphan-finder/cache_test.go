@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestSerialCache(t *testing.T) {
+	c := newSerialCache(false)
+	key := serialKey{serial: "00ff", typ: certOrphan}
+
+	state, claimed := c.claim(key)
+	test.AssertEquals(t, state, serialUnknown)
+	test.AssertEquals(t, claimed, true)
+
+	// A pending serial can't be claimed twice
+	state, claimed = c.claim(key)
+	test.AssertEquals(t, state, serialPending)
+	test.AssertEquals(t, claimed, false)
+
+	// A serial confirmed absent whose store failed can be claimed again, and
+	// remembers that it was absent
+	c.mark(key, serialAbsent)
+	c.release(key, false)
+	state, claimed = c.claim(key)
+	test.AssertEquals(t, state, serialAbsent)
+	test.AssertEquals(t, claimed, true)
+
+	// Once added it is never claimed again
+	c.release(key, true)
+	state, claimed = c.claim(key)
+	test.AssertEquals(t, state, serialAdded)
+	test.AssertEquals(t, claimed, false)
+
+	// Present serials are never claimed again
+	other := serialKey{serial: "00ff", typ: precertOrphan}
+	_, claimed = c.claim(other)
+	test.AssertEquals(t, claimed, true)
+	c.mark(other, serialPresent)
+	c.release(other, false)
+	state, claimed = c.claim(other)
+	test.AssertEquals(t, state, serialPresent)
+	test.AssertEquals(t, claimed, false)
+
+	// A nil cache claims everything
+	var nilCache *serialCache
+	state, claimed = nilCache.claim(key)
+	test.AssertEquals(t, state, serialUnknown)
+	test.AssertEquals(t, claimed, true)
+	nilCache.mark(key, serialAbsent)
+	nilCache.release(key, true)
+}
+
+func TestSerialCacheIgnoreTypes(t *testing.T) {
+	c := newSerialCache(true)
+	cert := serialKey{serial: "00ff", typ: certOrphan}
+	precert := serialKey{serial: "00ff", typ: precertOrphan}
+
+	_, claimed := c.claim(precert)
+	test.AssertEquals(t, claimed, true)
+	c.release(precert, true)
+
+	// The certificate shares the precertificate's entry
+	state, claimed := c.claim(cert)
+	test.AssertEquals(t, state, serialAdded)
+	test.AssertEquals(t, claimed, false)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// certificateRemover is implemented by storage authorities able to remove a
+// stored certificate or precertificate. It isn't part of the SA's gRPC
+// interface, so a canary is only cleaned up if the configured SA supports it.
+type certificateRemover interface {
+	RemoveCertificate(ctx context.Context, serial string) error
+	RemovePrecertificate(ctx context.Context, serial string) error
+}
+
+// runCanary proves that the write path works before any bulk work is done, by
+// adding the canary certificate or precertificate with the given DER for regID
+// and reading it back from the primary SA. The canary is removed again if the
+// SA supports it. As a canary that is already stored can't prove anything, it
+// is rejected, so without removal each canary can only be used once.
+func (rp *reprocessor) runCanary(ctx context.Context, der []byte, regID int64) error {
+	sa := rp.sa
+	cert, typ, err := rp.checkDER(ctx, primaryStorage(sa), der)
+	if err == errAlreadyExists {
+		return errors.New("canary is already stored, use a canary that has never been added")
+	}
+	if err != nil {
+		return err
+	}
+	serial := core.SerialToString(cert.SerialNumber)
+	response, err := rp.generateOCSP(ctx, der, goodStatus)
+	if err != nil {
+		return fmt.Errorf("generating OCSP for canary %s: %s", serial, err)
+	}
+	issued := issuedDateForCert(cert, rp.backdateDuration).UnixNano()
+	req := &sapb.AddCertificateRequest{
+		Der:    der,
+		RegID:  &regID,
+		Ocsp:   response,
+		Issued: &issued,
+	}
+	switch typ {
+	case certOrphan:
+		_, err = sa.AddCertificate(ctx, req)
+	case precertOrphan:
+		_, err = sa.AddPrecertificate(ctx, req)
+	default:
+		err = errors.New("unknown orphan type")
+	}
+	if err != nil {
+		return fmt.Errorf("adding canary %s %s: %s", typ, serial, err)
+	}
+	stored, err := rp.lookupStored(ctx, primaryStorage(sa), typ, serial)
+	if err != nil {
+		return fmt.Errorf("reading back canary %s %s: %s", typ, serial, err)
+	}
+	if !bytes.Equal(stored, der) {
+		return fmt.Errorf("canary %s %s read back with different content", typ, serial)
+	}
+	rp.logger.AuditInfof("orphan-finder canary %s added and read back: serial=[%s] runID=[%s]", typ, serial, rp.runID)
+
+	remover, ok := primaryStorage(sa).(certificateRemover)
+	if !ok {
+		rp.logger.Warningf("The SA doesn't support removing certificates, leaving canary %s %s in the database", typ, serial)
+		return nil
+	}
+	remove := remover.RemoveCertificate
+	if typ == precertOrphan {
+		remove = remover.RemovePrecertificate
+	}
+	err = remove(ctx, serial)
+	if err != nil {
+		// The write path has been proven, so failing to clean up only warrants
+		// a warning
+		rp.logger.Warningf("Failed to remove canary %s %s: %s", typ, serial, err)
+		return nil
+	}
+	rp.logger.Infof("Removed canary %s %s", typ, serial)
+	return nil
+}
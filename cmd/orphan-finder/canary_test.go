@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// removingSA is a mockSA able to remove stored certificates and
+// precertificates.
+type removingSA struct {
+	mockSA
+}
+
+func (m *removingSA) RemoveCertificate(_ context.Context, serial string) error {
+	m.certificates = removeSerial(m.certificates, serial)
+	return nil
+}
+
+func (m *removingSA) RemovePrecertificate(_ context.Context, serial string) error {
+	m.precertificates = removeSerial(m.precertificates, serial)
+	return nil
+}
+
+func TestRunCanary(t *testing.T) {
+	ctx := context.Background()
+	der, _ := hex.DecodeString(testCertDER)
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+
+	log.Clear()
+	test.AssertNotError(t, rp.runCanary(ctx, der, 1), "canary failed")
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] orphan-finder canary certificate added and read back`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`WARNING: The SA doesn't support removing certificates`)), 1)
+
+	// The canary is left in the database, so it can't prove anything again
+	err := rp.runCanary(ctx, der, 1)
+	test.AssertError(t, err, "reused canary succeeded")
+	test.AssertContains(t, err.Error(), "already stored")
+
+	log.Clear()
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	test.AssertNotError(t, rp.runCanary(ctx, precertDER, 1), "canary failed")
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] orphan-finder canary precertificate added and read back`)), 1)
+
+	// An SA able to remove the canary gets it cleaned up, so it can be reused
+	log.Clear()
+	removing := &removingSA{mockSA{clk: clock.NewFake()}}
+	rp.sa = removing
+	test.AssertNotError(t, rp.runCanary(ctx, precertDER, 1), "canary failed")
+	test.AssertEquals(t, len(removing.precertificates), 0)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: Removed canary precertificate`)), 1)
+	test.AssertNotError(t, rp.runCanary(ctx, precertDER, 1), "removed canary couldn't be reused")
+	rp.sa = sa
+
+	test.AssertError(t, rp.runCanary(ctx, []byte("not DER"), 1), "invalid canary succeeded")
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/letsencrypt/boulder/cmd"
+)
+
+// commands are the commands orphan-finder runs, by name. Each is given the
+// options parsed from its flags and the time the run started.
+var commands = map[string]func(opts *options, start time.Time){
+	"parse-ca-log":      reprocessLogCommand,
+	"parse-journal":     reprocessLogCommand,
+	"reconcile":         reconcileCommand,
+	"missing-from-ct":   missingFromCTCommand,
+	"find-missing-ocsp": findMissingOCSPCommand,
+	"backfill-ocsp":     backfillOCSPCommand,
+	"orphan-rate":       orphanRateCommand,
+	"issuers":           issuersCommand,
+	"count":             countCommand,
+	"parse-der":         parseDERCommand,
+	"parse-der-dir":     parseDERDirCommand,
+}
+
+// reprocessLogCommand runs parse-ca-log and parse-journal, which store the
+// orphans of boulder-ca logs or of the journal of a systemd unit.
+func reprocessLogCommand(opts *options, start time.Time) {
+	rp := opts.rp
+	command := opts.command
+	if command == "parse-ca-log" && opts.logPath == "" {
+		opts.logPath = stdinLogPath
+	}
+	logger, sa, ca := setup(opts)
+	rp.sa, rp.ca, rp.logger = sa, ca, logger
+	sum := &summary{regIDs: rp.touchedRegIDs}
+	rp.interruptStop = make(chan struct{})
+	inv := newInvocation(logger, command, start, sum, opts)
+	inv.failOnError(checkEnvironment(opts.environment, !rp.analysisOnly && !rp.dryRun, opts.allowProd), "Unsafe environment")
+	if rp.requireOrder {
+		if _, ok := sa.(orderGetter); !ok {
+			inv.failOnError(errors.New("the SA does not support looking up orders"), "Unsupported --require-order")
+		}
+	}
+	if rp.onConflict == conflictOverwrite {
+		if _, ok := primaryStorage(sa).(certificateOverwriter); !ok {
+			inv.failOnError(errors.New("the SA does not support overwriting certificates"), "Unsupported conflict policy")
+		}
+	}
+	var err error
+	rp.regIDFilter, err = buildRegIDFilter(context.Background(), sa, opts.onlyRegIDs, opts.regIDEmail, opts.regIDEmailAll)
+	inv.failOnError(err, "Failed to build regID filter")
+	if rp.regIDFilter != nil {
+		logger.Infof("Only storing orphans for registrations %v", rp.regIDFilter)
+	}
+
+	// The journal is read whole, as journalctl has to be run to completion,
+	// while log files are streamed
+	var inputs []logInput
+	var size int64
+	var paths []string
+	var journal []byte
+	if command == "parse-journal" {
+		journal, err = readJournal(opts.unit, opts.since, opts.until)
+		inv.failOnError(err, "Failed to read journal")
+		inputs = []logInput{readerLogInput("journal", bytes.NewReader(journal))}
+		size = int64(len(journal))
+	} else {
+		paths, err = expandLogPaths(opts.logPath)
+		inv.failOnError(err, "Failed to find log files")
+		inputs = fileLogInputs(paths)
+		size = logsSize(paths)
+		if opts.followStop != nil {
+			if len(paths) != 1 || opts.logPath == stdinLogPath {
+				inv.failOnError(errors.New("--follow needs a single log file"), "Invalid --follow")
+			}
+			path := paths[0]
+			inputs = []logInput{{name: path, open: func() (io.ReadCloser, error) {
+				return newFollowReader(logger, path, opts.followStop, followPollInterval)
+			}}}
+			// A followed log has no end to measure progress against
+			size = 0
+			logger.Infof("Following %s until SIGTERM or SIGINT", path)
+		}
+		if len(paths) > 1 {
+			logger.Infof("Processing %d log files: %s", len(paths), strings.Join(paths, ", "))
+		}
+	}
+
+	if opts.force && len(opts.ocspIssuers) > 0 {
+		logger.Warningf("Not checking the issuers of the log against the OCSP generator")
+	} else if len(opts.ocspIssuers) > 0 {
+		switch {
+		case command == "parse-journal":
+			err = checkLogIssuers(bytes.NewReader(journal), opts.continuationMarker, rp.format, opts.ocspIssuers, issuerSampleSize)
+		case opts.logPath == stdinLogPath:
+			// Stdin can't be read twice, so the part of it the check reads
+			// is kept to be read again when processing the log
+			stdin, openErr := openLog(stdinLogPath)
+			inv.failOnError(openErr, "Failed to read log file")
+			var checked bytes.Buffer
+			err = checkLogIssuers(io.TeeReader(stdin, &checked), opts.continuationMarker, rp.format, opts.ocspIssuers, issuerSampleSize)
+			inputs = []logInput{readerLogInput(stdinLogPath, io.MultiReader(&checked, stdin))}
+		default:
+			r, openErr := openLog(paths[0])
+			inv.failOnError(openErr, "Failed to read log file")
+			err = checkLogIssuers(r, opts.continuationMarker, rp.format, opts.ocspIssuers, issuerSampleSize)
+			_ = r.Close()
+		}
+		inv.failOnError(err, "Log doesn't match the OCSP generator, pass --force to process it anyway")
+	}
+
+	if opts.canaryPath != "" {
+		if opts.canaryRegID == 0 || rp.analysisOnly || rp.dryRun {
+			opts.usage()
+		}
+		canary, err := ioutil.ReadFile(opts.canaryPath)
+		inv.failOnError(err, "Failed to read canary DER file")
+		err = rp.runCanary(context.Background(), canary, opts.canaryRegID)
+		inv.failOnError(err, "Canary failed, not processing the log")
+	}
+
+	rp.seen = newSerialCache(opts.dedupAcrossTypes)
+	if opts.batchSize > 1 {
+		rp.batcher = newOrphanBatcher(rp, sum, opts.batchSize)
+		if rp.batcher == nil {
+			logger.Warningf("The SA doesn't support adding orphans in batches, adding them one at a time")
+		}
+	}
+	prog := newProgress(logger, cmd.Clock(), opts.progressEvery, size)
+	if opts.budget > 0 {
+		rp.budget = newRunBudget(cmd.Clock(), opts.budget, size)
+		sum.budget = rp.budget
+	}
+	err = rp.reprocessLogs(context.Background(), prog, sum, opts.continuationMarker, inputs...)
+	inv.failOnError(err, "Failed to read log")
+	inv.failOnInterrupt()
+	inv.failOnError(rp.conflictAbort, "Stopped on conflicting orphan")
+	inv.failOnError(rp.regIDAbort, "Stopped on too many distinct regIDs")
+	inv.failOnError(rp.readOnlyAbort, "Stopped on read-only SA")
+	inv.failOnError(rp.storeAbort, "Stopped on failed store")
+	rp.verifyAdded(context.Background(), inv, sum)
+	rp.verifyResponder(context.Background(), inv, sum)
+	inv.failOnOrphanFailures()
+	inv.finish(0, "")
+}
+
+// reconcileCommand runs reconcile, which prints the orphans of a boulder-ca
+// log missing from the database.
+func reconcileCommand(opts *options, start time.Time) {
+	if opts.logPath == "" {
+		opts.usage()
+	}
+	rp := opts.rp
+	logger, sa, _ := setup(opts)
+	rp.sa, rp.logger = sa, logger
+	inv := newInvocation(logger, opts.command, start, &summary{}, opts)
+	inv.failOnError(checkEnvironment(opts.environment, false, opts.allowProd), "Unsafe environment")
+	r, err := openLog(opts.logPath)
+	inv.failOnError(err, "Failed to read log file")
+	prog := newProgress(logger, cmd.Clock(), opts.progressEvery, r.size)
+	res, err := rp.reconcileLog(context.Background(), prog, r, opts.continuationMarker, func(typ orphanType, serial string) {
+		fmt.Printf("%s %s\n", serial, typ)
+	})
+	_ = r.Close()
+	inv.failOnError(err, "Failed to reconcile log")
+	logger.Infof("Reconciled log with the database: %s", res)
+	inv.finish(0, "")
+}
+
+// missingFromCTCommand runs missing-from-ct, which prints the orphans of a
+// boulder-ca log missing from the CT logs named by their SCTs.
+func missingFromCTCommand(opts *options, start time.Time) {
+	if opts.logPath == "" {
+		opts.usage()
+	}
+	rp := opts.rp
+	conf, logger := loadConfig(opts.configFile, opts.syslogTag, rp.runID)
+	opts.environment = conf.Environment
+	auditInvocation(logger, rp.runID, opts.configFile, conf)
+	inv := newInvocation(logger, opts.command, start, &summary{}, opts)
+	auditor, err := newCTAuditor(logger, conf.CTAudit)
+	inv.failOnError(err, "Failed to set up CT logs")
+	r, err := openLog(opts.logPath)
+	inv.failOnError(err, "Failed to read log file")
+	_, err = auditCTLog(context.Background(), auditor, logger, rp.format, r, opts.continuationMarker, func(d ctDiscrepancy) {
+		fmt.Printf("%s %s\n", d.Serial, d.LogURI)
+	})
+	_ = r.Close()
+	inv.failOnError(err, "Failed to read log file")
+	inv.finish(0, "")
+}
+
+// findMissingOCSPCommand runs find-missing-ocsp, which prints the stored
+// orphans of a boulder-ca log, and the serials given, without an OCSP response,
+// and with --fix stores a fresh one for each.
+func findMissingOCSPCommand(opts *options, start time.Time) {
+	rp := opts.rp
+	if opts.logPath == "" && rp.serialFilter == nil {
+		opts.usage()
+	}
+	logger, sa, ca := setup(opts)
+	rp.sa, rp.ca, rp.logger = sa, ca, logger
+	inv := newInvocation(logger, opts.command, start, &summary{}, opts)
+	inv.failOnError(checkEnvironment(opts.environment, opts.fix, opts.allowProd), "Unsafe environment")
+	var r io.Reader
+	var f *logReader
+	var err error
+	if opts.logPath != "" {
+		f, err = openLog(opts.logPath)
+		inv.failOnError(err, "Failed to read log file")
+		r = f
+	}
+	serials, err := serialsToCheck(r, opts.continuationMarker, rp.format, rp.serialFilter)
+	if f != nil {
+		_ = f.Close()
+	}
+	inv.failOnError(err, "Failed to read log file")
+	res, err := rp.findMissingOCSP(context.Background(), serials, opts.fix, func(serial string) {
+		fmt.Println(serial)
+	})
+	inv.failOnError(err, "Failed to check OCSP responses")
+	logger.Infof("Checked OCSP responses: %s", res)
+	inv.finish(0, "")
+}
+
+// backfillOCSPCommand runs backfill-ocsp, which regenerates and stores the OCSP
+// responses of the stored certificates given.
+func backfillOCSPCommand(opts *options, start time.Time) {
+	rp := opts.rp
+	if rp.serialFilter == nil {
+		opts.usage()
+	}
+	logger, sa, ca := setup(opts)
+	rp.sa, rp.ca, rp.logger = sa, ca, logger
+	inv := newInvocation(logger, opts.command, start, &summary{}, opts)
+	inv.failOnError(checkEnvironment(opts.environment, true, opts.allowProd), "Unsafe environment")
+	serials, err := serialsToCheck(nil, "", rp.format, rp.serialFilter)
+	inv.failOnError(err, "Failed to list serials")
+	res, err := rp.backfillOCSP(context.Background(), serials)
+	inv.failOnError(err, "Failed to backfill OCSP responses")
+	logger.Infof("Backfilled OCSP responses: %s", res)
+	if res.failed > 0 {
+		inv.failOnError(fmt.Errorf("%d OCSP responses couldn't be refreshed", res.failed), "Failed to backfill OCSP responses")
+	}
+	inv.finish(0, "")
+}
+
+// orphanRateCommand runs orphan-rate, which prints the number of orphans of a
+// boulder-ca log per bucket of time as CSV.
+func orphanRateCommand(opts *options, start time.Time) {
+	if opts.logPath == "" || opts.bucket <= 0 {
+		opts.usage()
+	}
+	rate := newOrphanRate(opts.rp.format, opts.bucket)
+	err := streamLog(opts.logPath, opts.continuationMarker, rate.add)
+	cmd.FailOnError(err, "Failed to read log file")
+	if rate.untimed > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d orphans whose log line has no timestamp\n", rate.untimed)
+	}
+	cmd.FailOnError(rate.writeCSV(os.Stdout), "Failed to write orphan rate")
+}
+
+// issuersCommand runs issuers, which lists the issuers of the orphans of a
+// boulder-ca log.
+func issuersCommand(opts *options, start time.Time) {
+	if opts.logPath == "" {
+		opts.usage()
+	}
+	tally := newIssuerTally(opts.rp.format)
+	err := streamLog(opts.logPath, opts.continuationMarker, tally.add)
+	cmd.FailOnError(err, "Failed to read log file")
+	if tally.unparsable > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d orphans whose DER couldn't be parsed\n", tally.unparsable)
+	}
+	cmd.FailOnError(tally.write(os.Stdout), "Failed to write issuers")
+}
+
+// countCommand runs count, which prints the number of orphans of a boulder-ca
+// log by type.
+func countCommand(opts *options, start time.Time) {
+	if opts.logPath == "" {
+		opts.usage()
+	}
+	setupOffline(opts)
+	count := orphanCount{format: opts.rp.format}
+	err := streamLog(opts.logPath, opts.continuationMarker, count.add)
+	cmd.FailOnError(err, "Failed to read log file")
+	cmd.FailOnError(count.write(os.Stdout), "Failed to write counts")
+}
+
+// parseDERCommand runs parse-der, which stores the orphans in the DER files
+// given.
+func parseDERCommand(opts *options, start time.Time) {
+	ctx := context.Background()
+	if len(opts.derPaths) == 0 || opts.regID == 0 {
+		opts.usage()
+	}
+	rp := opts.rp
+	logger, sa, ca := setup(opts)
+	rp.sa, rp.ca, rp.logger = sa, ca, logger
+	sum := &summary{regIDs: rp.touchedRegIDs}
+	inv := newInvocation(logger, opts.command, start, sum, opts)
+	inv.failOnError(checkEnvironment(opts.environment, !rp.analysisOnly && !rp.dryRun && !opts.inspectOnly, opts.allowProd), "Unsafe environment")
+	status, err := parseOrphanStatus(opts.statusName, opts.revokedReason, opts.revokedAt)
+	inv.failOnError(err, "Invalid OCSP status")
+	// Every file is read before any is stored, so that a typo in one of
+	// the paths doesn't leave the others half added
+	src := &memorySource{}
+	for _, path := range opts.derPaths {
+		der, origin, err := readCertFile(logger, path, opts.pemChain)
+		inv.failOnError(err, fmt.Sprintf("Failed to read DER file %s", path))
+		if opts.inspect || opts.inspectOnly {
+			cert, err := x509.ParseCertificate(der)
+			inv.failOnError(err, fmt.Sprintf("Failed to parse DER file %s", path))
+			inv.failOnError(writeInspection(os.Stdout, cert, rp.format.orphanType(cert), rp.backdateDuration), "Failed to write inspection")
+		}
+		src.orphans = append(src.orphans, sourcedOrphan{
+			der:   der,
+			regID: opts.regID,
+			meta:  sourceMeta{origin: origin, status: status},
+		})
+	}
+	if opts.inspectOnly {
+		inv.finish(0, "")
+		return
+	}
+	// A memorySource never fails
+	_ = rp.processSource(ctx, sum, src)
+	inv.failOnError(rp.readOnlyAbort, "Stopped on read-only SA")
+	if len(opts.derPaths) > 1 {
+		logger.Infof("Found %d certificate orphans and added %d to the database", sum.certOrphansFound, sum.certOrphansAdded)
+		logger.Infof("Found %d precertificate orphans and added %d to the database", sum.precertOrphansFound, sum.precertOrphansAdded)
+	}
+	rp.verifyAdded(ctx, inv, sum)
+	rp.verifyResponder(ctx, inv, sum)
+	inv.failOnOrphanFailures()
+	inv.finish(0, "")
+}
+
+// parseDERDirCommand runs parse-der-dir, which stores the orphans in the DER
+// files of a directory.
+func parseDERDirCommand(opts *options, start time.Time) {
+	ctx := context.Background()
+	if opts.derDir == "" || opts.regID == 0 {
+		opts.usage()
+	}
+	rp := opts.rp
+	logger, sa, ca := setup(opts)
+	rp.sa, rp.ca, rp.logger = sa, ca, logger
+	sum := &summary{regIDs: rp.touchedRegIDs}
+	inv := newInvocation(logger, opts.command, start, sum, opts)
+	inv.failOnError(checkEnvironment(opts.environment, !rp.analysisOnly && !rp.dryRun, opts.allowProd), "Unsafe environment")
+	paths, err := listDERFiles(opts.derDir)
+	inv.failOnError(err, "Failed to list DER directory")
+	logger.Infof("Found %d DER files in %s", len(paths), opts.derDir)
+	src := &dirSource{rp: rp, paths: paths, regID: opts.regID, pemChain: opts.pemChain}
+	// A dirSource skips the files it fails to read instead of failing
+	_ = rp.processSource(ctx, sum, src)
+	inv.failOnError(rp.readOnlyAbort, "Stopped on read-only SA")
+	logger.Infof("Found %d certificate orphans and added %d to the database", sum.certOrphansFound, sum.certOrphansAdded)
+	logger.Infof("Found %d precertificate orphans and added %d to the database", sum.precertOrphansFound, sum.precertOrphansAdded)
+	rp.logTooOld(sum)
+	rp.verifyAdded(ctx, inv, sum)
+	rp.verifyResponder(ctx, inv, sum)
+	inv.failOnOrphanFailures()
+	inv.finish(0, "")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+var errContentConflict = errors.New("Certificate with the same serial but different content already exists in DB")
+
+// certContent is the parsed content of a certificate that certFingerprint
+// covers. Fields are taken from the parsed certificate rather than its raw
+// encoding so that two encodings of the same certificate produce the same
+// fingerprint.
+type certContent struct {
+	Serial             string
+	Issuer             string
+	Subject            string
+	NotBefore          time.Time
+	NotAfter           time.Time
+	PublicKey          []byte
+	SignatureAlgorithm x509.SignatureAlgorithm
+	Extensions         []certExtension
+}
+
+type certExtension struct {
+	ID       string
+	Critical bool
+	Value    []byte
+}
+
+// certFingerprint returns a SHA-256 fingerprint of the parsed fields of the
+// certificate's TBSCertificate. Benign ASN.1 differences, like a name encoded
+// as a UTF8String instead of a PrintableString, don't change the fingerprint.
+func certFingerprint(cert *x509.Certificate) ([32]byte, error) {
+	publicKey, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	content := certContent{
+		Serial:             cert.SerialNumber.String(),
+		Issuer:             cert.Issuer.String(),
+		Subject:            cert.Subject.String(),
+		NotBefore:          cert.NotBefore.UTC(),
+		NotAfter:           cert.NotAfter.UTC(),
+		PublicKey:          publicKey,
+		SignatureAlgorithm: cert.SignatureAlgorithm,
+	}
+	for _, ext := range cert.Extensions {
+		content.Extensions = append(content.Extensions, certExtension{
+			ID:       ext.Id.String(),
+			Critical: ext.Critical,
+			Value:    ext.Value,
+		})
+	}
+	encoded, err := json.Marshal(content)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(encoded), nil
+}
+
+// compareStoredDER compares an orphan against the DER stored in the DB for the
+// same serial. It returns errAlreadyExists if they are the same certificate,
+// even if encoded differently, and errContentConflict if they aren't.
+func compareStoredDER(orphan *x509.Certificate, storedDER []byte) error {
+	if bytes.Equal(orphan.Raw, storedDER) {
+		return errAlreadyExists
+	}
+	stored, err := x509.ParseCertificate(storedDER)
+	if err != nil {
+		return errContentConflict
+	}
+	orphanFP, err := certFingerprint(orphan)
+	if err != nil {
+		return err
+	}
+	storedFP, err := certFingerprint(stored)
+	if err != nil {
+		return err
+	}
+	if orphanFP != storedFP {
+		return errContentConflict
+	}
+	return errAlreadyExists
+}
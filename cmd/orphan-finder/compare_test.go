@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func mustParseHexCert(t *testing.T, derHex string) *x509.Certificate {
+	t.Helper()
+	der, err := hex.DecodeString(derHex)
+	test.AssertNotError(t, err, "decoding test DER")
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "parsing test DER")
+	return cert
+}
+
+func TestCompareStoredDER(t *testing.T) {
+	orphan := mustParseHexCert(t, testCertDER)
+
+	// The same certificate with its subject CN encoded as a UTF8String instead
+	// of a PrintableString
+	reencodedHex := strings.Replace(testCertDER,
+		"0603550403130d6578616d706c652e636f2e626e",
+		"06035504030c0d6578616d706c652e636f2e626e", 1)
+	test.AssertNotEquals(t, reencodedHex, testCertDER)
+	reencoded := mustParseHexCert(t, reencodedHex)
+
+	// A different certificate with the same serial, valid for another year
+	conflictingHex := strings.Replace(testCertDER,
+		"170d3136303130313035323130305a",
+		"170d3137303130313035323130305a", 1)
+	test.AssertNotEquals(t, conflictingHex, testCertDER)
+	conflicting := mustParseHexCert(t, conflictingHex)
+
+	test.AssertEquals(t, compareStoredDER(orphan, orphan.Raw), errAlreadyExists)
+	test.AssertEquals(t, compareStoredDER(orphan, reencoded.Raw), errAlreadyExists)
+	test.AssertEquals(t, compareStoredDER(orphan, conflicting.Raw), errContentConflict)
+	test.AssertEquals(t, compareStoredDER(orphan, []byte{0xde, 0xad}), errContentConflict)
+}
+
+func TestCheckCertCompareDER(t *testing.T) {
+	sa := &mockSA{}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.compareDER = true
+	orphan := mustParseHexCert(t, testCertDER)
+	regID := int64(1)
+	issued := orphan.NotBefore.UnixNano()
+	_, err := sa.AddCertificate(context.Background(), &sapb.AddCertificateRequest{Der: orphan.Raw, RegID: &regID, Issued: &issued})
+	test.AssertNotError(t, err, "storing test cert")
+
+	reencoded := mustParseHexCert(t, strings.Replace(testCertDER,
+		"0603550403130d6578616d706c652e636f2e626e",
+		"06035504030c0d6578616d706c652e636f2e626e", 1))
+	_, _, err = rp.checkCert(context.Background(), sa, reencoded)
+	test.AssertEquals(t, err, errAlreadyExists)
+
+	conflicting := mustParseHexCert(t, strings.Replace(testCertDER,
+		"170d3136303130313035323130305a",
+		"170d3137303130313035323130305a", 1))
+	_, _, err = rp.checkCert(context.Background(), sa, conflicting)
+	test.AssertEquals(t, err, errContentConflict)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/letsencrypt/boulder/cmd"
+)
+
+// checkClientConfig returns an error naming every field dialServices needs
+// that is missing from conf, so that an incomplete config fails the run at
+// startup rather than deep in setting up a client. Fields are named as in the
+// config file.
+func checkClientConfig(conf config) error {
+	var missing []string
+	for _, f := range []struct {
+		name string
+		path *string
+	}{
+		{"tls.certFile", conf.TLS.CertFile},
+		{"tls.keyFile", conf.TLS.KeyFile},
+		{"tls.caCertFile", conf.TLS.CACertFile},
+	} {
+		if f.path == nil || *f.path == "" {
+			missing = append(missing, f.name)
+		}
+	}
+	for _, s := range []struct {
+		name     string
+		service  *cmd.GRPCClientConfig
+		optional bool
+	}{
+		{"saService", conf.SAService, false},
+		{"saReadService", conf.SAReadService, true},
+		{"ocspGeneratorService", conf.OCSPGeneratorService, false},
+	} {
+		if s.service == nil {
+			if !s.optional {
+				missing = append(missing, s.name)
+			}
+			continue
+		}
+		if s.service.ServerAddress == "" {
+			missing = append(missing, s.name+".serverAddress")
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("config is missing %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestCheckClientConfig(t *testing.T) {
+	path := "test.pem"
+	complete := config{
+		TLS:                  cmd.TLSConfig{CertFile: &path, KeyFile: &path, CACertFile: &path},
+		SAService:            &cmd.GRPCClientConfig{ServerAddress: "sa.boulder:9095"},
+		OCSPGeneratorService: &cmd.GRPCClientConfig{ServerAddress: "ca.boulder:9096"},
+	}
+	test.AssertNotError(t, checkClientConfig(complete), "complete config rejected")
+
+	err := checkClientConfig(config{})
+	test.AssertError(t, err, "empty config accepted")
+	test.AssertEquals(t, err.Error(), "config is missing tls.certFile, tls.keyFile, tls.caCertFile, saService, ocspGeneratorService")
+
+	empty := ""
+	incomplete := complete
+	incomplete.TLS.KeyFile = &empty
+	incomplete.SAReadService = &cmd.GRPCClientConfig{}
+	incomplete.OCSPGeneratorService = &cmd.GRPCClientConfig{}
+	err = checkClientConfig(incomplete)
+	test.AssertError(t, err, "incomplete config accepted")
+	test.AssertEquals(t, err.Error(), "config is missing tls.keyFile, saReadService.serverAddress, ocspGeneratorService.serverAddress")
+}
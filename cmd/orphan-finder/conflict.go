@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// conflictPolicy says what to do with an orphan whose serial is already stored
+// with different content.
+type conflictPolicy string
+
+const (
+	// conflictSkip logs the conflict and leaves the stored orphan as it is.
+	conflictSkip = conflictPolicy("skip")
+	// conflictOverwrite replaces the stored orphan with the one from the log.
+	conflictOverwrite = conflictPolicy("overwrite")
+	// conflictFail stops the run.
+	conflictFail = conflictPolicy("fail")
+)
+
+// certificateOverwriter is implemented by storage authorities able to replace
+// a stored certificate or precertificate. It isn't part of the SA's gRPC
+// interface, so conflictOverwrite can only be used if the configured SA
+// supports it.
+type certificateOverwriter interface {
+	OverwriteCertificate(ctx context.Context, req *sapb.AddCertificateRequest) error
+	OverwritePrecertificate(ctx context.Context, req *sapb.AddCertificateRequest) error
+}
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch p := conflictPolicy(s); p {
+	case conflictSkip, conflictOverwrite, conflictFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, expected skip, overwrite or fail", s)
+}
+
+// resolveConflict applies rp.onConflict to the orphan with the given serial,
+// whose content conflicts with the stored one. It returns true if the orphan
+// should be stored anyway, overwriting the stored one.
+func (rp *reprocessor) resolveConflict(typ orphanType, serial, line string) bool {
+	logger := rp.logger
+	atomic.AddInt64(&rp.contentConflicts, 1)
+	switch rp.onConflict {
+	case conflictOverwrite:
+		logger.Warningf("Overwriting stored %s %s with different content, [%s]", typ, serial, line)
+		return true
+	case conflictFail:
+		rp.abortRun(&rp.conflictAbort, fmt.Errorf("%s %s conflicts with the stored one", typ, serial))
+		logger.AuditErrf("%s, stopping the run, [%s]", errContentConflict, line)
+	default:
+		logger.Errf("%s, [%s]", errContentConflict, line)
+	}
+	return false
+}
+
+// overwriteOrphan replaces the stored certificate or precertificate, depending
+// on typ, with the one in req, using the primary SA.
+func (rp *reprocessor) overwriteOrphan(ctx context.Context, typ orphanType, req *sapb.AddCertificateRequest) error {
+	overwriter, ok := primaryStorage(rp.sa).(certificateOverwriter)
+	if !ok {
+		return errors.New("the SA does not support overwriting certificates")
+	}
+	switch typ {
+	case certOrphan:
+		return overwriter.OverwriteCertificate(ctx, req)
+	case precertOrphan:
+		return overwriter.OverwritePrecertificate(ctx, req)
+	}
+	return errors.New("unknown orphan type")
+}
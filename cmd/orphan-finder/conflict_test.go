@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/core"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// overwritingSA is a mockSA able to overwrite stored certificates.
+type overwritingSA struct {
+	mockSA
+	overwritten []string
+}
+
+func (m *overwritingSA) OverwriteCertificate(_ context.Context, req *sapb.AddCertificateRequest) error {
+	cert, err := x509.ParseCertificate(req.Der)
+	if err != nil {
+		return err
+	}
+	serial := core.SerialToString(cert.SerialNumber)
+	m.certificates = removeSerial(m.certificates, serial)
+	m.overwritten = append(m.overwritten, serial)
+	_, err = m.AddCertificate(context.Background(), req)
+	return err
+}
+
+func (m *overwritingSA) OverwritePrecertificate(context.Context, *sapb.AddCertificateRequest) error {
+	return nil
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	for _, s := range []string{"skip", "overwrite", "fail"} {
+		p, err := parseConflictPolicy(s)
+		test.AssertNotError(t, err, "valid policy rejected")
+		test.AssertEquals(t, string(p), s)
+	}
+	_, err := parseConflictPolicy("ignore")
+	test.AssertError(t, err, "invalid policy accepted")
+}
+
+func TestOnConflict(t *testing.T) {
+	conflictingHex := strings.Replace(testCertDER,
+		"170d3136303130313035323130305a",
+		"170d3137303130313035323130305a", 1)
+	line := logLine(certOrphan, conflictingHex, "1", "1")
+	stored := mustParseHexCert(t, testCertDER)
+	regID := int64(1)
+	issued := stored.NotBefore.UnixNano()
+
+	for _, tc := range []struct {
+		policy    conflictPolicy
+		added     bool
+		aborted   bool
+		logged    string
+		overwrite bool
+		// conflicts is the number of conflicts once the line is seen again
+		conflicts int64
+	}{
+		{policy: conflictSkip, logged: `ERR: \[AUDIT\] Certificate with the same serial but different content already exists in DB, \[`, conflicts: 2},
+		{policy: conflictFail, aborted: true, logged: `ERR: \[AUDIT\] Certificate with the same serial but different content already exists in DB, stopping the run`, conflicts: 1},
+		// Once overwritten the orphan is no longer a conflict
+		{policy: conflictOverwrite, added: true, overwrite: true, logged: `WARNING: Overwriting stored certificate`, conflicts: 1},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			sa := &overwritingSA{mockSA: mockSA{clk: clock.NewFake()}}
+			rp := newTestReprocessor(sa, &mockCA{})
+			rp.compareDER = true
+			rp.onConflict = tc.policy
+			_, err := sa.AddCertificate(context.Background(), &sapb.AddCertificateRequest{Der: stored.Raw, RegID: &regID, Issued: &issued})
+			test.AssertNotError(t, err, "storing test cert")
+			log.Clear()
+
+			res := rp.storeParsedLogLine(line)
+			test.AssertEquals(t, res.matched, true)
+			test.AssertEquals(t, res.stored, tc.added)
+			test.AssertEquals(t, res.typ, certOrphan)
+			test.AssertEquals(t, res.conflict, true)
+			test.AssertEquals(t, rp.contentConflicts, int64(1))
+			test.AssertEquals(t, rp.conflictAbort != nil, tc.aborted)
+			test.AssertEquals(t, len(log.GetAllMatching(tc.logged)), 1)
+			test.AssertEquals(t, len(sa.overwritten) == 1, tc.overwrite)
+
+			// Once aborted no further lines are started
+			sum := &summary{}
+			rp.seen = newSerialCache(false)
+			_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("ca.log", strings.NewReader(line)))
+			test.AssertEquals(t, rp.contentConflicts, tc.conflicts)
+			test.AssertContains(t, sum.String(), fmt.Sprintf("contentConflicts=%d onConflict=%s", tc.conflicts, tc.policy))
+		})
+	}
+}
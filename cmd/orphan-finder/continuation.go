@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"strings"
+)
+
+// lineJoiner reassembles log lines that the logging infrastructure split into
+// several physical lines. Every physical line but the last of a split line ends
+// with a continuation marker, which is removed along with any indentation at
+// the start of the following line.
+type lineJoiner struct {
+	// marker is the continuation marker. An empty marker disables joining.
+	marker  string
+	pending strings.Builder
+	partial bool
+}
+
+// add adds a physical line, returning the complete logical line and true if
+// the physical line completed one.
+func (j *lineJoiner) add(physical string) (string, bool) {
+	if j.partial {
+		physical = strings.TrimLeft(physical, " \t")
+	}
+	if j.marker != "" && strings.HasSuffix(physical, j.marker) {
+		j.pending.WriteString(strings.TrimSuffix(physical, j.marker))
+		j.partial = true
+		return "", false
+	}
+	if !j.partial {
+		return physical, true
+	}
+	j.pending.WriteString(physical)
+	return j.flush()
+}
+
+// flush returns any partially assembled line and true, or false if there is
+// none. It should be called at the end of the input so that a final line
+// ending with the continuation marker isn't lost.
+func (j *lineJoiner) flush() (string, bool) {
+	if !j.partial {
+		return "", false
+	}
+	line := j.pending.String()
+	j.pending.Reset()
+	j.partial = false
+	return line, true
+}
+
+// joinedLine is a logical log line along with the size of the physical lines,
+// including their newlines, it was joined from.
+type joinedLine struct {
+	line  string
+	bytes int
+}
+
+// joinLogLines splits logData into logical lines, joining the physical lines
+// ending with marker as lineJoiner does.
+func joinLogLines(logData []byte, marker string) []joinedLine {
+	var lines []joinedLine
+	var bytes int
+	joiner := &lineJoiner{marker: marker}
+	for _, physical := range strings.Split(string(logData), "\n") {
+		bytes += len(physical) + 1
+		if line, ok := joiner.add(physical); ok {
+			lines = append(lines, joinedLine{line: line, bytes: bytes})
+			bytes = 0
+		}
+	}
+	if line, ok := joiner.flush(); ok {
+		lines = append(lines, joinedLine{line: line, bytes: bytes})
+	}
+	return lines
+}
+
+// forEachLogLine calls fn with every logical line of the log read from r,
+// joining the physical lines ending with marker as lineJoiner does. Unlike
+// joinLogLines it streams the log, so only the current line is held in memory.
+func forEachLogLine(r io.Reader, marker string, fn func(line string)) error {
+	scanner := newLogScanner(r)
+	joiner := &lineJoiner{marker: marker}
+	for scanner.Scan() {
+		if line, ok := joiner.add(scanner.Text()); ok {
+			fn(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if line, ok := joiner.flush(); ok {
+		fn(line)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// splitLine splits line into the given number of physical lines, each but the
+// last ending with marker and each but the first indented.
+func splitLine(line string, pieces int, marker string) []string {
+	var physical []string
+	size := len(line)/pieces + 1
+	for i := 0; i < len(line); i += size {
+		end := i + size
+		if end > len(line) {
+			end = len(line)
+		}
+		piece := line[i:end]
+		if i > 0 {
+			piece = "  " + piece
+		}
+		if end < len(line) {
+			piece += marker
+		}
+		physical = append(physical, piece)
+	}
+	return physical
+}
+
+func TestLineJoiner(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+
+	for _, pieces := range []int{2, 3} {
+		line := logLine(certOrphan, testCertDER, "1001", "0")
+		if pieces == 3 {
+			line = logLine(precertOrphan, testPreCertDER, "1001", "0")
+		}
+		physical := splitLine(line, pieces, `\`)
+		test.AssertEquals(t, len(physical), pieces)
+
+		// Without joining none of the physical lines is a complete orphan
+		for _, p := range physical {
+			log.Clear()
+			res := rp.storeParsedLogLine(p)
+			test.AssertEquals(t, res.stored, false)
+		}
+
+		j := &lineJoiner{marker: `\`}
+		var joined []string
+		for _, p := range append([]string{"unrelated line"}, physical...) {
+			if l, ok := j.add(p); ok {
+				joined = append(joined, l)
+			}
+		}
+		_, ok := j.flush()
+		test.AssertEquals(t, ok, false)
+		test.AssertDeepEquals(t, joined, []string{"unrelated line", line})
+
+		log.Clear()
+		res := rp.storeParsedLogLine(joined[1])
+		test.AssertEquals(t, res.stored, true)
+		checkNoErrors(t)
+	}
+}
+
+func TestLineJoinerFlush(t *testing.T) {
+	j := &lineJoiner{marker: `\`}
+	_, ok := j.add(`truncated at the end \`)
+	test.AssertEquals(t, ok, false)
+	line, ok := j.flush()
+	test.AssertEquals(t, ok, true)
+	test.AssertEquals(t, line, "truncated at the end ")
+
+	// Without a marker lines are never joined
+	j = &lineJoiner{}
+	line, ok = j.add(`ends with a backslash \`)
+	test.AssertEquals(t, ok, true)
+	test.Assert(t, strings.HasSuffix(line, `\`), "marker was removed")
+}
+
+func TestJoinLogLines(t *testing.T) {
+	logData := "first\nsecond \\\n  half\nthird\n"
+	test.AssertDeepEquals(t, joinLogLines([]byte(logData), `\`), []joinedLine{
+		{line: "first", bytes: 6},
+		{line: "second half", bytes: 16},
+		{line: "third", bytes: 6},
+		{line: "", bytes: 1},
+	})
+}
+
+func TestForEachLogLine(t *testing.T) {
+	logData := "first\nsecond \\\n  half\nthird \\\n"
+	var lines []string
+	err := forEachLogLine(strings.NewReader(logData), `\`, func(line string) {
+		lines = append(lines, line)
+	})
+	test.AssertNotError(t, err, "streaming log")
+	// A split line cut short at the end of the log is still passed on
+	test.AssertDeepEquals(t, lines, []string{"first", "second half", "third "})
+}
+
+func TestReprocessLogReverse(t *testing.T) {
+	logData := []byte(strings.Join([]string{
+		logLine(certOrphan, testCertDER, "1001", "0"),
+		"unrelated line",
+		logLine(precertOrphan, testPreCertDER, "1001", "0"),
+	}, "\n"))
+
+	for _, reverse := range []bool{false, true} {
+		rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+		rp.seen = newSerialCache(false)
+		rp.reverseLines = reverse
+		sum := &summary{}
+		log.Clear()
+		prog := newProgress(log, clock.NewFake(), 0, int64(len(logData)))
+		_ = rp.reprocessLogs(context.Background(), prog, sum, `\`, readerLogInput("ca.log", bytes.NewReader(logData)))
+		test.AssertEquals(t, prog.bytes, int64(len(logData)+1))
+
+		recovered := log.GetAllMatching(`orphan-finder recovered`)
+		test.AssertEquals(t, len(recovered), 2)
+		first, second := "certificate", "precertificate"
+		if reverse {
+			first, second = second, first
+		}
+		test.AssertContains(t, recovered[0], "recovered "+first+":")
+		test.AssertContains(t, recovered[1], "recovered "+second+":")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+)
+
+// orphanCount counts the orphans of a log by type without looking any up or
+// storing them.
+type orphanCount struct {
+	format   *logFormat
+	certs    int
+	precerts int
+	// malformed counts the lines meant to hold an orphan it couldn't be
+	// extracted from, and unparsable those whose DER couldn't be parsed.
+	malformed  int
+	unparsable int
+}
+
+// add counts the orphan in a log line, if there is one, classifying it by its
+// DER like storeOrphan does. Nothing is logged or counted as failed, as the
+// orphans aren't being stored.
+func (c *orphanCount) add(line string) {
+	p, err := c.format.parseOrphanLine(line, 0)
+	if err != nil {
+		c.malformed++
+		return
+	}
+	if p.der == nil {
+		return
+	}
+	cert, err := x509.ParseCertificate(p.der)
+	if err != nil {
+		c.unparsable++
+		return
+	}
+	switch c.format.orphanType(cert) {
+	case certOrphan:
+		c.certs++
+	case precertOrphan:
+		c.precerts++
+	}
+}
+
+// write writes one tab separated row per tally, giving its name and count.
+func (c *orphanCount) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "certificates\t%d\nprecertificates\t%d\nmalformed\t%d\nunparsable\t%d\n",
+		c.certs, c.precerts, c.malformed, c.unparsable)
+	return err
+}
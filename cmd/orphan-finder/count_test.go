@@ -0,0 +1,27 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestOrphanCount(t *testing.T) {
+	count := orphanCount{format: defaultLogFormat()}
+	for _, line := range []string{
+		logLine(certOrphan, testCertDER, "1001", "1"),
+		logLine(precertOrphan, testPreCertDER, "1001", "1"),
+		// Orphans are classified by their DER rather than their label
+		logLine(certOrphan, testPreCertDER, "1002", "2"),
+		logLine(certOrphan, "deadbeef", "1003", "3"),
+		logLine(certOrphan, "zz", "1004", "4"),
+		"unrelated",
+	} {
+		count.add(line)
+	}
+
+	var buf bytes.Buffer
+	test.AssertNotError(t, count.write(&buf), "write failed")
+	test.AssertEquals(t, buf.String(), "certificates\t1\nprecertificates\t2\nmalformed\t1\nunparsable\t1\n")
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	ctClient "github.com/google/certificate-transparency-go/client"
+	"github.com/google/certificate-transparency-go/jsonclient"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/ctpolicy/ctconfig"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// ctAuditConfig configures the CT logs the missing-from-ct command checks the
+// SCTs embedded in orphans against.
+type ctAuditConfig struct {
+	// IssuerCert is the path to the PEM encoded certificate of the issuer of
+	// the orphans, which is needed to reconstruct the precertificate entries
+	// the SCTs were issued for.
+	IssuerCert string
+	// Logs lists the CT logs to check. Every shard of a temporal set is
+	// checked, as the shard an SCT came from is identified by its log ID.
+	Logs []ctconfig.LogDescription
+	// Timeout is the timeout of each request to a log. It defaults to a minute.
+	Timeout cmd.ConfigDuration
+}
+
+// ctLog is a CT log able to tell whether it includes a leaf.
+type ctLog interface {
+	URI() string
+	// Includes returns true if the log includes the leaf with the given hash.
+	Includes(ctx context.Context, leafHash [sha256.Size]byte) (bool, error)
+}
+
+// ctLogClient is a ctLog querying a log's API. The tree size proofs are
+// requested for is taken from the first STH fetched, so leaves merged later in
+// a run aren't found.
+type ctLogClient struct {
+	uri      string
+	client   *ctClient.LogClient
+	once     sync.Once
+	treeSize uint64
+	sthErr   error
+}
+
+func newCTLogClient(uri, b64PK string, timeout time.Duration) (*ctLogClient, error) {
+	uri = strings.TrimSuffix(uri, "/")
+	client, err := ctClient.New(uri, &http.Client{Timeout: timeout}, jsonclient.Options{
+		PublicKey: fmt.Sprintf("-----BEGIN PUBLIC KEY-----\n%s\n-----END PUBLIC KEY-----", b64PK),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("making CT client for %s: %s", uri, err)
+	}
+	return &ctLogClient{uri: uri, client: client}, nil
+}
+
+func (l *ctLogClient) URI() string {
+	return l.uri
+}
+
+func (l *ctLogClient) Includes(ctx context.Context, leafHash [sha256.Size]byte) (bool, error) {
+	l.once.Do(func() {
+		var sth *ct.SignedTreeHead
+		sth, l.sthErr = l.client.GetSTH(ctx)
+		if l.sthErr == nil {
+			l.treeSize = sth.TreeSize
+		}
+	})
+	if l.sthErr != nil {
+		return false, fmt.Errorf("fetching STH of %s: %s", l.uri, l.sthErr)
+	}
+	_, err := l.client.GetProofByHash(ctx, leafHash[:], l.treeSize)
+	if rspErr, ok := err.(jsonclient.RspError); ok &&
+		(rspErr.StatusCode == http.StatusNotFound || rspErr.StatusCode == http.StatusBadRequest) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("fetching proof from %s: %s", l.uri, err)
+	}
+	return true, nil
+}
+
+// ctDiscrepancy describes an SCT embedded in an orphan whose log doesn't
+// include the orphan.
+type ctDiscrepancy struct {
+	Serial string
+	LogID  string
+	LogURI string
+}
+
+// ctAuditor checks that the SCTs embedded in orphans are honored by their logs.
+type ctAuditor struct {
+	logger blog.Logger
+	issuer *ctx509.Certificate
+	// logs holds the logs to check by their log ID.
+	logs map[[sha256.Size]byte]ctLog
+}
+
+// ctLogID returns the log ID of the log with the given base64 encoded DER
+// public key.
+func ctLogID(b64PK string) ([sha256.Size]byte, error) {
+	pk, err := base64.StdEncoding.DecodeString(b64PK)
+	if err != nil {
+		return [sha256.Size]byte{}, fmt.Errorf("invalid log key %q: %s", b64PK, err)
+	}
+	return sha256.Sum256(pk), nil
+}
+
+func newCTAuditor(logger blog.Logger, conf *ctAuditConfig) (*ctAuditor, error) {
+	if conf == nil || conf.IssuerCert == "" || len(conf.Logs) == 0 {
+		return nil, fmt.Errorf("missing-from-ct requires a ctAudit config with an issuerCert and logs")
+	}
+	issuer, err := core.LoadCert(conf.IssuerCert)
+	if err != nil {
+		return nil, fmt.Errorf("loading issuer certificate: %s", err)
+	}
+	ctIssuer, err := ctx509.ParseCertificate(issuer.Raw)
+	if ctx509.IsFatal(err) {
+		return nil, fmt.Errorf("parsing issuer certificate: %s", err)
+	}
+	timeout := conf.Timeout.Duration
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	a := &ctAuditor{
+		logger: logger,
+		issuer: ctIssuer,
+		logs:   make(map[[sha256.Size]byte]ctLog),
+	}
+	add := func(uri, key string) error {
+		id, err := ctLogID(key)
+		if err != nil {
+			return err
+		}
+		client, err := newCTLogClient(uri, key, timeout)
+		if err != nil {
+			return err
+		}
+		a.logs[id] = client
+		return nil
+	}
+	for _, ld := range conf.Logs {
+		shards := []ctconfig.LogShard{{URI: ld.URI, Key: ld.Key}}
+		if ld.TemporalSet != nil {
+			shards = ld.TemporalSet.Shards
+		}
+		for _, shard := range shards {
+			err := add(shard.URI, shard.Key)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return a, nil
+}
+
+// audit checks every SCT embedded in the certificate with the given DER
+// against its log. It returns the number of SCTs checked and the
+// discrepancies found. SCTs from logs that aren't configured are skipped with
+// a warning.
+func (a *ctAuditor) audit(ctx context.Context, der []byte) (int, []ctDiscrepancy, error) {
+	cert, err := ctx509.ParseCertificate(der)
+	if ctx509.IsFatal(err) {
+		return 0, nil, fmt.Errorf("Failed to parse orphan DER: %s", err)
+	}
+	serial := core.SerialToString(cert.SerialNumber)
+	var checked int
+	var discrepancies []ctDiscrepancy
+	for _, serialized := range cert.SCTList.SCTList {
+		var sct ct.SignedCertificateTimestamp
+		_, err := cttls.Unmarshal(serialized.Val, &sct)
+		if err != nil {
+			return checked, discrepancies, fmt.Errorf("Failed to parse SCT embedded in %s: %s", serial, err)
+		}
+		logID := base64.StdEncoding.EncodeToString(sct.LogID.KeyID[:])
+		log, ok := a.logs[sct.LogID.KeyID]
+		if !ok {
+			a.logger.Warningf("Not checking SCT of %s from unconfigured log %s", serial, logID)
+			continue
+		}
+		leaf, err := ct.MerkleTreeLeafForEmbeddedSCT([]*ctx509.Certificate{cert, a.issuer}, sct.Timestamp)
+		if err != nil {
+			return checked, discrepancies, fmt.Errorf("Failed to build CT leaf for %s: %s", serial, err)
+		}
+		leafHash, err := ct.LeafHashForLeaf(leaf)
+		if err != nil {
+			return checked, discrepancies, fmt.Errorf("Failed to hash CT leaf for %s: %s", serial, err)
+		}
+		included, err := log.Includes(ctx, leafHash)
+		if err != nil {
+			return checked, discrepancies, err
+		}
+		checked++
+		if !included {
+			discrepancies = append(discrepancies, ctDiscrepancy{
+				Serial: serial,
+				LogID:  logID,
+				LogURI: log.URI(),
+			})
+		}
+	}
+	return checked, discrepancies, nil
+}
+
+// auditCTLog checks the SCTs embedded in every orphan in the log of the given
+// format read from r, writing the discrepancies found to report and returning
+// their number. Lines are joined as by reprocessLogs.
+func auditCTLog(ctx context.Context, a *ctAuditor, logger blog.Logger, format *logFormat, r io.Reader, continuationMarker string, report func(ctDiscrepancy)) (int, error) {
+	var orphans, scts, missing int
+	auditLine := func(line string) {
+		der, ok := format.orphanDER(line)
+		if !ok {
+			return
+		}
+		checked, discrepancies, err := a.audit(ctx, der)
+		if err != nil {
+			logger.Errf("%s, [%s]", err, line)
+			return
+		}
+		orphans++
+		scts += checked
+		for _, d := range discrepancies {
+			missing++
+			logger.AuditErrf("Orphan %s has an SCT from %s (%s) but isn't included in the log", d.Serial, d.LogURI, d.LogID)
+			report(d)
+		}
+	}
+	if err := forEachLogLine(r, continuationMarker, auditLine); err != nil {
+		return missing, err
+	}
+	logger.Infof("Checked %d SCTs embedded in %d orphans, %d not included in their log", scts, orphans, missing)
+	return missing, nil
+}
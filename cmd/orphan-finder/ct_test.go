@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	ct "github.com/google/certificate-transparency-go"
+	cttls "github.com/google/certificate-transparency-go/tls"
+	ctx509 "github.com/google/certificate-transparency-go/x509"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// fakeCTLog is a ctLog including the leaves in included.
+type fakeCTLog struct {
+	uri      string
+	included map[[sha256.Size]byte]bool
+}
+
+func (l *fakeCTLog) URI() string {
+	return l.uri
+}
+
+func (l *fakeCTLog) Includes(_ context.Context, leafHash [sha256.Size]byte) (bool, error) {
+	return l.included[leafHash], nil
+}
+
+// issueCertWithSCTs issues a certificate signed by issuer embedding an SCT
+// from each of the logs with the given IDs.
+func issueCertWithSCTs(t *testing.T, issuer *x509.Certificate, issuerKey *ecdsa.PrivateKey, logIDs ...[sha256.Size]byte) []byte {
+	var list ctx509.SignedCertificateTimestampList
+	for i, id := range logIDs {
+		sct := ct.SignedCertificateTimestamp{
+			SCTVersion: ct.V1,
+			LogID:      ct.LogID{KeyID: id},
+			Timestamp:  uint64(1000 + i),
+		}
+		val, err := cttls.Marshal(sct)
+		test.AssertNotError(t, err, "failed to marshal SCT")
+		list.SCTList = append(list.SCTList, ctx509.SerializedSCT{Val: val})
+	}
+	listBytes, err := cttls.Marshal(list)
+	test.AssertNotError(t, err, "failed to marshal SCT list")
+	extValue, err := asn1.Marshal(listBytes)
+	test.AssertNotError(t, err, "failed to marshal SCT list extension")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "failed to generate key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1234),
+		Subject:      pkix.Name{CommonName: "sct.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2},
+			Value: extValue,
+		}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, key.Public(), issuerKey)
+	test.AssertNotError(t, err, "failed to create certificate")
+	return der
+}
+
+func TestAuditCTLog(t *testing.T) {
+	issuer, issuerKey := issueTestCert(t, 1, "issuer", true, nil, nil)
+	ctIssuer, err := ctx509.ParseCertificate(issuer.Raw)
+	test.AssertNotError(t, err, "failed to parse issuer")
+
+	honest := [sha256.Size]byte{1}
+	dishonest := [sha256.Size]byte{2}
+	unconfigured := [sha256.Size]byte{3}
+	der := issueCertWithSCTs(t, issuer, issuerKey, honest, dishonest, unconfigured)
+
+	// The honest log includes the precertificate entry its SCT promised
+	cert, err := ctx509.ParseCertificate(der)
+	test.AssertNotError(t, err, "failed to parse certificate")
+	leaf, err := ct.MerkleTreeLeafForEmbeddedSCT([]*ctx509.Certificate{cert, ctIssuer}, 1000)
+	test.AssertNotError(t, err, "failed to build leaf")
+	leafHash, err := ct.LeafHashForLeaf(leaf)
+	test.AssertNotError(t, err, "failed to hash leaf")
+
+	a := &ctAuditor{
+		logger: log,
+		issuer: ctIssuer,
+		logs: map[[sha256.Size]byte]ctLog{
+			honest:    &fakeCTLog{uri: "https://honest.example.com", included: map[[sha256.Size]byte]bool{leafHash: true}},
+			dishonest: &fakeCTLog{uri: "https://dishonest.example.com"},
+		},
+	}
+
+	log.Clear()
+	logData := strings.NewReader("unrelated line\n" + logLine(certOrphan, hex.EncodeToString(der), "1001", "0") + "\n")
+	var reported []ctDiscrepancy
+	missing, err := auditCTLog(context.Background(), a, log, defaultLogFormat(), logData, "", func(d ctDiscrepancy) {
+		reported = append(reported, d)
+	})
+	test.AssertNotError(t, err, "auditing log")
+	test.AssertEquals(t, missing, 1)
+	test.AssertEquals(t, len(reported), 1)
+	test.AssertEquals(t, reported[0].Serial, "0000000000000000000000000000000004d2")
+	test.AssertEquals(t, reported[0].LogURI, "https://dishonest.example.com")
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Orphan 0000000000000000000000000000000004d2 has an SCT from https://dishonest.example.com`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Not checking SCT of .* from unconfigured log")), 1)
+	test.AssertEquals(t, len(log.GetAllMatching("INFO: Checked 2 SCTs embedded in 1 orphans, 1 not included in their log")), 1)
+}
+
+func TestNewCTAuditorConfig(t *testing.T) {
+	_, err := newCTAuditor(log, nil)
+	test.AssertError(t, err, "missing config accepted")
+	_, err = newCTAuditor(log, &ctAuditConfig{IssuerCert: "issuer.pem"})
+	test.AssertError(t, err, "config without logs accepted")
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// derFileExt is the extension of the files parse-der-dir adds.
+const derFileExt = ".der"
+
+// listDERFiles returns the paths of the *.der files under dir, including those
+// in its subdirectories, in lexical order.
+func listDERFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && filepath.Ext(path) == derFileExt {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// dirSource is a derSource yielding the orphans in a list of DER files, all for
+// the same regID. Files are read one at a time as they are asked for, and those
+// that can't be read are logged and skipped rather than ending the run.
+type dirSource struct {
+	rp       *reprocessor
+	paths    []string
+	regID    int64
+	pemChain bool
+}
+
+func (s *dirSource) Next() ([]byte, int64, sourceMeta, error) {
+	for len(s.paths) > 0 {
+		path := s.paths[0]
+		s.paths = s.paths[1:]
+		der, origin, err := readCertFile(s.rp.logger, path, s.pemChain)
+		if err != nil {
+			s.rp.countParseFailure()
+			atomic.AddInt64(&s.rp.failedOrphans, 1)
+			s.rp.logger.Errf("Failed to read orphan DER: %s, [der-file=%s]", err, path)
+			continue
+		}
+		return der, s.regID, sourceMeta{origin: origin}, nil
+	}
+	return nil, 0, sourceMeta{}, io.EOF
+}
+
+// readCertFile returns the DER of the orphan in the file at path, which holds
+// either a PEM encoded certificate, recognised by its leading header, or raw
+// DER, and the origin of the orphan, which tells the two apart. With pemChain
+// the file holds a PEM encoded chain and the DER of its leaf is returned.
+func readCertFile(logger blog.Logger, path string, pemChain bool) ([]byte, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+	if pemChain {
+		der, err := leafFromPEMChain(logger, data)
+		if err != nil {
+			return nil, "", fmt.Errorf("finding leaf in PEM chain: %s", err)
+		}
+		return der, "pem-file=" + path, nil
+	}
+	if !isPEMCertificate(data) {
+		return data, "der-file=" + path, nil
+	}
+	der, err := decodePEMCertificate(data)
+	if err != nil {
+		return nil, "", err
+	}
+	return der, "pem-file=" + path, nil
+}
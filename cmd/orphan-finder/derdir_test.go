@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestDirSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	err = os.Mkdir(filepath.Join(dir, "sub"), 0700)
+	test.AssertNotError(t, err, "creating subdirectory")
+	files := map[string][]byte{
+		"a.der":     certDER,
+		"b.der":     []byte("not a certificate"),
+		"notes.txt": []byte("ignored"),
+		"sub/c.der": precertDER,
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+		test.AssertNotError(t, err, "writing DER file")
+	}
+
+	paths, err := listDERFiles(dir)
+	test.AssertNotError(t, err, "listing DER files")
+	test.AssertDeepEquals(t, paths, []string{
+		filepath.Join(dir, "a.der"),
+		filepath.Join(dir, "b.der"),
+		filepath.Join(dir, "sub/c.der"),
+	})
+	_, err = listDERFiles(filepath.Join(dir, "missing"))
+	test.AssertError(t, err, "listing a missing directory succeeded")
+
+	// The corrupt file is skipped without stopping the run
+	rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+	sum := &summary{}
+	log.Clear()
+	src := &dirSource{rp: rp, paths: paths, regID: 1001}
+	err = rp.processSource(context.Background(), sum, src)
+	test.AssertNotError(t, err, "processing DER files")
+	test.AssertEquals(t, sum.certOrphansAdded, int64(1))
+	test.AssertEquals(t, sum.precertOrphansAdded, int64(1))
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Failed to parse orphan DER: .*, \[der-file=.*b.der\]`)), 1)
+
+	// Files that aren't PEM chains can't be read as one
+	rp = newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+	log.Clear()
+	src = &dirSource{rp: rp, paths: paths[:1], regID: 1001, pemChain: true}
+	err = rp.processSource(context.Background(), &summary{}, src)
+	test.AssertNotError(t, err, "processing DER files as PEM chains")
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Failed to read orphan DER: finding leaf in PEM chain: .*, \[der-file=.*a.der\]`)), 1)
+}
+
+func TestReadCertFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	certDER, _ := hex.DecodeString(testCertDER)
+	files := map[string][]byte{
+		"raw.der": certDER,
+		"cert.pem": append([]byte("\n"), pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: certDER,
+		})...),
+		"csr.pem": pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE REQUEST",
+			Bytes: certDER,
+		}),
+		"broken.pem": []byte(pemCertificateHeader + "\n!!!\n"),
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+		test.AssertNotError(t, err, "writing certificate file")
+	}
+
+	der, origin, err := readCertFile(log, filepath.Join(dir, "raw.der"), false)
+	test.AssertNotError(t, err, "reading DER file")
+	test.AssertByteEquals(t, der, certDER)
+	test.AssertEquals(t, origin, "der-file="+filepath.Join(dir, "raw.der"))
+
+	der, origin, err = readCertFile(log, filepath.Join(dir, "cert.pem"), false)
+	test.AssertNotError(t, err, "reading PEM file")
+	test.AssertByteEquals(t, der, certDER)
+	test.AssertEquals(t, origin, "pem-file="+filepath.Join(dir, "cert.pem"))
+
+	// A file that doesn't start with a certificate header is taken as DER
+	_, origin, err = readCertFile(log, filepath.Join(dir, "csr.pem"), false)
+	test.AssertNotError(t, err, "reading PEM file of another type")
+	test.AssertEquals(t, origin, "der-file="+filepath.Join(dir, "csr.pem"))
+
+	_, _, err = readCertFile(log, filepath.Join(dir, "broken.pem"), false)
+	test.AssertError(t, err, "reading a broken PEM file succeeded")
+	test.AssertContains(t, err.Error(), "decoding PEM certificate")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// prodOverrideEnv is the environment variable that, like the
+// --i-know-this-is-prod flag, allows writes to a production environment.
+const prodOverrideEnv = "ORPHAN_FINDER_I_KNOW_THIS_IS_PROD"
+
+// isProd returns true if env names a production environment.
+func isProd(env string) bool {
+	env = strings.ToLower(env)
+	return env == "prod" || env == "production"
+}
+
+// checkEnvironment refuses to let a command that writes to the DB run against a
+// production environment unless the operator explicitly acknowledged it with
+// the --i-know-this-is-prod flag or the ORPHAN_FINDER_I_KNOW_THIS_IS_PROD
+// environment variable. Read-only commands are always allowed.
+func checkEnvironment(env string, writes bool, override bool) error {
+	if !writes || !isProd(env) {
+		return nil
+	}
+	if override || os.Getenv(prodOverrideEnv) != "" {
+		return nil
+	}
+	return fmt.Errorf("refusing to write to the %q environment without --i-know-this-is-prod or %s set",
+		env, prodOverrideEnv)
+}
+
+// announceEnvironment prints the configured environment to stderr so that it
+// can't be missed.
+func announceEnvironment(env string) {
+	if env == "" {
+		env = "unspecified"
+	}
+	banner := fmt.Sprintf("orphan-finder: configured environment is %q", env)
+	if isProd(env) {
+		banner = "*** " + strings.ToUpper(banner) + " ***"
+	}
+	fmt.Fprintln(os.Stderr, banner)
+}
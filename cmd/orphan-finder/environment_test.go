@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestCheckEnvironment(t *testing.T) {
+	os.Unsetenv(prodOverrideEnv)
+
+	test.AssertNotError(t, checkEnvironment("", true, false), "unspecified environment refused")
+	test.AssertNotError(t, checkEnvironment("staging", true, false), "staging refused")
+	test.AssertNotError(t, checkEnvironment("prod", false, false), "read-only prod run refused")
+	test.AssertError(t, checkEnvironment("prod", true, false), "prod writes allowed without override")
+	test.AssertError(t, checkEnvironment("Production", true, false), "production writes allowed without override")
+	test.AssertNotError(t, checkEnvironment("prod", true, true), "prod writes refused with flag")
+
+	os.Setenv(prodOverrideEnv, "1")
+	defer os.Unsetenv(prodOverrideEnv)
+	test.AssertNotError(t, checkEnvironment("prod", true, false), "prod writes refused with env var")
+}
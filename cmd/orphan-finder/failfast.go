@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+)
+
+// abortOnStoreFailure sets rp.storeAbort to stop the run if rp.failFast is set,
+// after the orphan with the given serial from origin failed to be stored with
+// err.
+func (rp *reprocessor) abortOnStoreFailure(typ orphanType, serial, origin string, err error) {
+	if !rp.failFast {
+		return
+	}
+	rp.abortRun(&rp.storeAbort, fmt.Errorf("storing %s %s failed: %s", typ, serial, err))
+	rp.logger.AuditErrf("Failed to store %s %s with --fail-fast, stopping the run, [%s]", typ, serial, origin)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// unwritableSA is a mockSA failing to add any certificate.
+type unwritableSA struct {
+	mockSA
+	attempts int
+}
+
+func (m *unwritableSA) AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error) {
+	m.attempts++
+	return "", errors.New("disk full")
+}
+
+func TestFailFast(t *testing.T) {
+	logData := logLine(certOrphan, testCertDER, "1001", "1") + "\n" +
+		logLine(precertOrphan, testPreCertDER, "1001", "2") + "\n"
+
+	// By default a failed store doesn't stop the run
+	sa := &unwritableSA{mockSA: mockSA{clk: clock.NewFake()}}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, sa.attempts, 1)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertNotError(t, rp.storeAbort, "run stopped without --fail-fast")
+
+	// With failFast it stops before the next line
+	log.Clear()
+	sa = &unwritableSA{mockSA: mockSA{clk: clock.NewFake()}}
+	rp = newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+	rp.failFast = true
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, sa.attempts, 1)
+	test.AssertEquals(t, len(sa.precertificates), 0)
+	test.AssertError(t, rp.storeAbort, "run not stopped with --fail-fast")
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Failed to store certificate ffa0160630d618b2eb5c0510824b14274856 with --fail-fast, stopping the run`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: Found 1 certificate orphans and added 0 to the database`)), 1)
+}
+
+func TestFailFastFollow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "boulder-ca.log")
+	logData := logLine(certOrphan, testCertDER, "1001", "1") + "\n" +
+		logLine(precertOrphan, testPreCertDER, "1001", "2") + "\n"
+	test.AssertNotError(t, ioutil.WriteFile(path, []byte(logData), 0600), "writing log")
+
+	// A followed log is never closed, so the run must end as soon as it is
+	// stopped rather than wait for more lines
+	sa := &unwritableSA{mockSA: mockSA{clk: clock.NewFake()}}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+	rp.failFast = true
+	stop := make(chan struct{})
+	defer close(stop)
+	input := logInput{name: path, open: func() (io.ReadCloser, error) {
+		return newFollowReader(log, path, stop, time.Millisecond)
+	}}
+	done := make(chan struct{})
+	go func() {
+		_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", input)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("following the log went on after --fail-fast stopped the run")
+	}
+	test.AssertEquals(t, sa.attempts, 1)
+	test.AssertEquals(t, len(sa.precertificates), 0)
+	test.AssertError(t, rp.storeAbort, "run not stopped with --fail-fast")
+}
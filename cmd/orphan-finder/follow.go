@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// followPollInterval is how often a followed log is checked for new lines once
+// its end has been reached.
+const followPollInterval = time.Second
+
+// followReader reads a log file that is still being written, waiting for new
+// lines at its end until stop is closed. A log rotated by renaming it is read
+// to its end before the file now at its path is opened and read from its start,
+// and a log truncated in place is read again from its start. Only complete
+// lines are returned, so that a line being written when the log is rotated or
+// the run is stopped isn't mistaken for a whole one.
+type followReader struct {
+	logger   blog.Logger
+	path     string
+	stop     <-chan struct{}
+	interval time.Duration
+	f        *os.File
+	info     os.FileInfo
+	offset   int64
+	// partial is the start of a line whose end hasn't been written yet, and
+	// ready the complete lines not returned yet.
+	partial []byte
+	ready   []byte
+}
+
+// newFollowReader opens the log at path to be followed until stop is closed.
+func newFollowReader(logger blog.Logger, path string, stop <-chan struct{}, interval time.Duration) (*followReader, error) {
+	r := &followReader{logger: logger, path: path, stop: stop, interval: interval}
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// open opens the file at the log's path and reads it from its start.
+func (r *followReader) open() error {
+	f, err := os.Open(r.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	if r.f != nil {
+		_ = r.f.Close()
+	}
+	r.f, r.info, r.offset = f, info, 0
+	return nil
+}
+
+func (r *followReader) Read(p []byte) (int, error) {
+	for len(r.ready) == 0 {
+		err := r.fill()
+		if err == io.EOF {
+			if len(r.partial) > 0 {
+				r.logger.Warningf("Stopped following %s in the middle of a line, which was skipped", r.path)
+				r.partial = nil
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.ready)
+	r.ready = r.ready[n:]
+	return n, nil
+}
+
+// fill reads the lines written since it was last called, waiting for some if
+// there are none. It returns io.EOF once stop is closed, even if the log has
+// lines left.
+func (r *followReader) fill() error {
+	buf := make([]byte, 64*1024)
+	for {
+		select {
+		case <-r.stop:
+			return io.EOF
+		default:
+		}
+		n, err := r.f.Read(buf)
+		r.offset += int64(n)
+		if n > 0 {
+			data := append(r.partial, buf[:n]...)
+			end := bytes.LastIndexByte(data, '\n') + 1
+			r.ready = append(r.ready, data[:end]...)
+			r.partial = append([]byte(nil), data[end:]...)
+			if end > 0 {
+				return nil
+			}
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// At the end of the file, which may have been rotated or truncated
+		rotated, err := r.checkRotation()
+		if err != nil {
+			return err
+		}
+		if rotated && len(r.ready) > 0 {
+			return nil
+		}
+		if rotated {
+			continue
+		}
+		select {
+		case <-r.stop:
+			return io.EOF
+		case <-time.After(r.interval):
+		}
+	}
+}
+
+// checkRotation reopens the log if another file now is at its path, or seeks
+// back to its start if it was truncated, returning true if it did either. The
+// log missing from its path, as it briefly is while being rotated, isn't an
+// error.
+func (r *followReader) checkRotation() (bool, error) {
+	info, err := os.Stat(r.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(info, r.info) {
+		r.logger.Infof("Log %s was rotated, reading the new file", r.path)
+		// The rotated file ended, and so did its last line
+		if len(r.partial) > 0 {
+			r.ready = append(r.ready, append(r.partial, '\n')...)
+			r.partial = nil
+		}
+		return true, r.open()
+	}
+	if info.Size() < r.offset {
+		r.logger.Infof("Log %s was truncated, reading it from its start", r.path)
+		r.partial = nil
+		_, err = r.f.Seek(0, io.SeekStart)
+		r.offset = 0
+		return true, err
+	}
+	return false, nil
+}
+
+// Close closes the file currently followed.
+func (r *followReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestFollowReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "boulder-ca.log")
+	appendLog := func(data string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		test.AssertNotError(t, err, "opening log")
+		_, err = f.WriteString(data)
+		test.AssertNotError(t, err, "writing log")
+		test.AssertNotError(t, f.Close(), "closing log")
+	}
+	appendLog("one\n")
+
+	stop := make(chan struct{})
+	r, err := newFollowReader(log, path, stop, time.Millisecond)
+	test.AssertNotError(t, err, "opening followed log")
+	defer r.Close()
+	lines := make(chan string)
+	go func() {
+		scanner := newLogScanner(r)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+	next := func() string {
+		select {
+		case line := <-lines:
+			return line
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for a line")
+			return ""
+		}
+	}
+	test.AssertEquals(t, next(), "one")
+
+	// Appended lines are only returned once complete
+	appendLog("tw")
+	appendLog("o\n")
+	test.AssertEquals(t, next(), "two")
+
+	// A rotated log is read to its end, including its unterminated last line
+	appendLog("three\nfour")
+	test.AssertEquals(t, next(), "three")
+	test.AssertNotError(t, os.Rename(path, path+".1"), "rotating log")
+	appendLog("five\n")
+	test.AssertEquals(t, next(), "four")
+	test.AssertEquals(t, next(), "five")
+
+	// A truncated log is read from its start
+	test.AssertNotError(t, os.Truncate(path, 0), "truncating log")
+	appendLog("six\n")
+	test.AssertEquals(t, next(), "six")
+
+	// Stopping ends the log, skipping a line still being written
+	log.Clear()
+	appendLog("seven")
+	// Give the reader time to read it
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	_, ok := <-lines
+	test.Assert(t, !ok, "read a line after stopping")
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Stopped following .* in the middle of a line")), 1)
+}
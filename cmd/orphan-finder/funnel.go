@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync/atomic"
+)
+
+// funnelStage is a step of parsing and storing a log line. Each stage is only
+// reached by lines that reached the one before, so the counts of a run show
+// where lines dropped out.
+type funnelStage int
+
+const (
+	// stageScanned lines were handed to storeParsedLogLine.
+	stageScanned funnelStage = iota
+	// stageMarker lines carry an orphan label.
+	stageMarker
+	// stageCertMatched lines carry a cert=[...] field.
+	stageCertMatched
+	// stageDecoded lines' cert field is valid hex.
+	stageDecoded
+	// stageParsed lines' cert field is a valid certificate.
+	stageParsed
+	// stageChecked orphans were found not to be stored yet.
+	stageChecked
+	// stageStored orphans were stored.
+	stageStored
+	numFunnelStages
+)
+
+var funnelStageNames = [numFunnelStages]string{
+	"scanned",
+	"marker",
+	"cert",
+	"decoded",
+	"parsed",
+	"checked",
+	"stored",
+}
+
+func (s funnelStage) String() string {
+	return funnelStageNames[s]
+}
+
+// parseFunnel counts the lines reaching each funnelStage.
+type parseFunnel struct {
+	counts [numFunnelStages]int64
+	// stats is the orphanMetrics the lines are also counted in, or nil if
+	// metrics aren't exported.
+	stats *orphanMetrics
+}
+
+// reach records that a line reached stage, also counting it in the exported
+// metrics, if any.
+func (f *parseFunnel) reach(stage funnelStage) {
+	atomic.AddInt64(&f.counts[stage], 1)
+	f.stats.funnelReached(stage)
+}
+
+// count returns the number of lines that reached stage.
+func (f *parseFunnel) count(stage funnelStage) int64 {
+	return atomic.LoadInt64(&f.counts[stage])
+}
+
+// String returns the count of every stage, in order.
+func (f *parseFunnel) String() string {
+	stages := make([]string, numFunnelStages)
+	for stage := stageScanned; stage < numFunnelStages; stage++ {
+		stages[stage] = fmt.Sprintf("%s:%d", stage, f.count(stage))
+	}
+	return strings.Join(stages, ",")
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestParseFunnel(t *testing.T) {
+	stats := newOrphanMetrics(prometheus.NewRegistry(), false, "testrun")
+	f := &parseFunnel{stats: stats}
+	test.AssertEquals(t, f.String(), "scanned:0,marker:0,cert:0,decoded:0,parsed:0,checked:0,stored:0")
+	f.reach(stageScanned)
+	f.reach(stageScanned)
+	f.reach(stageMarker)
+	f.reach(stageStored)
+	test.AssertEquals(t, f.count(stageScanned), int64(2))
+	test.AssertEquals(t, f.String(), "scanned:2,marker:1,cert:0,decoded:0,parsed:0,checked:0,stored:1")
+
+	var m dto.Metric
+	err := stats.funnel.WithLabelValues("scanned").Write(&m)
+	test.AssertNotError(t, err, "failed to read counter")
+	test.AssertEquals(t, m.GetCounter().GetValue(), float64(2))
+
+	// Without metrics the funnel still counts
+	f.stats = nil
+	f.reach(stageDecoded)
+	test.AssertEquals(t, f.count(stageDecoded), int64(1))
+}
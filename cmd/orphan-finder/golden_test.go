@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+var update = flag.Bool("update", false, "Regenerate the golden files in testdata")
+
+// checkGolden compares got against the golden file name in testdata, or
+// replaces the golden file with got if -update is passed.
+func checkGolden(t *testing.T, name string, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		err := ioutil.WriteFile(path, []byte(got), 0644)
+		test.AssertNotError(t, err, "failed to update golden file")
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	test.AssertNotError(t, err, "failed to read golden file, run the test with -update to create it")
+	if got != string(want) {
+		t.Errorf("output differs from %s, run the test with -update to regenerate it if the change is intended:\ngot:\n%s\nwant:\n%s",
+			path, got, want)
+	}
+}
+
+func TestReprocessLogGolden(t *testing.T) {
+	logData, err := ioutil.ReadFile("testdata/orphans.log")
+	test.AssertNotError(t, err, "failed to read log fixture")
+
+	fc := clock.NewFake()
+	fc.Set(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	rp := newTestReprocessor(&mockSA{clk: fc}, &mockCA{})
+	rp.seen = newSerialCache(false)
+	rp.runID = "goldenrun"
+	sum := &summary{}
+	log.Clear()
+	prog := newProgress(log, fc, 3, int64(len(logData)))
+	_ = rp.reprocessLogs(context.Background(), prog, sum, `\`, readerLogInput("ca.log", bytes.NewReader(logData)))
+
+	checkGolden(t, "orphans.summary.golden", sum.String()+"\n")
+	checkGolden(t, "orphans.log.golden", strings.Join(log.GetAll(), "\n")+"\n")
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/jmhodges/clock"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// defaultHealthWindow is the time without a line being processed after which
+// the run is reported as stalled, unless --health-window sets another.
+const defaultHealthWindow = 5 * time.Minute
+
+// healthMonitor reports whether a run is still making progress through its
+// input, so that an orchestrator can restart a wedged run.
+type healthMonitor struct {
+	clk    clock.Clock
+	window time.Duration
+	// last is the time the last line was processed, in nanoseconds since the
+	// epoch.
+	last int64
+}
+
+func newHealthMonitor(clk clock.Clock, window time.Duration) *healthMonitor {
+	return &healthMonitor{clk: clk, window: window, last: clk.Now().UnixNano()}
+}
+
+// touch records that a line was processed. It does nothing if h is nil.
+func (h *healthMonitor) touch() {
+	if h == nil {
+		return
+	}
+	atomic.StoreInt64(&h.last, h.clk.Now().UnixNano())
+}
+
+// ServeHTTP responds with 200 if a line was processed within the window and
+// with 503 otherwise.
+func (h *healthMonitor) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	idle := h.clk.Now().Sub(time.Unix(0, atomic.LoadInt64(&h.last)))
+	if idle > h.window {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "stalled: no lines processed for %s\n", idle.Round(time.Second))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serveHealth serves the /healthz endpoint of the run on addr.
+func serveHealth(addr string, logger blog.Logger, clk clock.Clock, window time.Duration) *healthMonitor {
+	h := newHealthMonitor(clk, window)
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", h)
+	go func() {
+		err := http.ListenAndServe(addr, mux)
+		if err != nil {
+			logger.Errf("unable to serve health on %s: %s", addr, err)
+		}
+	}()
+	return h
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestHealthMonitor(t *testing.T) {
+	var nilMonitor *healthMonitor
+	nilMonitor.touch()
+
+	fc := clock.NewFake()
+	h := newHealthMonitor(fc, time.Minute)
+	check := func(status int, body string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+		test.AssertEquals(t, rec.Code, status)
+		test.AssertEquals(t, rec.Body.String(), body)
+	}
+	check(http.StatusOK, "ok\n")
+	fc.Add(time.Minute)
+	check(http.StatusOK, "ok\n")
+	fc.Add(time.Second)
+	check(http.StatusServiceUnavailable, "stalled: no lines processed for 1m1s\n")
+
+	// Reading a log counts as progress
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.health = h
+	src := rp.newLogSource(newProgress(log, fc, 0, 0), &summary{}, strings.NewReader("a\nb"), "")
+	_, _, _, _ = src.Next()
+	check(http.StatusOK, "ok\n")
+}
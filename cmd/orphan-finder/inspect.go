@@ -0,0 +1,37 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// writeInspection writes the fields of cert that matter to orphan-finder to w,
+// one per line, for --inspect, giving typ as its type. The issued date is the
+// one the orphan would be stored with given the backdate.
+func writeInspection(w io.Writer, cert *x509.Certificate, typ orphanType, backdate time.Duration) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fields := []struct {
+		name, value string
+	}{
+		{"serial", core.SerialToString(cert.SerialNumber)},
+		{"type", typ.String()},
+		{"subject", cert.Subject.String()},
+		{"issuer", cert.Issuer.String()},
+		{"names", strings.Join(cert.DNSNames, ", ")},
+		{"notBefore", cert.NotBefore.UTC().Format(time.RFC3339)},
+		{"notAfter", cert.NotAfter.UTC().Format(time.RFC3339)},
+		{"issued", fmt.Sprintf("%s (backdate %s)", issuedDateForCert(cert, backdate).UTC().Format(time.RFC3339), backdate)},
+	}
+	for _, f := range fields {
+		if _, err := fmt.Fprintf(tw, "%s:\t%s\n", f.name, f.value); err != nil {
+			return err
+		}
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestWriteInspection(t *testing.T) {
+	der, _ := hex.DecodeString(testCertDER)
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "parsing certificate failed")
+	var out bytes.Buffer
+	test.AssertNotError(t, writeInspection(&out, cert, certOrphan, time.Hour), "writing inspection failed")
+	test.AssertEquals(t, out.String(), ""+
+		"serial:    ffa0160630d618b2eb5c0510824b14274856\n"+
+		"type:      certificate\n"+
+		"subject:   CN=example.co.bn\n"+
+		"issuer:    CN=happy hacker fake CA\n"+
+		"names:     example.co.bn\n"+
+		"notBefore: 2015-10-03T05:21:00Z\n"+
+		"notAfter:  2016-01-01T05:21:00Z\n"+
+		"issued:    2015-10-03T06:21:00Z (backdate 1h0m0s)\n")
+
+	der, _ = hex.DecodeString(testPreCertDER)
+	cert, err = x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "parsing precertificate failed")
+	out.Reset()
+	test.AssertNotError(t, writeInspection(&out, cert, precertOrphan, 0), "writing inspection failed")
+	test.AssertContains(t, out.String(), "type:      precertificate\n")
+	test.AssertContains(t, out.String(), "names:     junts.io\n")
+}
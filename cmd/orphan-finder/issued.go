@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// storedIssued fetches the orphan with the given serial from the primary SA and
+// returns the issued date it was stored with.
+func storedIssued(ctx context.Context, sa certificateStorage, typ orphanType, serial string) (time.Time, error) {
+	sa = primaryStorage(sa)
+	switch typ {
+	case certOrphan:
+		stored, err := sa.GetCertificate(ctx, serial)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return stored.Issued, nil
+	case precertOrphan:
+		stored, err := sa.GetPrecertificate(ctx, &sapb.Serial{Serial: &serial})
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(0, stored.GetIssued()), nil
+	default:
+		return time.Time{}, fmt.Errorf("unknown orphan type")
+	}
+}
+
+// checkIssued compares the issued date the orphan with the given serial was
+// stored with against the one sent to the SA, counting and returning an error
+// describing any difference. The DB only stores the date to the second, so
+// smaller differences are ignored.
+func (rp *reprocessor) checkIssued(ctx context.Context, typ orphanType, serial string, sent time.Time) error {
+	stored, err := storedIssued(ctx, rp.sa, typ, serial)
+	if err != nil {
+		return fmt.Errorf("Couldn't fetch stored %s %s to verify its issued date: %s", typ, serial, err)
+	}
+	if !stored.Truncate(time.Second).Equal(sent.Truncate(time.Second)) {
+		atomic.AddInt64(&rp.issuedMismatches, 1)
+		return fmt.Errorf("Stored %s %s has issued date %s instead of the %s sent",
+			typ, serial, stored.UTC(), sent.UTC())
+	}
+	return nil
+}
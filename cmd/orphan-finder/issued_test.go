@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// issuedSA is a mockSA that ignores the issued date it's sent, storing the
+// current time of its clock instead.
+type issuedSA struct {
+	mockSA
+}
+
+func (m *issuedSA) AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error) {
+	return m.mockSA.AddCertificate(ctx, &sapb.AddCertificateRequest{Der: req.Der, RegID: req.RegID, Ocsp: req.Ocsp})
+}
+
+func TestParseLineVerifyIssued(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.verifyIssued = true
+
+	// An SA honoring the issued date isn't warned about
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING:")), 0)
+	test.AssertEquals(t, rp.issuedMismatches, int64(0))
+
+	fc := clock.NewFake()
+	fc.Set(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	log.Clear()
+	rp.sa = &issuedSA{mockSA{clk: fc}}
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Stored certificate .* has issued date .* instead of the .* sent")), 1)
+	test.AssertEquals(t, rp.issuedMismatches, int64(1))
+}
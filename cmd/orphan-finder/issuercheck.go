@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// issuerSampleSize is the number of orphans at the start of a log whose
+// issuers are checked against the configured OCSP issuers before processing it.
+const issuerSampleSize = 100
+
+// loadOCSPIssuers loads the PEM encoded issuer certificates at the given paths.
+func loadOCSPIssuers(paths []string) ([]*x509.Certificate, error) {
+	var issuers []*x509.Certificate
+	for _, path := range paths {
+		issuer, err := core.LoadCert(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading OCSP issuer certificate %s: %s", path, err)
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers, nil
+}
+
+// ocspIssuerOf returns true if issuer is the issuer of cert, judging by their
+// DNs and, if both are present, key identifiers. Unlike issues the DNs are
+// compared as parsed, as the encoding of the DN in an issuer's certificate
+// may differ from that in the certificates it issued.
+func ocspIssuerOf(issuer, cert *x509.Certificate) bool {
+	if issuer.Subject.String() != cert.Issuer.String() {
+		return false
+	}
+	if len(issuer.SubjectKeyId) > 0 && len(cert.AuthorityKeyId) > 0 {
+		return bytes.Equal(issuer.SubjectKeyId, cert.AuthorityKeyId)
+	}
+	return true
+}
+
+// checkLogIssuers checks that each of the first sampleSize orphans in the log
+// of the given format read from r was issued by one of issuers, so that a log
+// of orphans the OCSP generator can't sign for is refused before every one of
+// them fails. Lines are joined as by reprocessLogs, and the log is only read
+// until the sample is complete. Orphans whose DER can't be parsed are left to
+// fail while processing the log. It returns an error naming every issuer that
+// isn't configured, and nil if issuers is empty.
+func checkLogIssuers(r io.Reader, continuationMarker string, format *logFormat, issuers []*x509.Certificate, sampleSize int) error {
+	if len(issuers) == 0 {
+		return nil
+	}
+	var sampled, unmatched int
+	unknown := make(map[string]int)
+	check := func(line string) {
+		der, ok := format.orphanDER(line)
+		if !ok || sampled >= sampleSize {
+			return
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return
+		}
+		sampled++
+		for _, issuer := range issuers {
+			if ocspIssuerOf(issuer, cert) {
+				return
+			}
+		}
+		unmatched++
+		unknown[cert.Issuer.String()]++
+	}
+	scanner := newLogScanner(r)
+	joiner := &lineJoiner{marker: continuationMarker}
+	for sampled < sampleSize && scanner.Scan() {
+		if line, ok := joiner.add(scanner.Text()); ok {
+			check(line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading log: %s", err)
+	}
+	if line, ok := joiner.flush(); ok {
+		check(line)
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	var names []string
+	for dn, n := range unknown {
+		names = append(names, fmt.Sprintf("%q (%d orphans)", dn, n))
+	}
+	sort.Strings(names)
+	return fmt.Errorf("%d of the first %d orphans of the log were issued by issuers the OCSP generator isn't configured for: %s",
+		unmatched, sampled, strings.Join(names, ", "))
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestCheckLogIssuers(t *testing.T) {
+	_, err := loadOCSPIssuers([]string{"../../test/does-not-exist.pem"})
+	test.AssertError(t, err, "missing issuer certificate loaded")
+	issuer, err := loadOCSPIssuers([]string{"../../test/test-ca.pem"})
+	test.AssertNotError(t, err, "loading issuer certificate")
+	other, err := loadOCSPIssuers([]string{"../../test/test-ca2.pem"})
+	test.AssertNotError(t, err, "loading issuer certificate")
+
+	logData := []byte(strings.Join([]string{
+		logLine(certOrphan, testCertDER, "1001", "0"),
+		"not an orphan",
+		logLine(certOrphan, testCertDER, "1001", "0"),
+	}, "\n"))
+
+	// Without configured issuers the log isn't checked
+	test.AssertNotError(t, checkLogIssuers(bytes.NewReader(logData), "", defaultLogFormat(), nil, issuerSampleSize), "unconfigured issuers checked")
+
+	// Orphans of a configured issuer pass
+	test.AssertNotError(t, checkLogIssuers(bytes.NewReader(logData), "", defaultLogFormat(), issuer, issuerSampleSize), "orphans of configured issuer refused")
+	test.AssertNotError(t, checkLogIssuers(bytes.NewReader(logData), "", defaultLogFormat(), append(other, issuer...), issuerSampleSize), "orphans of configured issuer refused")
+
+	// Orphans of any other issuer are refused, naming the issuer
+	err = checkLogIssuers(bytes.NewReader(logData), "", defaultLogFormat(), other, issuerSampleSize)
+	test.AssertError(t, err, "orphans of unconfigured issuer accepted")
+	test.AssertContains(t, err.Error(), "2 of the first 2 orphans")
+	test.AssertContains(t, err.Error(), `"CN=happy hacker fake CA" (2 orphans)`)
+
+	// Only the sample is checked
+	err = checkLogIssuers(bytes.NewReader(logData), "", defaultLogFormat(), other, 1)
+	test.AssertContains(t, err.Error(), "1 of the first 1 orphans")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// buildKeyIDSet returns the set of the given key identifiers, which are hex
+// encoded and may be separated into bytes by colons as printed by openssl.
+// It returns nil if there are none.
+func buildKeyIDSet(ids []string) (map[string]bool, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	set := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		normalized := strings.ToLower(strings.Replace(id, ":", "", -1))
+		decoded, err := hex.DecodeString(normalized)
+		if err != nil || len(decoded) == 0 {
+			return nil, fmt.Errorf("invalid key identifier %q", id)
+		}
+		set[normalized] = true
+	}
+	return set, nil
+}
+
+// issuerKeyID returns the hex encoded authority key identifier of cert, which
+// is the subject key identifier of its issuer.
+func issuerKeyID(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.AuthorityKeyId)
+}
+
+// issuerDenied returns true if the issuer of cert is one of
+// rp.deniedIssuerKeyIDs.
+func (rp *reprocessor) issuerDenied(cert *x509.Certificate) bool {
+	return rp.deniedIssuerKeyIDs[issuerKeyID(cert)]
+}
+
+// issuerAllowed returns true if the issuer of cert is one of
+// rp.allowedIssuerKeyIDs, or if none are configured. An orphan without an
+// authority key identifier is only allowed if none are configured.
+func (rp *reprocessor) issuerAllowed(cert *x509.Certificate) bool {
+	return rp.allowedIssuerKeyIDs == nil || rp.allowedIssuerKeyIDs[issuerKeyID(cert)]
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestIssuerKeyIDFilter(t *testing.T) {
+	_, err := buildKeyIDSet([]string{"not hex"})
+	test.AssertError(t, err, "invalid key identifier accepted")
+	set, err := buildKeyIDSet(nil)
+	test.AssertNotError(t, err, "building empty set failed")
+	test.Assert(t, set == nil, "empty set isn't nil")
+
+	// The certificate fixture was issued by the test CA, the precertificate
+	// fixture by Let's Encrypt Authority X3
+	testCAKeyID := "FB:78:4F:12:F9:60:15:83:2C:9F:17:7F:34:19:B3:2E:36:EA:41:89"
+	x3KeyID := "a84a6a63047dddbae6d139b7a64565eff3a8eca1"
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+
+	// A denied issuer's orphans are rejected
+	rp.deniedIssuerKeyIDs, err = buildKeyIDSet([]string{x3KeyID})
+	test.AssertNotError(t, err, "building denied set failed")
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertError(t, res.err, "denied orphan didn't fail")
+	test.AssertEquals(t, rp.deniedIssuerOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(
+		`ERR: \[AUDIT\] Rejecting precertificate 03e1dea6f3349009a90e0306dbb39c3e7ca2 issued by ".*" with denied key identifier `+x3KeyID)), 1)
+
+	// With an allowlist only the listed issuers' orphans are stored
+	rp.deniedIssuerKeyIDs = nil
+	rp.allowedIssuerKeyIDs, err = buildKeyIDSet([]string{testCAKeyID})
+	test.AssertNotError(t, err, "building allowed set failed")
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.unlistedIssuerOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`with key identifier `+x3KeyID+`, which isn't allowed`)), 1)
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.deniedIssuerOrphans, int64(1))
+	test.AssertEquals(t, len(sa.precertificates), 0)
+}
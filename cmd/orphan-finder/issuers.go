@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// issuerKey identifies an issuer by its DN and key identifier.
+type issuerKey struct {
+	dn  string
+	aki string
+}
+
+// issuerTally counts the orphans of a log by issuer.
+type issuerTally struct {
+	format *logFormat
+	counts map[issuerKey]int
+	// unparsable counts the orphans whose DER couldn't be parsed.
+	unparsable int
+}
+
+func newIssuerTally(format *logFormat) *issuerTally {
+	return &issuerTally{format: format, counts: make(map[issuerKey]int)}
+}
+
+// add counts the orphan in a log line, if there is one.
+func (t *issuerTally) add(line string) {
+	der, ok := t.format.orphanDER(line)
+	if !ok {
+		return
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.unparsable++
+		return
+	}
+	t.counts[issuerKey{dn: cert.Issuer.String(), aki: hex.EncodeToString(cert.AuthorityKeyId)}]++
+}
+
+// write writes one tab separated row per issuer, giving the number of orphans,
+// the authority key identifier in hex and the DN, most frequent issuer first.
+// The key identifier is empty for orphans without one.
+func (t *issuerTally) write(w io.Writer) error {
+	keys := make([]issuerKey, 0, len(t.counts))
+	for k := range t.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if t.counts[keys[i]] != t.counts[keys[j]] {
+			return t.counts[keys[i]] > t.counts[keys[j]]
+		}
+		if keys[i].dn != keys[j].dn {
+			return keys[i].dn < keys[j].dn
+		}
+		return keys[i].aki < keys[j].aki
+	})
+	_, err := fmt.Fprintln(w, "orphans\tauthorityKeyID\tissuer")
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		_, err = fmt.Fprintf(w, "%d\t%s\t%s\n", t.counts[k], k.aki, k.dn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestIssuerTally(t *testing.T) {
+	tally := newIssuerTally(defaultLogFormat())
+	for _, line := range []string{
+		logLine(certOrphan, testCertDER, "1001", "1"),
+		logLine(precertOrphan, testPreCertDER, "1001", "1"),
+		logLine(certOrphan, testCertDER, "1002", "2"),
+		logLine(certOrphan, "deadbeef", "1003", "3"),
+		"unrelated",
+	} {
+		tally.add(line)
+	}
+	test.AssertEquals(t, tally.unparsable, 1)
+
+	var buf bytes.Buffer
+	test.AssertNotError(t, tally.write(&buf), "write failed")
+	test.AssertEquals(t, buf.String(), "orphans\tauthorityKeyID\tissuer\n"+
+		"2\tfb784f12f96015832c9f177f3419b32e36ea4189\tCN=happy hacker fake CA\n"+
+		"1\ta84a6a63047dddbae6d139b7a64565eff3a8eca1\tCN=Let's Encrypt Authority X3,O=Let's Encrypt,C=US\n")
+}
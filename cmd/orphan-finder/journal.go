@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// journalctl is the command used to read the systemd journal. It is replaced
+// in tests.
+var journalctl = "journalctl"
+
+// journalArgs returns the journalctl arguments printing just the messages
+// logged by unit between since and until, either of which may be empty to
+// leave that end open. They are given in any format journalctl accepts.
+func journalArgs(unit, since, until string) []string {
+	args := []string{"--unit", unit, "--output", "cat", "--no-pager", "--quiet"}
+	if since != "" {
+		args = append(args, "--since", since)
+	}
+	if until != "" {
+		args = append(args, "--until", until)
+	}
+	return args
+}
+
+// readJournal returns the messages logged by unit between since and until, one
+// per line, as parse-ca-log expects them from a log file.
+func readJournal(unit, since, until string) ([]byte, error) {
+	out, err := exec.Command(journalctl, journalArgs(unit, since, until)...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return nil, fmt.Errorf("%s failed: %s: %s", journalctl, err, exitErr.Stderr)
+		}
+		return nil, fmt.Errorf("%s failed: %s", journalctl, err)
+	}
+	return out, nil
+}
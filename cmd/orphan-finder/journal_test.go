@@ -0,0 +1,33 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestJournalArgs(t *testing.T) {
+	test.AssertDeepEquals(t, journalArgs("boulder-ca", "", ""),
+		[]string{"--unit", "boulder-ca", "--output", "cat", "--no-pager", "--quiet"})
+	test.AssertDeepEquals(t, journalArgs("boulder-ca", "2020-07-01", "1 hour ago"),
+		[]string{"--unit", "boulder-ca", "--output", "cat", "--no-pager", "--quiet",
+			"--since", "2020-07-01", "--until", "1 hour ago"})
+}
+
+func TestReadJournal(t *testing.T) {
+	defer func(cmd string) { journalctl = cmd }(journalctl)
+
+	// echo stands in for journalctl, printing the arguments it was given
+	journalctl = "echo"
+	out, err := readJournal("boulder-ca", "today", "")
+	test.AssertNotError(t, err, "reading journal failed")
+	test.AssertEquals(t, string(out), "--unit boulder-ca --output cat --no-pager --quiet --since today\n")
+
+	journalctl = "false"
+	_, err = readJournal("boulder-ca", "", "")
+	test.AssertError(t, err, "failing journalctl succeeded")
+
+	journalctl = "/nonexistent/journalctl"
+	_, err = readJournal("boulder-ca", "", "")
+	test.AssertError(t, err, "missing journalctl succeeded")
+}
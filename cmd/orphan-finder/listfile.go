@@ -0,0 +1,29 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strings"
+)
+
+// readListFile returns the entries listed one per line in the given file, so
+// that every auxiliary file orphan-finder reads can be annotated by hand the
+// same way. Leading and trailing whitespace is trimmed from each line, and
+// blank lines and lines starting with # are skipped.
+func readListFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestReadListFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "list")
+	test.AssertNotError(t, err, "creating list file")
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("# serials from the 2020-07-01 incident\n" +
+		"abcd\n" +
+		"\n" +
+		"   \t\n" +
+		"  # indented comment\n" +
+		"ef01  \t\n" +
+		"\t0x02 \r\n" +
+		"last")
+	test.AssertNotError(t, err, "writing list file")
+	f.Close()
+
+	entries, err := readListFile(f.Name())
+	test.AssertNotError(t, err, "reading list file failed")
+	test.AssertDeepEquals(t, entries, []string{"abcd", "ef01", "0x02", "last"})
+
+	_, err = readListFile(f.Name() + ".missing")
+	test.AssertError(t, err, "reading a missing list file succeeded")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// gzipMagic are the bytes every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// stdinLogPath is the log path standing for stdin, so that a log can be piped
+// in.
+const stdinLogPath = "-"
+
+// maxLogLineBytes is the size of the longest physical log line that can be
+// read, far longer than any line orphaning a certificate.
+const maxLogLineBytes = 16 << 20
+
+// logReader reads a log file, decompressing it if it's gzipped.
+type logReader struct {
+	io.Reader
+	closers []io.Closer
+	// size is the size of the log, or zero if it isn't known in advance
+	// because the log is gzipped.
+	size int64
+}
+
+// Close closes the decompressor, if any, and the file.
+func (r *logReader) Close() error {
+	var firstErr error
+	for i := len(r.closers) - 1; i >= 0; i-- {
+		err := r.closers[i].Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// openLog opens the log at path, or stdin if path is stdinLogPath, which is
+// left open when the log is closed. Logs are recognised as gzipped by their
+// content rather than by a .gz suffix, as rotation compresses them in place
+// under varying names, and are transparently decompressed.
+func openLog(path string) (*logReader, error) {
+	f := os.Stdin
+	r := &logReader{}
+	if path != stdinLogPath {
+		var err error
+		f, err = os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		r.closers = append(r.closers, f)
+	}
+	buffered := bufio.NewReader(f)
+	magic, _ := buffered.Peek(len(gzipMagic))
+	if !bytes.Equal(magic, gzipMagic) {
+		r.Reader = buffered
+		// A pipe has no size known in advance
+		if info, err := f.Stat(); err == nil && info.Mode().IsRegular() {
+			r.size = info.Size()
+		}
+		return r, nil
+	}
+	gz, err := gzip.NewReader(buffered)
+	if err != nil {
+		_ = r.Close()
+		return nil, err
+	}
+	r.Reader = gz
+	r.closers = append(r.closers, gz)
+	return r, nil
+}
+
+// streamLog calls fn with every logical line of the log at path, decompressed
+// if it's gzipped, as forEachLogLine does.
+func streamLog(path, marker string, fn func(line string)) error {
+	r, err := openLog(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return forEachLogLine(r, marker, fn)
+}
+
+// scanLogLines is a bufio.SplitFunc splitting a log into its physical lines.
+// Unlike bufio.ScanLines it keeps carriage returns, so that lines are read
+// exactly as when the whole log is split at its newlines.
+func scanLogLines(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// newLogScanner returns a scanner reading the physical lines of the log read
+// from r, up to maxLogLineBytes long, without holding more than the current
+// line in memory.
+func newLogScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLogLineBytes)
+	scanner.Split(scanLogLines)
+	return scanner
+}
+
+// logInput is one of the logs processed by a run, opened once its turn comes.
+type logInput struct {
+	name string
+	open func() (io.ReadCloser, error)
+}
+
+// expandLogPaths returns the paths of the log files matched by the glob
+// pattern, such as rotated logs, in sorted order. A pattern without glob
+// metacharacters is returned as is, so that a missing log is reported as such
+// when opening it.
+func expandLogPaths(pattern string) ([]string, error) {
+	if pattern == stdinLogPath || !strings.ContainsAny(pattern, `*?[`) {
+		return []string{pattern}, nil
+	}
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log file pattern %q: %s", pattern, err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no log files match %q", pattern)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// fileLogInputs returns the logInputs reading the logs at paths.
+func fileLogInputs(paths []string) []logInput {
+	inputs := make([]logInput, 0, len(paths))
+	for _, path := range paths {
+		path := path
+		inputs = append(inputs, logInput{
+			name: path,
+			open: func() (io.ReadCloser, error) {
+				return openLog(path)
+			},
+		})
+	}
+	return inputs
+}
+
+// readerLogInput returns the logInput reading the log from r, which can only be
+// opened once.
+func readerLogInput(name string, r io.Reader) logInput {
+	return logInput{name: name, open: func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(r), nil
+	}}
+}
+
+// logsSize returns the total size of the logs at paths, or zero if that of any
+// of them isn't known in advance.
+func logsSize(paths []string) int64 {
+	var total int64
+	for _, path := range paths {
+		if path == stdinLogPath {
+			return 0
+		}
+		r, err := openLog(path)
+		if err != nil {
+			// Reported when processing the log
+			return 0
+		}
+		size := r.size
+		_ = r.Close()
+		if size == 0 {
+			return 0
+		}
+		total += size
+	}
+	return total
+}
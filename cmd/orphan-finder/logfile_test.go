@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestStreamLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	lines := []string{
+		logLine(certOrphan, testCertDER, "1001", "0"),
+		logLine(precertOrphan, testPreCertDER, "1001", "0"),
+	}
+	logData := []byte(strings.Join(lines, "\n") + "\n")
+
+	plain := filepath.Join(dir, "boulder-ca.log")
+	err = ioutil.WriteFile(plain, logData, 0600)
+	test.AssertNotError(t, err, "writing plain log")
+
+	// Rotated logs are gzipped, in several members if appended to
+	var compressed bytes.Buffer
+	for _, half := range [][]byte{logData[:100], logData[100:]} {
+		gz := gzip.NewWriter(&compressed)
+		_, err = gz.Write(half)
+		test.AssertNotError(t, err, "compressing log")
+		test.AssertNotError(t, gz.Close(), "compressing log")
+	}
+	// The name doesn't matter, only the content
+	gzipped := filepath.Join(dir, "boulder-ca.log.1")
+	err = ioutil.WriteFile(gzipped, compressed.Bytes(), 0600)
+	test.AssertNotError(t, err, "writing gzipped log")
+
+	for _, path := range []string{plain, gzipped} {
+		var read []string
+		err := streamLog(path, "", func(line string) {
+			read = append(read, line)
+		})
+		test.AssertNotError(t, err, "reading log")
+		test.AssertDeepEquals(t, read, lines)
+	}
+
+	// A truncated gzipped log is an error rather than a silently short log
+	err = ioutil.WriteFile(gzipped, compressed.Bytes()[:compressed.Len()-10], 0600)
+	test.AssertNotError(t, err, "writing truncated log")
+	err = streamLog(gzipped, "", func(string) {})
+	test.AssertError(t, err, "reading truncated log succeeded")
+
+	err = streamLog(filepath.Join(dir, "missing.log"), "", func(string) {})
+	test.AssertError(t, err, "reading missing log succeeded")
+}
+
+func TestLogScanner(t *testing.T) {
+	scanner := newLogScanner(strings.NewReader("first\r\n\nlast"))
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	test.AssertNotError(t, scanner.Err(), "scanning log")
+	test.AssertDeepEquals(t, lines, []string{"first\r", "", "last"})
+
+	// Lines far longer than the default scanner limit are read whole
+	long := strings.Repeat("ab", 100*1024)
+	scanner = newLogScanner(strings.NewReader(long + "\n"))
+	test.Assert(t, scanner.Scan(), "long line not read")
+	test.AssertEquals(t, scanner.Text(), long)
+}
+
+func TestReadLogStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	test.AssertNotError(t, err, "creating pipe")
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	os.Stdin = r
+	logData := []byte(logLine(certOrphan, testCertDER, "1001", "0") + "\n")
+	go func() {
+		gz := gzip.NewWriter(w)
+		_, _ = gz.Write(logData)
+		_ = gz.Close()
+		_ = w.Close()
+	}()
+
+	in, err := openLog(stdinLogPath)
+	test.AssertNotError(t, err, "opening stdin")
+	test.AssertEquals(t, in.size, int64(0))
+	read, err := ioutil.ReadAll(in)
+	test.AssertNotError(t, err, "reading stdin")
+	test.AssertByteEquals(t, read, logData)
+
+	// Stdin is left open
+	test.AssertNotError(t, in.Close(), "closing log")
+	_, err = r.Stat()
+	test.AssertNotError(t, err, "stdin was closed")
+	test.AssertNotError(t, r.Close(), "stdin was closed")
+}
+
+func TestParseCALogs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	logs := map[string]string{
+		"boulder-ca.log.1": logLine(certOrphan, testCertDER, "1001", "0") + "\n",
+		"boulder-ca.log.2": logLine(precertOrphan, testPreCertDER, "1001", "0") + "\n" +
+			logLine(certOrphan, testCertDER, "1001", "0") + "\n",
+		"other.log": logLine(precertOrphan, testPreCertDER, "1001", "0") + "\n",
+	}
+	for name, data := range logs {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		test.AssertNotError(t, err, "writing log")
+	}
+
+	paths, err := expandLogPaths(filepath.Join(dir, "boulder-ca.log*"))
+	test.AssertNotError(t, err, "expanding log paths")
+	test.AssertDeepEquals(t, paths, []string{
+		filepath.Join(dir, "boulder-ca.log.1"),
+		filepath.Join(dir, "boulder-ca.log.2"),
+	})
+	_, err = expandLogPaths(filepath.Join(dir, "missing*"))
+	test.AssertError(t, err, "expanding a pattern matching nothing succeeded")
+	// A plain path is left to fail when opened
+	paths2, err := expandLogPaths(filepath.Join(dir, "missing.log"))
+	test.AssertNotError(t, err, "expanding a plain path")
+	test.AssertDeepEquals(t, paths2, []string{filepath.Join(dir, "missing.log")})
+	test.AssertEquals(t, logsSize(paths), int64(len(logs["boulder-ca.log.1"])+len(logs["boulder-ca.log.2"])))
+
+	rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+	rp.seen = newSerialCache(false)
+	sum := &summary{}
+	log.Clear()
+	err = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", fileLogInputs(paths)...)
+	test.AssertNotError(t, err, "parsing logs")
+	// The totals are accumulated across the logs, the certificate found in
+	// both only being added once
+	test.AssertEquals(t, sum.String(), "certOrphansFound=2 certOrphansAdded=1 precertOrphansFound=1 precertOrphansAdded=1 duplicatesSkipped=1 funnel="+rp.funnel.String())
+	test.AssertEquals(t, len(log.GetAllMatching(`DEBUG: Processed .*boulder-ca.log.1: certOrphansFound=1 certOrphansAdded=1 precertOrphansFound=0 precertOrphansAdded=0`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`DEBUG: Processed .*boulder-ca.log.2: certOrphansFound=1 certOrphansAdded=0 precertOrphansFound=1 precertOrphansAdded=1`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: Found 2 certificate orphans and added 1 to the database`)), 1)
+
+	// A log that can't be opened fails the run
+	err = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", fileLogInputs(paths2)...)
+	test.AssertError(t, err, "parsing a missing log succeeded")
+}
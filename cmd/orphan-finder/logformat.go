@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+)
+
+// logFormat is how the orphans of a run are recognised: the regexes matching
+// the DER and the regID in a boulder-ca log line, and the extensions marking a
+// certificate as a precertificate.
+type logFormat struct {
+	cert  *regexp.Regexp
+	regID *regexp.Regexp
+	// certToken is a token a log line must contain to be matched against
+	// cert. It is empty when cert is configured, as the line may then name the
+	// DER differently.
+	certToken string
+	// poisonOIDs are the OIDs of the extensions marking a certificate as a
+	// precertificate.
+	poisonOIDs []asn1.ObjectIdentifier
+}
+
+// defaultLogFormat returns the format of the current boulder-ca log, in which
+// only the RFC 6962 poison extension marks a precertificate.
+func defaultLogFormat() *logFormat {
+	return &logFormat{
+		cert:       regexp.MustCompile(`cert=\[([0-9a-f]+)\]`),
+		regID:      regexp.MustCompile(`regID=\[(\d+)\]`),
+		certToken:  "cert=",
+		poisonOIDs: []asn1.ObjectIdentifier{rfc6962PoisonOID},
+	}
+}
+
+// compileLogRegex compiles the pattern of the config field name, which must
+// capture the value it matches in its first group.
+func compileLogRegex(name, pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s %q: %s", name, pattern, err)
+	}
+	if re.NumSubexp() < 1 {
+		return nil, fmt.Errorf("invalid %s %q: it has no capture group", name, pattern)
+	}
+	return re, nil
+}
+
+// newLogFormat returns the default log format with its regexes replaced by
+// the given patterns, unless they are empty, and the given poison OIDs.
+func newLogFormat(certPattern, regIDPattern string, poisonOIDs []asn1.ObjectIdentifier) (*logFormat, error) {
+	f := defaultLogFormat()
+	f.poisonOIDs = poisonOIDs
+	if certPattern != "" {
+		re, err := compileLogRegex("certRegex", certPattern)
+		if err != nil {
+			return nil, err
+		}
+		f.cert = re
+		f.certToken = ""
+	}
+	if regIDPattern != "" {
+		re, err := compileLogRegex("regIDRegex", regIDPattern)
+		if err != nil {
+			return nil, err
+		}
+		f.regID = re
+	}
+	return f, nil
+}
+
+// orphanDER returns the DER of the orphan in a boulder-ca log line, or false
+// if the line doesn't orphan a certificate.
+func (f *logFormat) orphanDER(line string) ([]byte, bool) {
+	if orphanTypeForLabel(line) == unknownOrphan {
+		return nil, false
+	}
+	derStr := f.cert.FindStringSubmatch(line)
+	if len(derStr) <= 1 {
+		return nil, false
+	}
+	der, err := hex.DecodeString(derStr[1])
+	if err != nil {
+		return nil, false
+	}
+	return der, true
+}
+
+// orphanType returns precertOrphan if the certificate has a CT poison
+// extension, the RFC 6962 one or any other in poisonOIDs, or certOrphan if it
+// does not. If the certificate is nil unknownOrphan is returned.
+func (f *logFormat) orphanType(cert *x509.Certificate) orphanType {
+	if cert == nil {
+		return unknownOrphan
+	}
+	for _, ext := range cert.Extensions {
+		for _, poison := range f.poisonOIDs {
+			if ext.Id.Equal(poison) {
+				return precertOrphan
+			}
+		}
+	}
+	return certOrphan
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestNewLogFormat(t *testing.T) {
+	_, err := newLogFormat(`der=\[([0-9a-f]+`, "", nil)
+	test.AssertError(t, err, "invalid pattern accepted")
+	_, err = newLogFormat("", `account=\d+`, nil)
+	test.AssertError(t, err, "pattern without a capture group accepted")
+
+	// Empty patterns leave the defaults in place
+	f, err := newLogFormat("", "", nil)
+	test.AssertNotError(t, err, "empty patterns rejected")
+	test.AssertEquals(t, f.certToken, "cert=")
+
+	f, err = newLogFormat(`der=([0-9a-f]+)`, `account=(\d+)`, nil)
+	test.AssertNotError(t, err, "valid patterns rejected")
+	test.AssertEquals(t, f.certToken, "")
+	sa := &mockSA{}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.format = f
+	log.Clear()
+	line := fmt.Sprintf("[AUDIT] Failed RPC to store at SA, orphaning certificate: der=%s account=1001", testCertDER)
+	res := rp.storeParsedLogLine(line)
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, sa.certificates[0].RegistrationID, int64(1001))
+}
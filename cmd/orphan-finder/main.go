@@ -3,16 +3,11 @@ package main
 import (
 	"context"
 	"crypto/x509"
-	"encoding/asn1"
-	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -24,8 +19,8 @@ import (
 	"github.com/letsencrypt/boulder/features"
 	bgrpc "github.com/letsencrypt/boulder/grpc"
 	blog "github.com/letsencrypt/boulder/log"
-	"github.com/letsencrypt/boulder/metrics"
 	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
 )
 
@@ -34,28 +29,114 @@ name:
   orphan-finder - Reads orphaned certificates from a boulder-ca log or a der file and adds them to the database
 
 usage:
-  orphan-finder parse-ca-log --config <path> --log-file <path>
-  orphan-finder parse-der --config <path> --der-file <path> --regID <registration-id>
+  orphan-finder parse-ca-log --config <path> [--log-file <path>]
+  orphan-finder parse-journal --config <path> [--unit boulder-ca] [--since <time>] [--until <time>]
+  orphan-finder parse-der --config <path> --der-file <path> [--der-file <path> ...] --regID <registration-id>
+  orphan-finder parse-der-dir --config <path> --der-dir <path> --regID <registration-id>
+  orphan-finder reconcile --config <path> --log-file <path>
+  orphan-finder missing-from-ct --config <path> --log-file <path>
+  orphan-finder find-missing-ocsp --config <path> [--log-file <path>] [--serials <serial,...>] [--fix]
+  orphan-finder backfill-ocsp --config <path> --serials-file <path>
+  orphan-finder orphan-rate --log-file <path> [--bucket <duration>]
+  orphan-finder issuers --log-file <path>
+  orphan-finder count --config <path> --log-file <path>
 
 command descriptions:
-  parse-ca-log    Parses boulder-ca logs to add multiple orphaned certificates
-  parse-der       Parses a single orphaned DER certificate file and adds it to the database
+  parse-ca-log      Parses boulder-ca logs to add multiple orphaned certificates
+  parse-journal     Like parse-ca-log, but reads the journal of a systemd unit
+  parse-der         Parses orphaned DER certificate files and adds them to the database
+  parse-der-dir     Like parse-der, but adds every *.der file in a directory
+  reconcile         Prints the orphans of a boulder-ca log missing from the database
+  missing-from-ct   Prints the orphans of a boulder-ca log missing from the CT logs of their SCTs
+  find-missing-ocsp Prints the stored orphans of a boulder-ca log without an OCSP response
+  backfill-ocsp     Regenerates and stores the OCSP responses of the stored serials given
+  orphan-rate       Counts the orphans of a boulder-ca log per bucket of time as CSV
+  issuers           Lists the issuers of the orphans of a boulder-ca log
+  count             Counts the orphans of a boulder-ca log by type
 `
 
 type config struct {
-	TLS                  cmd.TLSConfig
-	SAService            *cmd.GRPCClientConfig
+	TLS       cmd.TLSConfig
+	SAService *cmd.GRPCClientConfig
+	// SAReadService optionally configures a read replica SA used to check
+	// whether orphans already exist, in which case SAService is only used for
+	// writes.
+	SAReadService        *cmd.GRPCClientConfig
 	OCSPGeneratorService *cmd.GRPCClientConfig
 	Syslog               cmd.SyslogConfig
 	// Backdate specifies how to adjust a certificate's NotBefore date to get back
 	// to the original issued date. It should match the value used in
 	// `test/config/ca.json` for the CA "backdate" value.
 	Backdate cmd.ConfigDuration
-	Features map[string]bool
+	// CAConfig optionally is the path to the boulder-ca config of the CA
+	// orphans were issued by. If it's set the CA's backdate is taken from it,
+	// with a warning if Backdate disagrees. Backdate is used if it can't be
+	// loaded.
+	CAConfig string
+	// MaxOrphanAge optionally bounds how far in the past an orphan's issued
+	// date may be. An older one more likely comes from a corrupted NotBefore
+	// than from a real orphan, so it is reported with an audit message and
+	// skipped with --strict-age.
+	MaxOrphanAge cmd.ConfigDuration
+	Features     map[string]bool
+	// Environment names the environment the config is for, e.g. "prod" or
+	// "staging". Commands writing to the DB refuse to run against "prod" unless
+	// explicitly overridden.
+	Environment string
+	// DebugAddr optionally names the address to export the metrics of the run
+	// on while it is in progress.
+	DebugAddr string
+	// PushGateway optionally is the URL of a Prometheus Pushgateway the metrics
+	// of the run are pushed to once it is done, as a run may end before it is
+	// scraped.
+	PushGateway string
+	// DowngradeAuditErrors lists categories of expected errors about malformed
+	// log lines to log as warnings rather than audit errors: unmatched-cert,
+	// bad-hex, missing-regid, bad-regid, invalid-regid and bad-status.
+	DowngradeAuditErrors []string
+	// CTAudit configures the CT logs checked by the missing-from-ct command.
+	CTAudit *ctAuditConfig
+	// AllowedSigAlgs optionally lists the signature algorithms orphans may be
+	// signed with, named as by crypto/x509, e.g. SHA256-RSA. Orphans signed
+	// with any other are skipped. Any algorithm is allowed if it's empty.
+	AllowedSigAlgs []string
+	// ExtraPoisonOIDs optionally lists, in dotted decimal notation, the OIDs
+	// of extensions besides the RFC 6962 CT poison that mark an orphan as a
+	// precertificate, e.g. the pre-standard marker of historical ones.
+	ExtraPoisonOIDs []string
+	// OCSPIssuerCerts optionally lists the paths to the PEM encoded
+	// certificates of the issuers OCSPGeneratorService signs responses for.
+	// If it's set parse-ca-log and parse-journal refuse a log whose first
+	// orphans were issued by any other issuer, unless run with --force.
+	OCSPIssuerCerts []string
+	// TrustedIssuerCerts optionally lists the paths to the PEM encoded
+	// certificates of the issuers orphans may be signed by. Orphans whose
+	// signature doesn't validate against any of them are rejected, so that a
+	// log from another environment can't fill the DB with foreign orphans.
+	// Orphans from any issuer are stored if it's empty.
+	TrustedIssuerCerts []string
+	// AllowedIssuerKeyIDs and DeniedIssuerKeyIDs optionally list the hex
+	// encoded subject key identifiers of issuers, as found in the authority
+	// key identifier of the orphans they issued. Orphans of a denied issuer,
+	// and if AllowedIssuerKeyIDs is set of any issuer it doesn't list, are
+	// rejected. Unlike TrustedIssuerCerts no issuer certificate is needed.
+	AllowedIssuerKeyIDs []string
+	DeniedIssuerKeyIDs  []string
+	// CertRegex and RegIDRegex optionally replace the regular expressions
+	// matching the hex encoded DER and the regID in a boulder-ca log line, so
+	// that logs of an older format can be processed. The first group of each
+	// captures the value. They default to cert=\[([0-9a-f]+)\] and
+	// regID=\[(\d+)\].
+	CertRegex  string
+	RegIDRegex string
+	// GRPCTimeout optionally bounds each RPC made to the SA and CA while
+	// looking up, generating OCSP for and storing an orphan. An orphan whose
+	// RPC times out is counted as failed and the run goes on with the next.
+	GRPCTimeout cmd.ConfigDuration
 }
 
 type certificateStorage interface {
-	AddCertificate(context.Context, []byte, int64, []byte, *time.Time) (string, error)
+	AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error)
 	AddPrecertificate(ctx context.Context, req *sapb.AddCertificateRequest) (*corepb.Empty, error)
 	GetCertificate(ctx context.Context, serial string) (core.Certificate, error)
 	GetPrecertificate(ctx context.Context, reqSerial *sapb.Serial) (*corepb.Certificate, error)
@@ -91,29 +172,27 @@ func (t orphanType) String() string {
 	}
 }
 
-var (
-	derOrphan        = regexp.MustCompile(`cert=\[([0-9a-f]+)\]`)
-	regOrphan        = regexp.MustCompile(`regID=\[(\d+)\]`)
-	errAlreadyExists = fmt.Errorf("Certificate already exists in DB")
-)
+var errAlreadyExists = fmt.Errorf("Certificate already exists in DB")
 
-var backdateDuration time.Duration
+// issuedDateForCert returns the issued date of cert, its NotBefore plus the
+// backdate the CA subtracted from the time it was issued. The SA would
+// otherwise tag an orphan with the time it is stored rather than the time it
+// was issued.
+func issuedDateForCert(cert *x509.Certificate, backdate time.Duration) time.Time {
+	return cert.NotBefore.Add(backdate)
+}
 
-// orphanTypeForCert returns precertOrphan if the certificate has the RFC 6962
-// CT poison extension, or certOrphan if it does not. If the certificate is nil
-// unknownOrphan is returned.
-func orphanTypeForCert(cert *x509.Certificate) orphanType {
-	if cert == nil {
+// orphanTypeForLabel returns the orphanType named by the "orphaning ..." label
+// in a log line, or unknownOrphan if the line has no such label.
+func orphanTypeForLabel(line string) orphanType {
+	switch {
+	case strings.Contains(line, fmt.Sprintf("orphaning %s", certOrphan)):
+		return certOrphan
+	case strings.Contains(line, fmt.Sprintf("orphaning %s", precertOrphan)):
+		return precertOrphan
+	default:
 		return unknownOrphan
 	}
-	// RFC 6962 Section 3.1 - https://tools.ietf.org/html/rfc6962#section-3.1
-	poisonExt := asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
-	for _, ext := range cert.Extensions {
-		if ext.Id.Equal(poisonExt) {
-			return precertOrphan
-		}
-	}
-	return certOrphan
 }
 
 // checkDER parses the provided DER bytes and uses the resulting certificate's
@@ -121,24 +200,33 @@ func orphanTypeForCert(cert *x509.Certificate) orphanType {
 // provided DER. If there is a matching precert/cert serial then
 // errAlreadyExists and the orphanType are returned. If there is no matching
 // precert/cert serial then the parsed certificate and orphanType are returned.
-func checkDER(sai certificateStorage, der []byte) (*x509.Certificate, orphanType, error) {
-	ctx := context.Background()
+func (rp *reprocessor) checkDER(ctx context.Context, sai certificateStorage, der []byte) (*x509.Certificate, orphanType, error) {
 	orphan, err := x509.ParseCertificate(der)
 	if err != nil {
 		return nil, unknownOrphan, fmt.Errorf("Failed to parse orphan DER: %s", err)
 	}
+	return rp.checkCert(ctx, sai, orphan)
+}
+
+// checkCert uses the provided certificate's serial to check if there is an
+// existing precertificate or certificate for it, with the same results as
+// checkDER. If compareDER is set an existing precertificate or certificate
+// with different content results in errContentConflict instead of
+// errAlreadyExists.
+func (rp *reprocessor) checkCert(ctx context.Context, sai certificateStorage, orphan *x509.Certificate) (*x509.Certificate, orphanType, error) {
 	orphanSerial := core.SerialToString(orphan.SerialNumber)
-	orphanTyp := orphanTypeForCert(orphan)
+	orphanTyp := rp.format.orphanType(orphan)
 
-	switch orphanTyp {
-	case certOrphan:
-		_, err = sai.GetCertificate(ctx, orphanSerial)
-	case precertOrphan:
-		_, err = sai.GetPrecertificate(ctx, &sapb.Serial{Serial: &orphanSerial})
-	default:
-		err = errors.New("unknown orphan type")
+	storedDER, err := rp.lookupStored(ctx, sai, orphanTyp, orphanSerial)
+	// Serials issued before they were lengthened may be stored in their
+	// shorter legacy form
+	if legacySerial, ok := legacySerialToString(orphan.SerialNumber); ok && berrors.Is(err, berrors.NotFound) {
+		storedDER, err = rp.lookupStored(ctx, sai, orphanTyp, legacySerial)
 	}
 	if err == nil {
+		if rp.compareDER {
+			return nil, orphanTyp, compareStoredDER(orphan, storedDER)
+		}
 		return nil, orphanTyp, errAlreadyExists
 	}
 	if berrors.Is(err, berrors.NotFound) {
@@ -147,106 +235,34 @@ func checkDER(sai certificateStorage, der []byte) (*x509.Certificate, orphanType
 	return nil, orphanTyp, fmt.Errorf("Existing %s lookup failed: %s", orphanTyp, err)
 }
 
-// storeParsedLogLine attempts to parse one log line according to the format used when
-// orphaning certificates and precertificates. It returns two booleans and the
-// orphanType: The first boolean is true if the line was a match, and the second
-// is true if the orphan was successfully added to the DB. As part of adding an
-// orphan to the DB, it requests a fresh OCSP response from the CA to store
-// alongside the precertificate/certificate.
-func storeParsedLogLine(sa certificateStorage, ca ocspGenerator, logger blog.Logger, line string) (found bool, added bool, typ orphanType) {
-	ctx := context.Background()
-
-	// The log line should contain a label indicating it is a cert or a precert
-	// orphan. We will determine which it is in checkDER based on the DER instead
-	// of the log line label.
-	if !strings.Contains(line, fmt.Sprintf("orphaning %s", certOrphan)) &&
-		!strings.Contains(line, fmt.Sprintf("orphaning %s", precertOrphan)) {
-		return false, false, unknownOrphan
-	}
-	// The log line should also contain certificate DER
-	if !strings.Contains(line, "cert=") {
-		return false, false, unknownOrphan
-	}
-	// Extract and decode the orphan DER
-	derStr := derOrphan.FindStringSubmatch(line)
-	if len(derStr) <= 1 {
-		logger.AuditErrf("Didn't match regex for cert: %s", line)
-		return true, false, unknownOrphan
-	}
-	der, err := hex.DecodeString(derStr[1])
-	if err != nil {
-		logger.AuditErrf("Couldn't decode hex: %s, [%s]", err, line)
-		return true, false, unknownOrphan
-	}
-	// Parse the DER, determine the orphan type, and ensure it doesn't already
-	// exist in the DB
-	cert, typ, err := checkDER(sa, der)
-	if err != nil {
-		logFunc := logger.Errf
-		if err == errAlreadyExists {
-			logFunc = logger.Infof
+// lookupStored returns the DER of the stored precertificate or certificate,
+// depending on typ, with the given serial.
+func (rp *reprocessor) lookupStored(ctx context.Context, sai certificateStorage, typ orphanType, serial string) ([]byte, error) {
+	var der []byte
+	err := rp.callRPC(ctx, func(ctx context.Context) error {
+		switch typ {
+		case certOrphan:
+			stored, err := sai.GetCertificate(ctx, serial)
+			der = stored.DER
+			return err
+		case precertOrphan:
+			stored, err := sai.GetPrecertificate(ctx, &sapb.Serial{Serial: &serial})
+			if err != nil {
+				return err
+			}
+			der = stored.Der
+			return nil
+		default:
+			return errors.New("unknown orphan type")
 		}
-		logFunc("%s, [%s]", err, line)
-		return true, false, typ
-	}
-	// extract the regID
-	regStr := regOrphan.FindStringSubmatch(line)
-	if len(regStr) <= 1 {
-		logger.AuditErrf("regID variable is empty, [%s]", line)
-		return true, false, typ
-	}
-	regID, err := strconv.ParseInt(regStr[1], 10, 64)
-	if err != nil {
-		logger.AuditErrf("Couldn't parse regID: %s, [%s]", err, line)
-		return true, false, typ
-	}
-	response, err := generateOCSP(ctx, ca, der)
-	if err != nil {
-		logger.AuditErrf("Couldn't generate OCSP: %s, [%s]", err, line)
-		return true, false, typ
-	}
-	// We use `cert.NotBefore` as the issued date to avoid the SA tagging this
-	// certificate with an issued date of the current time when we know it was an
-	// orphan issued in the past. Because certificates are backdated we need to
-	// add the backdate duration to find the true issued time.
-	issuedDate := cert.NotBefore.Add(backdateDuration)
-	switch typ {
-	case certOrphan:
-		_, err = sa.AddCertificate(ctx, der, regID, response, &issuedDate)
-	case precertOrphan:
-		issued := issuedDate.UnixNano()
-		_, err = sa.AddPrecertificate(ctx, &sapb.AddCertificateRequest{
-			Der:    der,
-			RegID:  &regID,
-			Ocsp:   response,
-			Issued: &issued,
-		})
-	default:
-		// Shouldn't happen but be defensive anyway
-		err = errors.New("unknown orphan type")
-	}
-	if err != nil {
-		logger.AuditErrf("Failed to store certificate: %s, [%s]", err, line)
-		return true, false, typ
-	}
-	return true, true, typ
-}
-
-func generateOCSP(ctx context.Context, ca ocspGenerator, certDER []byte) ([]byte, error) {
-	// generate a fresh OCSP response
-	ocspResponse, err := ca.GenerateOCSP(ctx, &capb.GenerateOCSPRequest{
-		CertDER:   certDER,
-		Status:    string(core.OCSPStatusGood),
-		Reason:    0,
-		RevokedAt: 0,
 	})
-	if err != nil {
-		return nil, err
-	}
-	return ocspResponse.Response, nil
+	return der, err
 }
 
-func setup(configFile string) (blog.Logger, core.StorageAuthority, capb.OCSPGeneratorClient) {
+// loadConfig reads the config file, sets the feature flags it enables and
+// constructs the logger it configures, tagging its syslog entries with
+// syslogTag.
+func loadConfig(configFile, syslogTag, runID string) (config, blog.Logger) {
 	configJSON, err := ioutil.ReadFile(configFile)
 	cmd.FailOnError(err, "Failed to read config file")
 	var conf config
@@ -254,120 +270,125 @@ func setup(configFile string) (blog.Logger, core.StorageAuthority, capb.OCSPGene
 	cmd.FailOnError(err, "Failed to parse config file")
 	err = features.Set(conf.Features)
 	cmd.FailOnError(err, "Failed to set feature flags")
-	logger := cmd.NewLogger(conf.Syslog)
+	logger := cmd.NewLoggerWithTag(conf.Syslog, expandSyslogTag(syslogTag, runID))
+	return conf, logger
+}
+
+// setup configures the run like setupOffline and dials the SA and CA the config
+// names, returning clients for them.
+func setup(opts *options) (blog.Logger, certificateStorage, capb.OCSPGeneratorClient) {
+	rp := opts.rp
+	conf, logger, registerer := setupOffline(opts)
+	err := checkClientConfig(conf)
+	cmd.FailOnError(err, "Incomplete config")
+	err = checkBackdate(logger, os.Stderr, rp.backdateDuration, opts.strictBackdate)
+	cmd.FailOnError(err, "Refusing to run with --strict-backdate")
+	sac, cac := dialServices(conf, logger, registerer, opts.waitForServices)
+	if rp.skipOCSP {
+		logger.Warningf("Skipping OCSP generation, orphans are stored without an OCSP response that has to be backfilled")
+	}
+	waitStartJitter(logger, opts.startJitter, rp.analysisOnly)
+	return logger, sac, cac
+}
 
+// setupOffline reads the config and sets up everything it configures apart
+// from the SA and CA clients, so that commands that never talk to either don't
+// need their credentials. The options read from the config are set. It returns
+// the registerer for the clients' metrics.
+func setupOffline(opts *options) (config, blog.Logger, prometheus.Registerer) {
+	rp := opts.rp
+	conf, logger := loadConfig(opts.configFile, opts.syslogTag, rp.runID)
+	var registerer prometheus.Registerer
+	rp.stats, registerer = setupMetrics(conf.DebugAddr, conf.PushGateway, logger, opts.emitExemplars, rp.runID)
+	if opts.healthAddr != "" {
+		rp.health = serveHealth(opts.healthAddr, logger, cmd.Clock(), opts.healthWindow)
+	}
+
+	var err error
+	rp.downgradedAuditErrors, err = buildDowngradedAuditErrors(conf.DowngradeAuditErrors)
+	cmd.FailOnError(err, "Invalid downgradeAuditErrors")
+	rp.allowedSigAlgs, err = buildAllowedSigAlgs(conf.AllowedSigAlgs)
+	cmd.FailOnError(err, "Invalid allowedSigAlgs")
+	poisonOIDs, err := buildPoisonOIDs(conf.ExtraPoisonOIDs)
+	cmd.FailOnError(err, "Invalid extraPoisonOIDs")
+	opts.ocspIssuers, err = loadOCSPIssuers(conf.OCSPIssuerCerts)
+	cmd.FailOnError(err, "Invalid ocspIssuerCerts")
+	rp.trustedIssuers, err = loadTrustedIssuers(conf.TrustedIssuerCerts)
+	cmd.FailOnError(err, "Invalid trustedIssuerCerts")
+	rp.allowedIssuerKeyIDs, err = buildKeyIDSet(conf.AllowedIssuerKeyIDs)
+	cmd.FailOnError(err, "Invalid allowedIssuerKeyIDs")
+	rp.deniedIssuerKeyIDs, err = buildKeyIDSet(conf.DeniedIssuerKeyIDs)
+	cmd.FailOnError(err, "Invalid deniedIssuerKeyIDs")
+	rp.format, err = newLogFormat(conf.CertRegex, conf.RegIDRegex, poisonOIDs)
+	cmd.FailOnError(err, "Invalid log line regex")
+	rp.backdateDuration = resolveBackdate(logger, conf.Backdate.Duration, conf.CAConfig)
+	rp.maxOrphanAge = conf.MaxOrphanAge.Duration
+	rp.rpcTimeout = conf.GRPCTimeout.Duration
+	opts.environment = conf.Environment
+	announceEnvironment(opts.environment)
+	logger.Infof("Configured environment is %q", opts.environment)
+	auditInvocation(logger, rp.runID, opts.configFile, conf)
+	return conf, logger, registerer
+}
+
+// dialServices dials the SA, and its read replica if configured, and the CA,
+// waiting up to wait for them to be ready if it is positive.
+func dialServices(conf config, logger blog.Logger, registerer prometheus.Registerer, wait time.Duration) (certificateStorage, capb.OCSPGeneratorClient) {
 	tlsConfig, err := conf.TLS.Load()
 	cmd.FailOnError(err, "TLS config")
 
-	clientMetrics := bgrpc.NewClientMetrics(metrics.NoopRegisterer)
+	clientMetrics := bgrpc.NewClientMetrics(registerer)
 	saConn, err := bgrpc.ClientSetup(conf.SAService, tlsConfig, clientMetrics, cmd.Clock())
 	cmd.FailOnError(err, "Failed to load credentials and create gRPC connection to SA")
-	sac := bgrpc.NewStorageAuthorityClient(sapb.NewStorageAuthorityClient(saConn))
+	var sac certificateStorage = saClient{bgrpc.NewStorageAuthorityClient(sapb.NewStorageAuthorityClient(saConn))}
+	var saReadConn *grpc.ClientConn
+	if conf.SAReadService != nil {
+		saReadConn, err = bgrpc.ClientSetup(conf.SAReadService, tlsConfig, clientMetrics, cmd.Clock())
+		cmd.FailOnError(err, "Failed to load credentials and create gRPC connection to read replica SA")
+		sac = splitStorage{
+			certificateStorage: sac,
+			reader:             saClient{bgrpc.NewStorageAuthorityClient(sapb.NewStorageAuthorityClient(saReadConn))},
+		}
+	}
 
 	caConn, err := bgrpc.ClientSetup(conf.OCSPGeneratorService, tlsConfig, clientMetrics, cmd.Clock())
 	cmd.FailOnError(err, "Failed to load credentials and create gRPC connection to CA")
 	cac := capb.NewOCSPGeneratorClient(caConn)
 
-	backdateDuration = conf.Backdate.Duration
-	return logger, sac, cac
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), wait)
+		defer cancel()
+		err = waitForReady(ctx, logger, "SA", saConn)
+		cmd.FailOnError(err, "Failed to connect to SA")
+		if saReadConn != nil {
+			err = waitForReady(ctx, logger, "read replica SA", saReadConn)
+			cmd.FailOnError(err, "Failed to connect to read replica SA")
+		}
+		err = waitForReady(ctx, logger, "CA", caConn)
+		cmd.FailOnError(err, "Failed to connect to CA")
+	}
+	return sac, cac
 }
 
 func main() {
+	start := time.Now()
 	if len(os.Args) <= 2 {
 		fmt.Fprint(os.Stderr, usageString)
 		os.Exit(1)
 	}
 
-	command := os.Args[1]
-	flagSet := flag.NewFlagSet(command, flag.ContinueOnError)
-	configFile := flagSet.String("config", "", "File path to the configuration file for this service")
-	logPath := flagSet.String("log-file", "", "Path to boulder-ca log file to parse")
-	derPath := flagSet.String("der-file", "", "Path to DER certificate file")
-	regID := flagSet.Int64("regID", 0, "Registration ID of user who requested the certificate")
-	err := flagSet.Parse(os.Args[2:])
-	cmd.FailOnError(err, "Error parsing flagset")
-
-	usage := func() {
-		fmt.Fprintf(os.Stderr, "%s\nargs:", usageString)
-		flagSet.PrintDefaults()
-		os.Exit(1)
-	}
-
-	if *configFile == "" {
-		usage()
+	opts := newOptions(os.Args[1])
+	defer recoverPanic(&opts.rp.inv)
+	err := opts.parse(os.Args[2:])
+	if err == errUsage {
+		opts.usage()
 	}
-
-	switch command {
-	case "parse-ca-log":
-		logger, sa, ca := setup(*configFile)
-		if *logPath == "" {
-			usage()
-		}
-
-		logData, err := ioutil.ReadFile(*logPath)
-		cmd.FailOnError(err, "Failed to read log file")
-
-		var certOrphansFound, certOrphansAdded, precertOrphansFound, precertOrphansAdded int64
-		for _, line := range strings.Split(string(logData), "\n") {
-			if line == "" {
-				continue
-			}
-			found, added, typ := storeParsedLogLine(sa, ca, logger, line)
-			var foundStat, addStat *int64
-			switch typ {
-			case certOrphan:
-				foundStat = &certOrphansFound
-				addStat = &certOrphansAdded
-			case precertOrphan:
-				foundStat = &precertOrphansFound
-				addStat = &precertOrphansAdded
-			default:
-				logger.Errf("Found orphan type %s", typ)
-				continue
-			}
-			if found {
-				*foundStat++
-				if added {
-					*addStat++
-				}
-			}
-		}
-		logger.Infof("Found %d certificate orphans and added %d to the database", certOrphansFound, certOrphansAdded)
-		logger.Infof("Found %d precertificate orphans and added %d to the database", precertOrphansFound, precertOrphansAdded)
-
-	case "parse-der":
-		ctx := context.Background()
-		_, sa, ca := setup(*configFile)
-		if *derPath == "" || *regID == 0 {
-			usage()
-		}
-		der, err := ioutil.ReadFile(*derPath)
-		cmd.FailOnError(err, "Failed to read DER file")
-		cert, typ, err := checkDER(sa, der)
-		cmd.FailOnError(err, "Pre-AddCertificate checks failed")
-		// Because certificates are backdated we need to add the backdate duration
-		// to find the true issued time.
-		issuedDate := cert.NotBefore.Add(1 * backdateDuration)
-		response, err := generateOCSP(ctx, ca, der)
-		cmd.FailOnError(err, "Generating OCSP")
-
-		switch typ {
-		case certOrphan:
-			_, err = sa.AddCertificate(ctx, der, *regID, response, &issuedDate)
-		case precertOrphan:
-			issued := issuedDate.UnixNano()
-			_, err = sa.AddPrecertificate(ctx, &sapb.AddCertificateRequest{
-				Der:    der,
-				RegID:  regID,
-				Ocsp:   response,
-				Issued: &issued,
-			})
-		default:
-			err = errors.New("unknown orphan type")
-		}
-		cmd.FailOnError(err, "Failed to add certificate to database")
-
-	default:
-		usage()
+	cmd.FailOnError(err, "Error parsing flagset")
+	run, ok := commands[opts.command]
+	if !ok {
+		opts.usage()
 	}
+	err = opts.openOutputs()
+	cmd.FailOnError(err, "Failed to open outputs")
+	run(opts, start)
 }
@@ -2,12 +2,19 @@ package main
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"fmt"
+	"math/big"
+	"strings"
 	"testing"
 	"time"
 
+	"golang.org/x/crypto/ocsp"
 	"google.golang.org/grpc"
 
 	"github.com/jmhodges/clock"
@@ -29,20 +36,20 @@ type mockSA struct {
 	clk             clock.FakeClock
 }
 
-func (m *mockSA) AddCertificate(ctx context.Context, der []byte, regID int64, _ []byte, issued *time.Time) (string, error) {
-	parsed, err := x509.ParseCertificate(der)
+func (m *mockSA) AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error) {
+	parsed, err := x509.ParseCertificate(req.Der)
 	if err != nil {
 		return "", err
 	}
 	cert := core.Certificate{
-		DER:            der,
-		RegistrationID: regID,
+		DER:            req.Der,
+		RegistrationID: *req.RegID,
 		Serial:         core.SerialToString(parsed.SerialNumber),
 	}
-	if issued == nil {
+	if req.Issued == nil {
 		cert.Issued = m.clk.Now()
 	} else {
-		cert.Issued = *issued
+		cert.Issued = time.Unix(0, *req.Issued)
 	}
 	m.certificates = append(m.certificates, cert)
 	return "", nil
@@ -91,12 +98,100 @@ func (m *mockSA) GetPrecertificate(ctx context.Context, req *sapb.Serial) (*core
 	return nil, berrors.NotFoundError("no precert stored for requested serial")
 }
 
+// removeSerial returns certs without those with the given serial.
+func removeSerial(certs []core.Certificate, serial string) []core.Certificate {
+	var kept []core.Certificate
+	for _, cert := range certs {
+		if cert.Serial != serial {
+			kept = append(kept, cert)
+		}
+	}
+	return kept
+}
+
+// newOCSPSigner returns a self-signed certificate and its key to sign test
+// OCSP responses with.
+func newOCSPSigner() (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "responder"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		panic(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		panic(err)
+	}
+	return cert, key
+}
+
+var mockOCSPCert, mockOCSPKey = newOCSPSigner()
+
+// mockCA generates OCSP responses asserting the requested status for the
+// serial of the requested certificate.
 type mockCA struct{}
 
-func (ca *mockCA) GenerateOCSP(context.Context, *capb.GenerateOCSPRequest, ...grpc.CallOption) (*capb.OCSPResponse, error) {
-	return &capb.OCSPResponse{
-		Response: []byte("HI"),
-	}, nil
+func (ca *mockCA) GenerateOCSP(_ context.Context, req *capb.GenerateOCSPRequest, _ ...grpc.CallOption) (*capb.OCSPResponse, error) {
+	cert, err := x509.ParseCertificate(req.CertDER)
+	if err != nil {
+		return nil, err
+	}
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   time.Now(),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if req.Status == string(core.OCSPStatusRevoked) {
+		template.Status = ocsp.Revoked
+		template.RevocationReason = int(req.Reason)
+		template.RevokedAt = time.Unix(0, req.RevokedAt)
+	}
+	resp, err := ocsp.CreateResponse(mockOCSPCert, mockOCSPCert, template, mockOCSPKey)
+	if err != nil {
+		return nil, err
+	}
+	return &capb.OCSPResponse{Response: resp}, nil
+}
+
+var (
+	testCertDER    = "3082045b30820343a003020102021300ffa0160630d618b2eb5c0510824b14274856300d06092a864886f70d01010b0500301f311d301b06035504030c146861707079206861636b65722066616b65204341301e170d3135313030333035323130305a170d3136303130313035323130305a3018311630140603550403130d6578616d706c652e636f2e626e30820122300d06092a864886f70d01010105000382010f003082010a02820101009ea3f1d21fade5596e36a6a77095a94758e4b72466b7444ada4f7c4cf6fde9b1d470b93b65c1fdd896917f248ccae49b57c80dc21c64b010699432130d059d2d8392346e8a179c7c947835549c64a7a5680c518faf0a5cbea48e684fca6304775c8fa9239c34f1d5cb2d063b098bd1c17183c7521efc884641b2f0b41402ac87c7076848d4347cef59dd5a9c174ad25467db933c95ef48c578ba762f527b21666a198fb5e1fe2d8299b4dceb1791e96ad075e3ecb057c776d764fad8f0829d43c32ddf985a3a36fade6966cec89468721a1ec47ab38eac8da4514060ded51d283a787b7c69971bda01f49f76baa41b1f9b4348aa4279e0fa55645d6616441f0d0203010001a382019530820191300e0603551d0f0101ff0404030205a0301d0603551d250416301406082b0601050507030106082b06010505070302300c0603551d130101ff04023000301d0603551d0e04160414369d0c100452b9eb3ffe7ae852e9e839a3ae5adb301f0603551d23041830168014fb784f12f96015832c9f177f3419b32e36ea4189306a06082b06010505070101045e305c302606082b06010505073001861a687474703a2f2f6c6f63616c686f73743a343030322f6f637370303206082b060105050730028626687474703a2f2f6c6f63616c686f73743a343030302f61636d652f6973737565722d6365727430180603551d110411300f820d6578616d706c652e636f2e626e30270603551d1f0420301e301ca01aa0188616687474703a2f2f6578616d706c652e636f6d2f63726c30630603551d20045c305a300a060667810c0102013000304c06032a03043045302206082b060105050702011616687474703a2f2f6578616d706c652e636f6d2f637073301f06082b0601050507020230130c11446f20576861742054686f752057696c74300d06092a864886f70d01010b05000382010100bbb4b994971cafa2e56e2258db46d88bfb361d8bfcd75521c03174e471eaa9f3ff2e719059bb57cc064079496d8550577c127baa84a18e792ddd36bf4f7b874b6d40d1d14288c15d38e4d6be25eb7805b1c3756b3735702eb4585d1886bc8af2c14086d3ce506e55184913c83aaaa8dfe6160bd035e42cda6d97697ed3ee3124c9bf9620a9fe6602191c1b746533c1d4a30023bbe902cb4aa661901177ed924eb836c94cc062dd0ce439c4ece9ee1dfe0499a42cbbcb2ea7243c59f4df4fdd7058229bacf9a640632dbd776b21633137b2df1c41f0765a66f448777aeec7ed4c0cdeb9d8a2356ff813820a287e11d52efde1aa543b4ef2ee992a7a9d5ccf7da4"
+	testPreCertDER = "308204553082033da003020102021203e1dea6f3349009a90e0306dbb39c3e7ca2300d06092a864886f70d01010b0500304a310b300906035504061302555331163014060355040a130d4c6574277320456e6372797074312330210603550403131a4c6574277320456e637279707420417574686f72697479205833301e170d3139313031363132353431375a170d3230303131343132353431375a30133111300f060355040313086a756e74732e696f30820122300d06092a864886f70d01010105000382010f003082010a0282010100c91926403839aadbf2a73af4f85e3884df553880c7e9d11943121b941f284a2c805b6329a93d7fb2357c1298d811cfce61faa863c334149f948ff52a55a516e56b2d31d137b1d0319f2aabdea0e9d5e8630b54d7e53597e094c323e24a7ec1ab0db5d85651a641ec3fd7841fe5cbc675315c49b714238ead757e55409fd68c4b48d42f14c2124d381800fd2ec417ed7f363b00ab23aaddaf9113d5cf889bbf391431bffb91d425d11a1e79318b7007b8e75cc56633662c3d6c58175b5cab6225aa495361b1124642f19584820d215f23f46bd9fafa3341a0f7f387bf7cdecbccd7fcbcb3e917becb41562771e579884a0d8a1b170536f82ba90b398e9a6932150203010001a382016a30820166300e0603551d0f0101ff0404030205a0301d0603551d250416301406082b0601050507030106082b06010505070302300c0603551d130101ff04023000301d0603551d0e041604144d14d73117ca7f5a27394ed590b0d037eb5888a2301f0603551d23041830168014a84a6a63047dddbae6d139b7a64565eff3a8eca1306f06082b0601050507010104633061302e06082b060105050730018622687474703a2f2f6f6373702e696e742d78332e6c657473656e63727970742e6f7267302f06082b060105050730028623687474703a2f2f636572742e696e742d78332e6c657473656e63727970742e6f72672f30130603551d11040c300a82086a756e74732e696f304c0603551d20044530433008060667810c0102013037060b2b0601040182df130101013028302606082b06010505070201161a687474703a2f2f6370732e6c657473656e63727970742e6f72673013060a2b06010401d6790204030101ff04020500300d06092a864886f70d01010b0500038201010035f9d6620874966f2aa400f069c5f601dc11083f5859a15d20e9b1d2f9d87d3756a71a03cee0ab2a69b5173a4395b698163ba60394167c9eb4b66d20d9b3a76bf94995288e8d15c70bee969f77a71147718803e73df0a7832c1fcae1e3138601ebc61725bc7505c6d1e5b0eaf7797e09161d71e37d76370dc489312b1bf0600d1c952f846edb810c284c0d831f27481a8f2220ad178c87d8c4688023fa3798293dc9fdffa9e5b885a8107d8a2480226cd5f9121d6d7ea83b10292371ad6757e7729b27136a064f2901822b4f0ea52f8149a17860e37d3dc925488b1ba4aa26ef51e60de024e67e3d5e04ac97d8bd79a003e668ea2e1bd1c0b9d77c7cf7bfdc32"
+)
+
+// logLine returns a boulder-ca log line orphaning the given DER.
+func logLine(typ orphanType, der, regID, orderID string) string {
+	return fmt.Sprintf(
+		"0000-00-00T00:00:00+00:00 hostname boulder-ca[pid]: "+
+			"[AUDIT] Failed RPC to store at SA, orphaning %s: "+
+			"cert=[%s] err=[context deadline exceeded], regID=[%s], orderID=[%s]",
+		typ, der, regID, orderID)
+}
+
+// newTestReprocessor returns a reprocessor storing orphans with sa and ca and
+// logging to the mock log, with the default settings but for the hour long
+// backdate of the test CA.
+func newTestReprocessor(sa certificateStorage, ca ocspGenerator) *reprocessor {
+	rp := newReprocessor()
+	rp.sa, rp.ca, rp.logger = sa, ca, log
+	rp.backdateDuration = time.Hour
+	return rp
+}
+
+// newTestOptions returns the default options of a parse-ca-log run storing
+// orphans with rp.
+func newTestOptions(rp *reprocessor) *options {
+	opts := newOptions("parse-ca-log")
+	opts.rp = rp
+	return opts
 }
 
 func checkNoErrors(t *testing.T) {
@@ -113,22 +208,7 @@ func TestParseLine(t *testing.T) {
 	fc := clock.NewFake()
 	fc.Set(time.Date(2015, 3, 4, 5, 0, 0, 0, time.UTC))
 	sa := &mockSA{}
-	ca := &mockCA{}
-
-	// Set an example backdate duration (this is normally read from config)
-	backdateDuration = time.Hour
-
-	testCertDER := "3082045b30820343a003020102021300ffa0160630d618b2eb5c0510824b14274856300d06092a864886f70d01010b0500301f311d301b06035504030c146861707079206861636b65722066616b65204341301e170d3135313030333035323130305a170d3136303130313035323130305a3018311630140603550403130d6578616d706c652e636f2e626e30820122300d06092a864886f70d01010105000382010f003082010a02820101009ea3f1d21fade5596e36a6a77095a94758e4b72466b7444ada4f7c4cf6fde9b1d470b93b65c1fdd896917f248ccae49b57c80dc21c64b010699432130d059d2d8392346e8a179c7c947835549c64a7a5680c518faf0a5cbea48e684fca6304775c8fa9239c34f1d5cb2d063b098bd1c17183c7521efc884641b2f0b41402ac87c7076848d4347cef59dd5a9c174ad25467db933c95ef48c578ba762f527b21666a198fb5e1fe2d8299b4dceb1791e96ad075e3ecb057c776d764fad8f0829d43c32ddf985a3a36fade6966cec89468721a1ec47ab38eac8da4514060ded51d283a787b7c69971bda01f49f76baa41b1f9b4348aa4279e0fa55645d6616441f0d0203010001a382019530820191300e0603551d0f0101ff0404030205a0301d0603551d250416301406082b0601050507030106082b06010505070302300c0603551d130101ff04023000301d0603551d0e04160414369d0c100452b9eb3ffe7ae852e9e839a3ae5adb301f0603551d23041830168014fb784f12f96015832c9f177f3419b32e36ea4189306a06082b06010505070101045e305c302606082b06010505073001861a687474703a2f2f6c6f63616c686f73743a343030322f6f637370303206082b060105050730028626687474703a2f2f6c6f63616c686f73743a343030302f61636d652f6973737565722d6365727430180603551d110411300f820d6578616d706c652e636f2e626e30270603551d1f0420301e301ca01aa0188616687474703a2f2f6578616d706c652e636f6d2f63726c30630603551d20045c305a300a060667810c0102013000304c06032a03043045302206082b060105050702011616687474703a2f2f6578616d706c652e636f6d2f637073301f06082b0601050507020230130c11446f20576861742054686f752057696c74300d06092a864886f70d01010b05000382010100bbb4b994971cafa2e56e2258db46d88bfb361d8bfcd75521c03174e471eaa9f3ff2e719059bb57cc064079496d8550577c127baa84a18e792ddd36bf4f7b874b6d40d1d14288c15d38e4d6be25eb7805b1c3756b3735702eb4585d1886bc8af2c14086d3ce506e55184913c83aaaa8dfe6160bd035e42cda6d97697ed3ee3124c9bf9620a9fe6602191c1b746533c1d4a30023bbe902cb4aa661901177ed924eb836c94cc062dd0ce439c4ece9ee1dfe0499a42cbbcb2ea7243c59f4df4fdd7058229bacf9a640632dbd776b21633137b2df1c41f0765a66f448777aeec7ed4c0cdeb9d8a2356ff813820a287e11d52efde1aa543b4ef2ee992a7a9d5ccf7da4"
-
-	testPreCertDER := "308204553082033da003020102021203e1dea6f3349009a90e0306dbb39c3e7ca2300d06092a864886f70d01010b0500304a310b300906035504061302555331163014060355040a130d4c6574277320456e6372797074312330210603550403131a4c6574277320456e637279707420417574686f72697479205833301e170d3139313031363132353431375a170d3230303131343132353431375a30133111300f060355040313086a756e74732e696f30820122300d06092a864886f70d01010105000382010f003082010a0282010100c91926403839aadbf2a73af4f85e3884df553880c7e9d11943121b941f284a2c805b6329a93d7fb2357c1298d811cfce61faa863c334149f948ff52a55a516e56b2d31d137b1d0319f2aabdea0e9d5e8630b54d7e53597e094c323e24a7ec1ab0db5d85651a641ec3fd7841fe5cbc675315c49b714238ead757e55409fd68c4b48d42f14c2124d381800fd2ec417ed7f363b00ab23aaddaf9113d5cf889bbf391431bffb91d425d11a1e79318b7007b8e75cc56633662c3d6c58175b5cab6225aa495361b1124642f19584820d215f23f46bd9fafa3341a0f7f387bf7cdecbccd7fcbcb3e917becb41562771e579884a0d8a1b170536f82ba90b398e9a6932150203010001a382016a30820166300e0603551d0f0101ff0404030205a0301d0603551d250416301406082b0601050507030106082b06010505070302300c0603551d130101ff04023000301d0603551d0e041604144d14d73117ca7f5a27394ed590b0d037eb5888a2301f0603551d23041830168014a84a6a63047dddbae6d139b7a64565eff3a8eca1306f06082b0601050507010104633061302e06082b060105050730018622687474703a2f2f6f6373702e696e742d78332e6c657473656e63727970742e6f7267302f06082b060105050730028623687474703a2f2f636572742e696e742d78332e6c657473656e63727970742e6f72672f30130603551d11040c300a82086a756e74732e696f304c0603551d20044530433008060667810c0102013037060b2b0601040182df130101013028302606082b06010505070201161a687474703a2f2f6370732e6c657473656e63727970742e6f72673013060a2b06010401d6790204030101ff04020500300d06092a864886f70d01010b0500038201010035f9d6620874966f2aa400f069c5f601dc11083f5859a15d20e9b1d2f9d87d3756a71a03cee0ab2a69b5173a4395b698163ba60394167c9eb4b66d20d9b3a76bf94995288e8d15c70bee969f77a71147718803e73df0a7832c1fcae1e3138601ebc61725bc7505c6d1e5b0eaf7797e09161d71e37d76370dc489312b1bf0600d1c952f846edb810c284c0d831f27481a8f2220ad178c87d8c4688023fa3798293dc9fdffa9e5b885a8107d8a2480226cd5f9121d6d7ea83b10292371ad6757e7729b27136a064f2901822b4f0ea52f8149a17860e37d3dc925488b1ba4aa26ef51e60de024e67e3d5e04ac97d8bd79a003e668ea2e1bd1c0b9d77c7cf7bfdc32"
-
-	logLine := func(typ orphanType, der, regID, orderID string) string {
-		return fmt.Sprintf(
-			"0000-00-00T00:00:00+00:00 hostname boulder-ca[pid]: "+
-				"[AUDIT] Failed RPC to store at SA, orphaning %s: "+
-				"cert=[%s] err=[context deadline exceeded], regID=[%s], orderID=[%s]",
-			typ, der, regID, orderID)
-	}
+	rp := newTestReprocessor(sa, &mockCA{})
 
 	testCases := []struct {
 		Name           string
@@ -222,9 +302,9 @@ func TestParseLine(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.Name, func(t *testing.T) {
 			log.Clear()
-			found, added, typ := storeParsedLogLine(sa, ca, log, tc.LogLine)
-			test.AssertEquals(t, found, tc.ExpectFound)
-			test.AssertEquals(t, added, tc.ExpectAdded)
+			res := rp.storeParsedLogLine(tc.LogLine)
+			test.AssertEquals(t, res.matched, tc.ExpectFound)
+			test.AssertEquals(t, res.stored, tc.ExpectAdded)
 			logs := log.GetAllMatching("ERR:")
 			if tc.ExpectNoErrors {
 				test.AssertEquals(t, len(logs), 0)
@@ -239,7 +319,7 @@ func TestParseLine(t *testing.T) {
 
 				// Fetch the precert/cert using the correct mock SA function
 				var storedCert core.Certificate
-				switch typ {
+				switch res.typ {
 				case precertOrphan:
 					resp, err := sa.GetPrecertificate(context.Background(), &sapb.Serial{Serial: &testCertSerial})
 					test.AssertNotError(t, err, "Error getting test precert serial from SA")
@@ -251,12 +331,12 @@ func TestParseLine(t *testing.T) {
 					test.AssertNotError(t, err, "Error getting test cert serial from SA")
 					storedCert = cert
 				default:
-					t.Fatalf("unknown orphan type returned: %s", typ)
+					t.Fatalf("unknown orphan type returned: %s", res.typ)
 				}
 				// The orphan should have been added with the correct registration ID from the log line
 				test.AssertEquals(t, storedCert.RegistrationID, int64(tc.ExpectRegID))
 				// The Issued timestamp should be the certificate's NotBefore timestamp offset by the backdateDuration
-				expectedIssued := testCert.NotBefore.Add(backdateDuration)
+				expectedIssued := testCert.NotBefore.Add(rp.backdateDuration)
 				test.Assert(t, storedCert.Issued.Equal(expectedIssued),
 					fmt.Sprintf("stored cert issued date (%s) was not equal to expected (%s)",
 						storedCert.Issued, expectedIssued))
@@ -268,13 +348,356 @@ func TestParseLine(t *testing.T) {
 func TestNotOrphan(t *testing.T) {
 	fc := clock.NewFake()
 	fc.Set(time.Date(2015, 3, 4, 5, 0, 0, 0, time.UTC))
-	sa := &mockSA{}
-	ca := &mockCA{}
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
 
 	log.Clear()
-	found, added, typ := storeParsedLogLine(sa, ca, log, "cert=fakeout")
-	test.AssertEquals(t, found, false)
-	test.AssertEquals(t, added, false)
-	test.AssertEquals(t, typ, unknownOrphan)
+	res := rp.storeParsedLogLine("cert=fakeout")
+	test.AssertEquals(t, res.matched, false)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, res.typ, unknownOrphan)
 	checkNoErrors(t)
 }
+
+// countingSA wraps mockSA to count existence lookups.
+type countingSA struct {
+	mockSA
+	lookups int
+}
+
+func (m *countingSA) GetCertificate(ctx context.Context, s string) (core.Certificate, error) {
+	m.lookups++
+	return m.mockSA.GetCertificate(ctx, s)
+}
+
+func (m *countingSA) GetPrecertificate(ctx context.Context, req *sapb.Serial) (*corepb.Certificate, error) {
+	m.lookups++
+	return m.mockSA.GetPrecertificate(ctx, req)
+}
+
+func TestOrphanResult(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+	log.Clear()
+
+	res := rp.storeParsedLogLine("unrelated line")
+	test.AssertEquals(t, res, orphanResult{})
+
+	res = rp.storeParsedLogLine(logLine(certOrphan, "abc", "1001", "0"))
+	test.AssertEquals(t, res, orphanResult{matched: true, err: errMalformedLine})
+
+	res = rp.storeParsedLogLine(logLine(certOrphan, "deadbeef", "1001", "0"))
+	test.Assert(t, res.matched && res.err != nil, "unparsable DER didn't fail")
+	test.AssertEquals(t, res.typ, unknownOrphan)
+
+	line := logLine(certOrphan, testCertDER, "1001", "0")
+	res = rp.storeParsedLogLine(line)
+	test.AssertEquals(t, res, orphanResult{matched: true, stored: true, typ: certOrphan, serial: "ffa0160630d618b2eb5c0510824b14274856"})
+
+	// An orphan already in the database is skipped rather than failed
+	res = rp.storeParsedLogLine(line)
+	test.AssertEquals(t, res, orphanResult{matched: true, skipped: true, typ: certOrphan, serial: "ffa0160630d618b2eb5c0510824b14274856"})
+
+	rp.ca = &failingCA{}
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertError(t, res.err, "failed OCSP generation didn't fail")
+	test.Assert(t, !res.stored && !res.skipped, "failed orphan stored or skipped")
+	test.AssertEquals(t, res.typ, precertOrphan)
+}
+
+func TestParseLineSeenCache(t *testing.T) {
+	sa := &countingSA{}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+
+	// Each orphan is looked up and added once no matter how often it appears
+	precertLine := logLine(precertOrphan, testPreCertDER, "1001", "0")
+	certLine := logLine(certOrphan, testCertDER, "1001", "0")
+	for i := 0; i < 3; i++ {
+		log.Clear()
+		res := rp.storeParsedLogLine(precertLine)
+		test.AssertEquals(t, res.matched, true)
+		test.AssertEquals(t, res.stored, i == 0)
+		res = rp.storeParsedLogLine(certLine)
+		test.AssertEquals(t, res.matched, true)
+		test.AssertEquals(t, res.stored, i == 0)
+		checkNoErrors(t)
+	}
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, sa.lookups, 2)
+	test.AssertEquals(t, rp.duplicatesSkipped, int64(4))
+
+	// Orphans are matched by the serial of their DER, not their line
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "42") + " retried")
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, sa.lookups, 2)
+	test.AssertEquals(t, rp.duplicatesSkipped, int64(5))
+	test.AssertEquals(t, rp.pendingSkipped, int64(0))
+
+	// A line arriving while its serial is pending is skipped, and counted as
+	// such as it isn't retried if the pending store fails
+	rp.seen = newSerialCache(false)
+	certDER, _ := hex.DecodeString(testCertDER)
+	cert, err := x509.ParseCertificate(certDER)
+	test.AssertNotError(t, err, "parsing test cert failed")
+	key := serialKey{serial: core.SerialToString(cert.SerialNumber), typ: certOrphan}
+	_, claimed := rp.seen.claim(key)
+	test.Assert(t, claimed, "unseen serial not claimed")
+	res = rp.storeParsedLogLine(certLine)
+	test.Assert(t, res.skipped && !res.stored, "pending duplicate not skipped")
+	rp.seen.release(key, false)
+	test.AssertEquals(t, rp.duplicatesSkipped, int64(6))
+	test.AssertEquals(t, rp.pendingSkipped, int64(1))
+}
+
+func TestParseLineLabelMismatch(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+
+	// A matching label isn't a mismatch
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.typ, precertOrphan)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING:")), 0)
+	test.AssertEquals(t, rp.labelMismatches, int64(0))
+
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, res.typ, certOrphan)
+	test.AssertEquals(t, rp.labelMismatches, int64(0))
+
+	// A precert labelled as a certificate is still stored as a precert
+	log.Clear()
+	sa := &mockSA{}
+	rp.sa = sa
+	res = rp.storeParsedLogLine(logLine(certOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, res.typ, precertOrphan)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Log line labels orphan .* as a certificate but its DER is a precertificate")), 1)
+	test.AssertEquals(t, rp.labelMismatches, int64(1))
+
+	// Unless strictLabel is set, in which case it is refused
+	rp.strictLabel = true
+	log.Clear()
+	sa = &mockSA{}
+	rp.sa = sa
+	res = rp.storeParsedLogLine(logLine(certOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, res.typ, precertOrphan)
+	test.AssertEquals(t, len(sa.precertificates), 0)
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Log line labels orphan 03e1dea6f3349009a90e0306dbb39c3e7ca2 as a certificate but its DER is a precertificate, refusing to store it`)), 1)
+	test.AssertEquals(t, rp.labelMismatches, int64(2))
+
+	// A matching label is still stored
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.labelMismatches, int64(2))
+}
+
+func TestParseLineStrictRegID(t *testing.T) {
+	sa := &mockSA{}
+	rp := newTestReprocessor(sa, &mockCA{})
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "0", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(sa.certificates), 0)
+	test.AssertEquals(t, len(log.GetAllMatching("ERR: \\[AUDIT\\] Invalid regID 0")), 1)
+	test.AssertEquals(t, rp.invalidRegIDs, int64(1))
+
+	// Without --strict-regid the orphan is stored as before
+	rp.strictRegID = false
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "0", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.invalidRegIDs, int64(1))
+}
+
+func TestParseLineDefaultRegID(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	// Logs predating the regID token have none at all
+	oldLine := strings.Replace(logLine(certOrphan, testCertDER, "", "0"), ", regID=[]", "", 1)
+
+	// Without --default-regID the line fails as before
+	log.Clear()
+	res := rp.storeParsedLogLine(oldLine)
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] regID variable is empty`)), 1)
+
+	rp.defaultRegID = 42
+	log.Clear()
+	res = rp.storeParsedLogLine(oldLine)
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, sa.certificates[0].RegistrationID, int64(42))
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] Using --default-regID 42 for orphan without a regID`)), 1)
+
+	// A regID in the line takes precedence
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, sa.precertificates[0].RegistrationID, int64(1001))
+	test.AssertEquals(t, len(log.GetAllMatching(`--default-regID`)), 0)
+}
+
+func TestParseLineFailures(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+
+	// Neither adding an orphan nor finding it already stored is a failure
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.failedOrphans, int64(0))
+
+	rp.storeParsedLogLine(logLine(certOrphan, "abc", "1001", "0"))
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "", "0"))
+	test.AssertEquals(t, rp.failedOrphans, int64(2))
+	realSleepContext := sleepContext
+	sleepContext = func(context.Context, time.Duration) error { return nil }
+	defer func() { sleepContext = realSleepContext }()
+	rp.ca = &limitedCA{limited: rp.ocspRetries + 1}
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.failedOrphans, int64(3))
+
+	// Downgraded categories are only logged differently and still count
+	rp.ca = &mockCA{}
+	rp.downgradedAuditErrors = map[string]bool{auditMissingRegID: true, auditBadHex: true}
+	rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "", "0"))
+	test.AssertEquals(t, rp.failedOrphans, int64(4))
+	rp.storeParsedLogLine(logLine(certOrphan, "abc", "1001", "0"))
+	test.AssertEquals(t, rp.failedOrphans, int64(5))
+}
+
+func TestParseLineDryRun(t *testing.T) {
+	sa := &mockSA{}
+	ca := &limitedCA{}
+	rp := newTestReprocessor(sa, ca)
+	rp.dryRun = true
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, res.typ, certOrphan)
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: Would add certificate ffa0160630d618b2eb5c0510824b14274856 for registration 1001 in a dry run`)), 1)
+	test.AssertEquals(t, rp.dryRunOrphans, int64(1))
+	// Neither OCSP is generated nor anything stored
+	test.AssertEquals(t, ca.calls, 0)
+	test.AssertEquals(t, len(sa.certificates), 0)
+
+	// Orphans that wouldn't be stored aren't counted
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "0", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(log.GetAllMatching(`Would add`)), 0)
+	test.AssertEquals(t, rp.dryRunOrphans, int64(1))
+}
+
+func TestParseLineIssuedSince(t *testing.T) {
+	der, _ := hex.DecodeString(testCertDER)
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "parsing test certificate")
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+
+	// The threshold is compared against the backdated issued time, which is
+	// after it even though the NotBefore isn't
+	rp.issuedSince = cert.NotBefore.Add(90 * time.Minute)
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.tooOldOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`DEBUG: Skipping certificate ffa0160630d618b2eb5c0510824b14274856 issued at 2015-10-03T06:21:00Z, before 2015-10-03T06:51:00Z`)), 1)
+
+	rp.issuedSince = cert.NotBefore.Add(30 * time.Minute)
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.tooOldOrphans, int64(1))
+}
+
+func TestParseLineFutureIssued(t *testing.T) {
+	sa := &mockSA{}
+	rp := newTestReprocessor(sa, &mockCA{})
+
+	// A backdate putting the issued date in the future is refused
+	rp.backdateDuration = 100 * 365 * 24 * time.Hour
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	test.AssertEquals(t, len(sa.certificates), 0)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Refusing to store certificate ffa0160630d618b2eb5c0510824b14274856 with issued date 2115-.* in the future`)), 1)
+
+	rp.backdateDuration = time.Hour
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+}
+
+func TestParseLineMaxOrphanAge(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+
+	// The test certificate was issued in 2015, more than five years ago
+	rp.maxOrphanAge = 5 * 365 * 24 * time.Hour
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.implausiblyOldOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] Storing certificate ffa0160630d618b2eb5c0510824b14274856 with issued date 2015-10-03T06:21:00Z more than 43800h0m0s ago`)), 1)
+
+	// With --strict-age it is skipped
+	rp.strictAge = true
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.skipped, true)
+	test.AssertEquals(t, len(sa.precertificates), 0)
+	test.AssertEquals(t, rp.implausiblyOldOrphans, int64(2))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Skipping precertificate 03e1dea6f3349009a90e0306dbb39c3e7ca2 with issued date .* more than 43800h0m0s ago`)), 1)
+	sum := &summary{}
+	rp.logTooOld(sum)
+	test.AssertContains(t, sum.String(), " orphansImplausiblyOld=2")
+	test.AssertEquals(t, len(log.GetAllMatching(`WARNING: Skipped 2 orphans issued more than 43800h0m0s ago`)), 1)
+
+	// Orphans younger than maxOrphanAge are stored as usual
+	rp.maxOrphanAge = 100 * 365 * 24 * time.Hour
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.implausiblyOldOrphans, int64(2))
+}
+
+func TestReprocessLogExported(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	logData := strings.Join([]string{
+		logLine(certOrphan, testCertDER, "1001", "1"),
+		"unrelated",
+		logLine(precertOrphan, "3082", "1001", "2"),
+		logLine(precertOrphan, testPreCertDER, "1001", "3"),
+	}, "\n")
+	log.Clear()
+	sum, err := ReprocessLog(context.Background(), sa, &mockCA{}, log, time.Hour, strings.NewReader(logData))
+	test.AssertNotError(t, err, "reprocessing log failed")
+	test.AssertEquals(t, sum, Summary{
+		CertOrphansFound:    1,
+		CertOrphansAdded:    1,
+		PrecertOrphansFound: 1,
+		PrecertOrphansAdded: 1,
+		OrphansFailed:       1,
+		ParseFailures:       1,
+	})
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	blog "github.com/letsencrypt/boulder/log"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+)
+
+// pushTimeout bounds the time spent pushing the metrics of a run to a
+// Pushgateway, so that an unreachable gateway doesn't hold up its exit.
+const pushTimeout = 10 * time.Second
+
+// pushJob is the job the metrics of a run are pushed to a Pushgateway under.
+const pushJob = "orphan-finder"
+
+// orphanMetrics holds the counters updated as orphans are stored.
+type orphanMetrics struct {
+	found         *prometheus.CounterVec
+	added         *prometheus.CounterVec
+	failed        *prometheus.CounterVec
+	parseFailures prometheus.Counter
+	funnel        *prometheus.CounterVec
+	// exemplars, when set, causes every increment to carry an exemplar naming
+	// the serial and runID, the run it was made by, if the counter supports
+	// it.
+	exemplars bool
+	runID     string
+	// gatherer and pushURL, if set, are the metrics pushed to a Pushgateway
+	// once the run is done and its URL.
+	gatherer prometheus.Gatherer
+	pushURL  string
+}
+
+// newRunID returns a short random identifier for a run. It is kept short so
+// that it fits into an exemplar alongside a serial.
+func newRunID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func newOrphanMetrics(registerer prometheus.Registerer, exemplars bool, runID string) *orphanMetrics {
+	found := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orphan_finder_found_total",
+		Help: "A counter of orphans found, whether or not they were added to the database, labelled by type",
+	}, []string{"type"})
+	registerer.MustRegister(found)
+	added := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orphan_finder_added_total",
+		Help: "A counter of orphans added to the database, labelled by type",
+	}, []string{"type"})
+	registerer.MustRegister(added)
+	failed := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orphan_finder_failed_total",
+		Help: "A counter of orphans that failed to be added to the database, labelled by type",
+	}, []string{"type"})
+	registerer.MustRegister(failed)
+	parseFailures := prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "orphan_finder_parse_failures_total",
+		Help: "A counter of orphans that couldn't be parsed from their log line or DER",
+	})
+	registerer.MustRegister(parseFailures)
+	funnel := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "orphan_finder_parse_funnel_total",
+		Help: "A counter of log lines reaching each stage of parsing and storing an orphan, labelled by stage",
+	}, []string{"stage"})
+	registerer.MustRegister(funnel)
+	return &orphanMetrics{
+		found:         found,
+		added:         added,
+		failed:        failed,
+		parseFailures: parseFailures,
+		funnel:        funnel,
+		exemplars:     exemplars,
+		runID:         runID,
+	}
+}
+
+// inc increments counter, attaching an exemplar for serial if exemplars are
+// enabled and the counter supports them.
+func (m *orphanMetrics) inc(counter prometheus.Counter, serial string) {
+	exemplar := prometheus.Labels{"serial": serial, "run_id": m.runID}
+	adder, ok := counter.(prometheus.ExemplarAdder)
+	if !m.exemplars || !ok || exemplarRunes(exemplar) > prometheus.ExemplarMaxRunes {
+		counter.Inc()
+		return
+	}
+	adder.AddWithExemplar(1, exemplar)
+}
+
+// exemplarRunes returns the number of runes counted against
+// prometheus.ExemplarMaxRunes for the given exemplar labels.
+func exemplarRunes(labels prometheus.Labels) int {
+	var n int
+	for name, value := range labels {
+		n += utf8.RuneCountInString(name) + utf8.RuneCountInString(value)
+	}
+	return n
+}
+
+// orphanFound records that an orphan was found. It does nothing if m is nil.
+func (m *orphanMetrics) orphanFound(typ orphanType) {
+	if m == nil {
+		return
+	}
+	m.found.WithLabelValues(typ.String()).Inc()
+}
+
+// orphanAdded records that an orphan was added. It does nothing if m is nil.
+func (m *orphanMetrics) orphanAdded(typ orphanType, serial string) {
+	if m == nil {
+		return
+	}
+	m.inc(m.added.WithLabelValues(typ.String()), serial)
+}
+
+// orphanFailed records that an orphan failed to be added. It does nothing if m
+// is nil.
+func (m *orphanMetrics) orphanFailed(typ orphanType, serial string) {
+	if m == nil {
+		return
+	}
+	m.inc(m.failed.WithLabelValues(typ.String()), serial)
+}
+
+// parseFailed records that an orphan couldn't be parsed. It does nothing if m
+// is nil.
+func (m *orphanMetrics) parseFailed() {
+	if m == nil {
+		return
+	}
+	m.parseFailures.Inc()
+}
+
+// funnelReached records that a log line reached stage. It does nothing if m is
+// nil.
+func (m *orphanMetrics) funnelReached(stage funnelStage) {
+	if m == nil {
+		return
+	}
+	m.funnel.WithLabelValues(stage.String()).Inc()
+}
+
+// setupMetrics returns the orphanMetrics of the run, exported on addr and
+// pushed to the Pushgateway at pushURL once the run is done, if they are set,
+// and the registerer the other metrics of the run are registered with. It
+// returns nil and a registerer discarding the metrics if neither is set. The
+// OpenMetrics format, the only one able to carry exemplars, is offered on addr
+// if exemplars are enabled, which name the run by runID.
+func setupMetrics(addr, pushURL string, logger blog.Logger, exemplars bool, runID string) (*orphanMetrics, prometheus.Registerer) {
+	if addr == "" && pushURL == "" {
+		return nil, metrics.NoopRegisterer
+	}
+	registry := prometheus.NewRegistry()
+	m := newOrphanMetrics(registry, exemplars, runID)
+	if pushURL != "" {
+		m.gatherer = registry
+		m.pushURL = pushURL
+	}
+	if addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{
+			EnableOpenMetrics: exemplars,
+		}))
+		go func() {
+			err := http.ListenAndServe(addr, mux)
+			if err != nil {
+				logger.Errf("unable to serve metrics on %s: %s", addr, err)
+			}
+		}()
+	}
+	return m, registry
+}
+
+// push replaces the metrics of the orphan-finder job in the Pushgateway with
+// those of the run, grouped by the command run, as a run may end before
+// Prometheus scrapes it. Failing to do so is only logged, as it mustn't change
+// the outcome of the run. It does nothing if m is nil or has no pushURL.
+func (m *orphanMetrics) push(logger blog.Logger, command string) {
+	if m == nil || m.pushURL == "" {
+		return
+	}
+	families, err := m.gatherer.Gather()
+	if err != nil {
+		logger.Warningf("Failed to gather metrics to push: %s", err)
+		return
+	}
+	var body bytes.Buffer
+	enc := expfmt.NewEncoder(&body, expfmt.FmtText)
+	for _, family := range families {
+		err := enc.Encode(family)
+		if err != nil {
+			logger.Warningf("Failed to encode metrics to push: %s", err)
+			return
+		}
+	}
+	url := fmt.Sprintf("%s/metrics/job/%s/command/%s", strings.TrimSuffix(m.pushURL, "/"), pushJob, command)
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		logger.Warningf("Failed to push metrics: %s", err)
+		return
+	}
+	req.Header.Set("Content-Type", string(expfmt.FmtText))
+	client := &http.Client{Timeout: pushTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.Warningf("Failed to push metrics: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		logger.Warningf("Failed to push metrics: Pushgateway returned %s", resp.Status)
+	}
+}
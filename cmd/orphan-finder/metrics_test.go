@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/metrics"
+	"github.com/letsencrypt/boulder/test"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// counterMetric returns the state of the counter for typ in vec.
+func counterMetric(t *testing.T, vec *prometheus.CounterVec, typ orphanType) *dto.Metric {
+	var m dto.Metric
+	err := vec.WithLabelValues(typ.String()).Write(&m)
+	test.AssertNotError(t, err, "failed to read counter")
+	return &m
+}
+
+func TestOrphanMetricsExemplars(t *testing.T) {
+	// A nil orphanMetrics ignores updates
+	var m *orphanMetrics
+	m.orphanAdded(certOrphan, "00")
+
+	m = newOrphanMetrics(prometheus.NewRegistry(), true, "testrun")
+	m.orphanAdded(certOrphan, "00ffa0160630d618b2eb5c0510824b14274856")
+	added := counterMetric(t, m.added, certOrphan)
+	test.AssertEquals(t, added.GetCounter().GetValue(), float64(1))
+	exemplar := added.GetCounter().GetExemplar()
+	test.Assert(t, exemplar != nil, "no exemplar attached")
+	labels := map[string]string{}
+	for _, pair := range exemplar.GetLabel() {
+		labels[pair.GetName()] = pair.GetValue()
+	}
+	test.AssertDeepEquals(t, labels, map[string]string{
+		"serial": "00ffa0160630d618b2eb5c0510824b14274856",
+		"run_id": "testrun",
+	})
+
+	// Without exemplars, or if the exemplar would be too long, the counter is
+	// still incremented
+	m.orphanFailed(precertOrphan, string(make([]byte, prometheus.ExemplarMaxRunes)))
+	failed := counterMetric(t, m.failed, precertOrphan)
+	test.AssertEquals(t, failed.GetCounter().GetValue(), float64(1))
+	test.Assert(t, failed.GetCounter().GetExemplar() == nil, "oversized exemplar attached")
+
+	m = newOrphanMetrics(prometheus.NewRegistry(), false, "testrun")
+	m.orphanAdded(precertOrphan, "00")
+	added = counterMetric(t, m.added, precertOrphan)
+	test.AssertEquals(t, added.GetCounter().GetValue(), float64(1))
+	test.Assert(t, added.GetCounter().GetExemplar() == nil, "exemplar attached while disabled")
+}
+
+func TestOrphanMetricsNames(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	m := newOrphanMetrics(registry, false, "testrun")
+	m.orphanFound(certOrphan)
+	m.orphanAdded(certOrphan, "00")
+	m.orphanFailed(certOrphan, "00")
+	m.parseFailed()
+	m.funnelReached(stageScanned)
+	families, err := registry.Gather()
+	test.AssertNotError(t, err, "gathering metrics")
+	var names []string
+	for _, family := range families {
+		names = append(names, family.GetName())
+	}
+	// The counters follow the Prometheus naming conventions
+	test.AssertDeepEquals(t, names, []string{
+		"orphan_finder_added_total",
+		"orphan_finder_failed_total",
+		"orphan_finder_found_total",
+		"orphan_finder_parse_failures_total",
+		"orphan_finder_parse_funnel_total",
+	})
+}
+
+func TestOrphanMetricsFound(t *testing.T) {
+	stats := newOrphanMetrics(prometheus.NewRegistry(), false, "testrun")
+	sa := &mockSA{clk: clock.NewFake()}
+	logData := strings.Join([]string{
+		logLine(certOrphan, testCertDER, "1001", "0"),
+		logLine(precertOrphan, "abc", "1001", "0"),
+		logLine(precertOrphan, testPreCertDER, "1001", "0"),
+		logLine(certOrphan, "3082", "1001", "0"),
+		logLine(certOrphan, testCertDER, "1001", "0"),
+	}, "\n")
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.stats = stats
+	rp.seen = newSerialCache(false)
+	err := rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertNotError(t, err, "parsing log")
+
+	// Orphans are counted as found whether or not they are added
+	test.AssertEquals(t, counterMetric(t, stats.found, certOrphan).GetCounter().GetValue(), float64(2))
+	test.AssertEquals(t, counterMetric(t, stats.found, precertOrphan).GetCounter().GetValue(), float64(1))
+	test.AssertEquals(t, counterMetric(t, stats.added, certOrphan).GetCounter().GetValue(), float64(1))
+	// The bad hex and the truncated DER failed to parse
+	var m dto.Metric
+	test.AssertNotError(t, stats.parseFailures.Write(&m), "reading parse failures")
+	test.AssertEquals(t, m.GetCounter().GetValue(), float64(2))
+}
+
+func TestPushMetrics(t *testing.T) {
+	var method, path, body string
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method, path = r.Method, r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		body = string(b)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	// Without a Pushgateway nothing is pushed
+	m, registerer := setupMetrics("", "", log, false, "testrun")
+	test.Assert(t, m == nil, "metrics set up without an address or Pushgateway")
+	test.AssertEquals(t, registerer, metrics.NoopRegisterer)
+	m.push(log, "parse-ca-log")
+	test.AssertEquals(t, path, "")
+
+	m, _ = setupMetrics("", srv.URL+"/", log, false, "testrun")
+	m.orphanAdded(certOrphan, "00")
+	log.Clear()
+	m.push(log, "parse-ca-log")
+	test.AssertEquals(t, method, http.MethodPut)
+	test.AssertEquals(t, path, "/metrics/job/orphan-finder/command/parse-ca-log")
+	test.AssertContains(t, body, `orphan_finder_added_total{type="certificate"} 1`)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING")), 0)
+
+	// Failing to push is only a warning
+	status = http.StatusBadRequest
+	m.push(log, "parse-ca-log")
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Failed to push metrics: Pushgateway returned 400")), 1)
+}
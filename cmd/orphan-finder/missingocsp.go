@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+)
+
+// certificateStatusGetter is implemented by storage authorities able to look
+// up the status row of a certificate, which holds its OCSP response.
+type certificateStatusGetter interface {
+	GetCertificateStatus(ctx context.Context, serial string) (core.CertificateStatus, error)
+}
+
+// ocspUpdater is implemented by storage authorities able to store a new OCSP
+// response for a certificate. It isn't part of the SA's gRPC interface, so OCSP
+// responses can only be stored apart from their certificate if the configured
+// SA supports it.
+type ocspUpdater interface {
+	UpdateOCSP(ctx context.Context, serial string, response []byte) error
+}
+
+// storedDER returns the DER of the certificate or, failing that, the
+// precertificate stored with the given serial.
+func (rp *reprocessor) storedDER(ctx context.Context, serial string) ([]byte, error) {
+	der, err := rp.lookupStored(ctx, rp.sa, certOrphan, serial)
+	if berrors.Is(err, berrors.NotFound) {
+		der, err = rp.lookupStored(ctx, rp.sa, precertOrphan, serial)
+	}
+	return der, err
+}
+
+// missingOCSPResult counts the outcomes of checking serials for an OCSP
+// response.
+type missingOCSPResult struct {
+	checked   int
+	notStored int
+	missing   int
+	fixed     int
+	failed    int
+}
+
+func (r missingOCSPResult) String() string {
+	return fmt.Sprintf("checked=%d notStored=%d missingOCSP=%d fixed=%d failed=%d",
+		r.checked, r.notStored, r.missing, r.fixed, r.failed)
+}
+
+// serialsToCheck returns the serials of the orphans in the log of the given
+// format read from r, if any, followed by those in serials, each only once.
+// Lines are joined as by reprocessLogs.
+func serialsToCheck(r io.Reader, continuationMarker string, format *logFormat, serials map[string]bool) ([]string, error) {
+	var ordered []string
+	seen := make(map[string]bool)
+	if r != nil {
+		err := forEachLogLine(r, continuationMarker, func(line string) {
+			der, ok := format.orphanDER(line)
+			if !ok {
+				return
+			}
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return
+			}
+			serial := core.SerialToString(cert.SerialNumber)
+			if !seen[serial] {
+				ordered = append(ordered, serial)
+				seen[serial] = true
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	var listed []string
+	for serial := range serials {
+		if !seen[serial] {
+			listed = append(listed, serial)
+		}
+	}
+	sort.Strings(listed)
+	return append(ordered, listed...), nil
+}
+
+// findMissingOCSP checks that every serial that is stored has an OCSP
+// response, calling report for each that doesn't. If fix is set a fresh
+// response asserting the status the SA records is generated for each of those
+// from the stored DER and stored, which needs an SA able to update OCSP
+// responses.
+func (rp *reprocessor) findMissingOCSP(ctx context.Context, serials []string, fix bool, report func(serial string)) (missingOCSPResult, error) {
+	var res missingOCSPResult
+	logger := rp.logger
+	getter, ok := rp.sa.(certificateStatusGetter)
+	if !ok {
+		return res, errors.New("the SA does not support looking up certificate statuses")
+	}
+	updater, ok := primaryStorage(rp.sa).(ocspUpdater)
+	if fix && !ok {
+		return res, errors.New("the SA does not support updating OCSP responses")
+	}
+	for _, serial := range serials {
+		var status core.CertificateStatus
+		err := rp.callRPC(ctx, func(ctx context.Context) error {
+			var err error
+			status, err = getter.GetCertificateStatus(ctx, serial)
+			return err
+		})
+		if berrors.Is(err, berrors.NotFound) {
+			logger.Infof("Skipping %s, which isn't stored", serial)
+			res.notStored++
+			continue
+		}
+		if err != nil {
+			logger.Errf("Failed to look up status of %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		res.checked++
+		if len(status.OCSPResponse) > 0 {
+			continue
+		}
+		res.missing++
+		logger.Warningf("Stored certificate %s has no OCSP response", serial)
+		report(serial)
+		if !fix {
+			continue
+		}
+		der, err := rp.storedDER(ctx, serial)
+		if err != nil {
+			logger.Errf("Failed to look up DER of %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		response, err := rp.generateOCSP(ctx, der, storedStatus(status))
+		if err != nil {
+			logger.AuditErrf("Couldn't generate OCSP for %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		err = rp.callRPC(ctx, func(ctx context.Context) error {
+			return updater.UpdateOCSP(ctx, serial, response)
+		})
+		if err != nil {
+			logger.AuditErrf("Failed to store OCSP response for %s: %s", serial, err)
+			res.failed++
+			continue
+		}
+		logger.AuditInfof("orphan-finder stored missing OCSP response: serial=[%s] runID=[%s]", serial, rp.runID)
+		res.fixed++
+	}
+	return res, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+	"github.com/letsencrypt/boulder/revocation"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// statusSA is a mockSA holding an OCSP response for some of the serials it
+// stores, and able to update them. The serials in revoked are revoked for the
+// given reason.
+type statusSA struct {
+	mockSA
+	responses map[string][]byte
+	revoked   map[string]revocation.Reason
+}
+
+func (m *statusSA) GetCertificateStatus(ctx context.Context, serial string) (core.CertificateStatus, error) {
+	_, certErr := m.GetCertificate(ctx, serial)
+	_, precertErr := m.GetPrecertificate(ctx, &sapb.Serial{Serial: &serial})
+	if certErr != nil && precertErr != nil {
+		return core.CertificateStatus{}, berrors.NotFoundError("no status stored for %s", serial)
+	}
+	status := core.CertificateStatus{Serial: serial, Status: core.OCSPStatusGood, OCSPResponse: m.responses[serial]}
+	if reason, ok := m.revoked[serial]; ok {
+		status.Status = core.OCSPStatusRevoked
+		status.RevokedReason = reason
+		status.RevokedDate = m.clk.Now()
+	}
+	return status, nil
+}
+
+func (m *statusSA) UpdateOCSP(_ context.Context, serial string, response []byte) error {
+	m.responses[serial] = response
+	return nil
+}
+
+func TestFindMissingOCSP(t *testing.T) {
+	ctx := context.Background()
+	certSerial := "ffa0160630d618b2eb5c0510824b14274856"
+	precertSerial := "03e1dea6f3349009a90e0306dbb39c3e7ca2"
+	sa := &statusSA{mockSA: mockSA{clk: clock.NewFake()}, responses: map[string][]byte{certSerial: []byte("stored")}}
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	regID := int64(1001)
+	_, err := sa.AddCertificate(ctx, &sapb.AddCertificateRequest{Der: certDER, RegID: &regID})
+	test.AssertNotError(t, err, "adding certificate failed")
+	_, err = sa.AddPrecertificate(ctx, &sapb.AddCertificateRequest{Der: precertDER, RegID: &regID})
+	test.AssertNotError(t, err, "adding precertificate failed")
+	serials := []string{certSerial, precertSerial, "00"}
+
+	ca := &mockCA{}
+
+	_, err = newTestReprocessor(&mockSA{}, ca).findMissingOCSP(ctx, serials, false, func(string) {})
+	test.AssertError(t, err, "SA without status lookups accepted")
+	readOnly := struct {
+		certificateStorage
+		certificateStatusGetter
+	}{&sa.mockSA, sa}
+	_, err = newTestReprocessor(readOnly, ca).findMissingOCSP(ctx, serials, true, func(string) {})
+	test.AssertError(t, err, "SA without OCSP updates accepted")
+
+	var reported []string
+	report := func(serial string) { reported = append(reported, serial) }
+	rp := newTestReprocessor(sa, ca)
+	res, err := rp.findMissingOCSP(ctx, serials, false, report)
+	test.AssertNotError(t, err, "checking OCSP failed")
+	test.AssertEquals(t, res.String(), "checked=2 notStored=1 missingOCSP=1 fixed=0 failed=0")
+	test.AssertDeepEquals(t, reported, []string{precertSerial})
+	test.AssertEquals(t, len(sa.responses[precertSerial]), 0)
+
+	// With fix the missing response is generated from the stored DER
+	reported = nil
+	log.Clear()
+	res, err = rp.findMissingOCSP(ctx, serials, true, report)
+	test.AssertNotError(t, err, "fixing OCSP failed")
+	test.AssertEquals(t, res.String(), "checked=2 notStored=1 missingOCSP=1 fixed=1 failed=0")
+	test.AssertDeepEquals(t, reported, []string{precertSerial})
+	test.AssertNotError(t, checkOCSPResponse(precertDER, sa.responses[precertSerial], goodStatus), "stored OCSP response is wrong")
+	test.AssertEquals(t, len(log.GetAllMatching(`stored missing OCSP response: serial=\[`+precertSerial+`\]`)), 1)
+
+	// Once fixed nothing is missing any more
+	reported = nil
+	res, err = rp.findMissingOCSP(ctx, serials, true, report)
+	test.AssertNotError(t, err, "checking OCSP failed")
+	test.AssertEquals(t, res.String(), "checked=2 notStored=1 missingOCSP=0 fixed=0 failed=0")
+	test.AssertEquals(t, len(reported), 0)
+}
+
+func TestSerialsToCheck(t *testing.T) {
+	logData := strings.NewReader(logLine(precertOrphan, testPreCertDER, "1001", "1") + "\n" +
+		logLine(certOrphan, testCertDER, "1001", "1") + "\n" +
+		logLine(precertOrphan, testPreCertDER, "1001", "1") + "\n")
+	serials, err := serialsToCheck(logData, "", defaultLogFormat(), map[string]bool{
+		"ffa0160630d618b2eb5c0510824b14274856": true,
+		"02":                                   true,
+		"01":                                   true,
+	})
+	test.AssertNotError(t, err, "reading log")
+	test.AssertDeepEquals(t, serials, []string{
+		"03e1dea6f3349009a90e0306dbb39c3e7ca2",
+		"ffa0160630d618b2eb5c0510824b14274856",
+		"01",
+		"02",
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// notifyTimeout bounds the time spent posting the notification of a run, so
+// that an unreachable webhook doesn't hold up its exit.
+const notifyTimeout = 5 * time.Second
+
+// notificationText returns the chat message announcing the end of the run with
+// the given ID.
+func notificationText(command, runID string, status int, reason string, sum *summary, elapsed time.Duration) string {
+	outcome := "succeeded"
+	if status != 0 {
+		outcome = fmt.Sprintf("failed with status %d: %s", status, reason)
+	}
+	return fmt.Sprintf("orphan-finder %s run %s %s after %s\n```%s```",
+		command, runID, outcome, elapsed.Round(time.Second), sum)
+}
+
+// notifyRun posts the end of a run to the Slack compatible webhook at url, if
+// it is set. Failing to do so is only logged, as it mustn't change the outcome
+// of the run.
+func notifyRun(logger blog.Logger, url, command, runID string, status int, reason string, sum *summary, elapsed time.Duration) {
+	if url == "" {
+		return
+	}
+	body, err := json.Marshal(map[string]string{
+		"text": notificationText(command, runID, status, reason, sum, elapsed),
+	})
+	if err != nil {
+		logger.Warningf("Failed to encode run notification: %s", err)
+		return
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Warningf("Failed to post run notification: %s", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		logger.Warningf("Failed to post run notification: webhook returned %s", resp.Status)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestNotifyRun(t *testing.T) {
+	sum := &summary{certOrphansFound: 2, certOrphansAdded: 1}
+
+	var posted []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]string
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		test.AssertNotError(t, err, "decoding notification failed")
+		posted = append(posted, msg["text"])
+	}))
+	defer srv.Close()
+	opts := newOptions("parse-ca-log")
+	opts.notifyURL = srv.URL
+	opts.rp.runID = "testrun"
+
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: sum, opts: opts}
+	inv.finish(1, "Failed to read log file: no such file")
+	test.AssertDeepEquals(t, posted, []string{
+		"orphan-finder parse-ca-log run testrun failed with status 1: Failed to read log file: no such file after 0s\n" +
+			"```certOrphansFound=2 certOrphansAdded=1 precertOrphansFound=0 precertOrphansAdded=0```",
+	})
+
+	// A webhook that can't be reached is only logged
+	srv.Close()
+	log.Clear()
+	notifyRun(log, opts.notifyURL, "parse-ca-log", "testrun", 0, "", sum, time.Minute)
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Failed to post run notification")), 1)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"strconv"
+	"time"
+
+	capb "github.com/letsencrypt/boulder/ca/proto"
+	"github.com/letsencrypt/boulder/core"
+	berrors "github.com/letsencrypt/boulder/errors"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// ocspBackoffBase and ocspBackoffMax bound the backoff between attempts to
+	// generate OCSP while the CA is rate limiting us. A delay the CA hints at
+	// is used instead, but is also capped at ocspBackoffMax.
+	ocspBackoffBase = time.Second
+	ocspBackoffMax  = time.Minute
+	// retryAfterKey is the trailer metadata key the CA may use to hint at how
+	// long to wait before retrying, in seconds.
+	retryAfterKey = "retry-after"
+)
+
+// noOCSPServerPolicy says what to do with an orphan whose AIA extension names
+// no OCSP server, e.g. one issued before the CA included it, for which a
+// generated OCSP response would never be asked for.
+type noOCSPServerPolicy string
+
+const (
+	// noOCSPServerGenerate generates an OCSP response as for any other orphan.
+	noOCSPServerGenerate = noOCSPServerPolicy("generate")
+	// noOCSPServerSkip stores the orphan without an OCSP response.
+	noOCSPServerSkip = noOCSPServerPolicy("skip")
+	// noOCSPServerFail refuses to store the orphan.
+	noOCSPServerFail = noOCSPServerPolicy("fail")
+)
+
+// parseNoOCSPServerPolicy parses the value of the --on-no-ocsp-server flag.
+func parseNoOCSPServerPolicy(s string) (noOCSPServerPolicy, error) {
+	switch p := noOCSPServerPolicy(s); p {
+	case noOCSPServerGenerate, noOCSPServerSkip, noOCSPServerFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown policy %q for orphans without an OCSP server, expected generate, skip or fail", s)
+}
+
+// hasOCSPServer returns true if the AIA extension of cert names an OCSP server.
+func hasOCSPServer(cert *x509.Certificate) bool {
+	return len(cert.OCSPServer) > 0
+}
+
+// withoutOCSP returns true if the orphan cert of the given type is stored
+// without an OCSP response.
+func (rp *reprocessor) withoutOCSP(typ orphanType, cert *x509.Certificate) bool {
+	if rp.onNoOCSPServer == noOCSPServerSkip && !hasOCSPServer(cert) {
+		return true
+	}
+	return rp.skipOCSP || (typ == precertOrphan && rp.noOCSPForPrecert)
+}
+
+// sleep waits before retrying a write refused by a read-only SA and before
+// starting a run with start jitter. It is replaced in tests.
+var sleep = time.Sleep
+
+// sleepContext waits for d to pass, like sleep, unless ctx is done first, in
+// which case it returns ctx's error at once. It is replaced in tests.
+var sleepContext = func(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// isRateLimited returns true if err indicates that the CA is rate limiting us.
+func isRateLimited(err error) bool {
+	return status.Code(err) == codes.ResourceExhausted || berrors.Is(err, berrors.RateLimit)
+}
+
+// retryAfter returns the delay hinted at by the CA in the trailer of a
+// response, capped at ocspBackoffMax so that a bogus hint can't stall the
+// run, or false if there is no valid hint.
+func retryAfter(trailer metadata.MD) (time.Duration, bool) {
+	values := trailer.Get(retryAfterKey)
+	if len(values) == 0 {
+		return 0, false
+	}
+	seconds, err := strconv.Atoi(values[0])
+	if err != nil || seconds < 0 {
+		return 0, false
+	}
+	if seconds > int(ocspBackoffMax/time.Second) {
+		return ocspBackoffMax, true
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// orphanOCSP returns the OCSP response asserting status to store alongside the
+// orphan cert of the given type, whose DER is certDER, which is none if
+// withoutOCSP is true for it.
+func (rp *reprocessor) orphanOCSP(ctx context.Context, typ orphanType, cert *x509.Certificate, certDER []byte, status orphanStatus) ([]byte, error) {
+	if rp.withoutOCSP(typ, cert) {
+		return nil, nil
+	}
+	return rp.generateOCSP(ctx, certDER, status)
+}
+
+// generateOCSP requests a fresh OCSP response asserting status for the
+// certificate from the CA. Unless rp.skipOCSPValidation is set the response is
+// checked with checkOCSPResponse, and one that doesn't pass is an error.
+// If the CA rate limits the request it is retried up to rp.ocspRetries times,
+// waiting as long as the CA hints at or backing off exponentially otherwise,
+// unless ctx is done first. Each attempt is bounded by rp.rpcTimeout and retried if it fails
+// transiently.
+func (rp *reprocessor) generateOCSP(ctx context.Context, certDER []byte, status orphanStatus) ([]byte, error) {
+	req := status.ocspRequest(certDER)
+	for attempt := 0; ; attempt++ {
+		var trailer metadata.MD
+		var ocspResponse *capb.OCSPResponse
+		err := rp.callRPC(ctx, func(ctx context.Context) error {
+			var err error
+			ocspResponse, err = rp.ca.GenerateOCSP(ctx, req, grpc.Trailer(&trailer))
+			return err
+		})
+		if err == nil {
+			if !rp.skipOCSPValidation {
+				err = checkOCSPResponse(certDER, ocspResponse.Response, status)
+				if err != nil {
+					return nil, fmt.Errorf("CA returned an invalid OCSP response: %s", err)
+				}
+			}
+			return ocspResponse.Response, nil
+		}
+		if !isRateLimited(err) || attempt >= rp.ocspRetries {
+			return nil, err
+		}
+		delay, ok := retryAfter(trailer)
+		if !ok {
+			delay = core.RetryBackoff(attempt+1, ocspBackoffBase, ocspBackoffMax, 2)
+		}
+		rp.logger.Warningf("CA rate limited OCSP generation, retrying in %s (attempt %d of %d): %s",
+			delay, attempt+1, rp.ocspRetries, err)
+		if sleepContext(ctx, delay) != nil {
+			return nil, err
+		}
+	}
+}
+
+// checkOCSPResponse returns an error unless response is an OCSP response for
+// the serial of the certificate with the given DER asserting status, including
+// its revocation reason if it is revoked. The response's signature isn't
+// checked, as the issuer of the certificate isn't known.
+func checkOCSPResponse(certDER, response []byte, status orphanStatus) error {
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return err
+	}
+	parsed, err := ocsp.ParseResponse(response, nil)
+	if err != nil {
+		return fmt.Errorf("parsing response: %s", err)
+	}
+	serial := core.SerialToString(cert.SerialNumber)
+	if got := core.SerialToString(parsed.SerialNumber); got != serial {
+		return fmt.Errorf("response is for serial %s rather than %s", got, serial)
+	}
+	want := ocsp.Good
+	if status.revoked {
+		want = ocsp.Revoked
+	}
+	if parsed.Status != want {
+		return fmt.Errorf("response for %s has status %d rather than %d", serial, parsed.Status, want)
+	}
+	if status.revoked && parsed.RevocationReason != int(status.reason) {
+		return fmt.Errorf("response for %s has revocation reason %d rather than %d", serial, parsed.RevocationReason, status.reason)
+	}
+	return nil
+}
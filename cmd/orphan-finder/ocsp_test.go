@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	capb "github.com/letsencrypt/boulder/ca/proto"
+	"github.com/letsencrypt/boulder/test"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// limitedCA is a mockCA that rate limits the first limited requests, hinting
+// at retryAfter in the trailer if it's set.
+type limitedCA struct {
+	mockCA
+	limited    int
+	retryAfter string
+	calls      int
+}
+
+func (ca *limitedCA) GenerateOCSP(ctx context.Context, req *capb.GenerateOCSPRequest, opts ...grpc.CallOption) (*capb.OCSPResponse, error) {
+	ca.calls++
+	if ca.calls > ca.limited {
+		return ca.mockCA.GenerateOCSP(ctx, req, opts...)
+	}
+	for _, opt := range opts {
+		if trailer, ok := opt.(grpc.TrailerCallOption); ok && ca.retryAfter != "" {
+			*trailer.TrailerAddr = metadata.Pairs(retryAfterKey, ca.retryAfter)
+		}
+	}
+	return nil, status.Error(codes.ResourceExhausted, "slow down")
+}
+
+func TestGenerateOCSPRateLimited(t *testing.T) {
+	var slept []time.Duration
+	realSleepContext := sleepContext
+	sleepContext = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	defer func() { sleepContext = realSleepContext }()
+
+	certDER, _ := hex.DecodeString(testCertDER)
+
+	// Rate limited requests are retried after the hinted delay
+	ca := &limitedCA{limited: 2, retryAfter: "7"}
+	rp := newTestReprocessor(&mockSA{}, ca)
+	log.Clear()
+	resp, err := rp.generateOCSP(context.Background(), certDER, goodStatus)
+	test.AssertNotError(t, err, "rate limited OCSP generation wasn't retried")
+	test.AssertNotError(t, checkOCSPResponse(certDER, resp, goodStatus), "generated OCSP response is wrong")
+	test.AssertEquals(t, ca.calls, 3)
+	test.AssertDeepEquals(t, slept, []time.Duration{7 * time.Second, 7 * time.Second})
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: CA rate limited OCSP generation")), 2)
+
+	// Without a hint the delay backs off
+	slept = nil
+	ca = &limitedCA{limited: 1}
+	rp.ca = ca
+	_, err = rp.generateOCSP(context.Background(), certDER, goodStatus)
+	test.AssertNotError(t, err, "rate limited OCSP generation wasn't retried")
+	test.AssertEquals(t, len(slept), 1)
+	test.Assert(t, slept[0] > 0 && slept[0] <= 2*ocspBackoffBase, "unexpected backoff")
+
+	// Retries are limited
+	slept = nil
+	ca = &limitedCA{limited: rp.ocspRetries + 1}
+	rp.ca = ca
+	_, err = rp.generateOCSP(context.Background(), certDER, goodStatus)
+	test.AssertError(t, err, "OCSP generation retried forever")
+	test.AssertEquals(t, status.Code(err), codes.ResourceExhausted)
+	test.AssertEquals(t, ca.calls, rp.ocspRetries+1)
+
+	// Other errors aren't retried
+	slept = nil
+	rp.ca = &failingCA{}
+	_, err = rp.generateOCSP(context.Background(), certDER, goodStatus)
+	test.AssertError(t, err, "failing OCSP generation succeeded")
+	test.AssertEquals(t, len(slept), 0)
+
+	// A hinted delay is capped
+	slept = nil
+	rp.ca = &limitedCA{limited: 1, retryAfter: "86400"}
+	_, err = rp.generateOCSP(context.Background(), certDER, goodStatus)
+	test.AssertNotError(t, err, "rate limited OCSP generation wasn't retried")
+	test.AssertDeepEquals(t, slept, []time.Duration{ocspBackoffMax})
+
+	// And the wait ends when the context of the line is done
+	sleepContext = realSleepContext
+	ctx, cancel := context.WithCancel(context.Background())
+	ca = &limitedCA{limited: 1, retryAfter: "60"}
+	rp.ca = ca
+	done := make(chan error)
+	go func() {
+		_, err := rp.generateOCSP(ctx, certDER, goodStatus)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		test.AssertEquals(t, status.Code(err), codes.ResourceExhausted)
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting for the CA went on after the context was canceled")
+	}
+	test.AssertEquals(t, ca.calls, 1)
+}
+
+// failingCA is a mockCA whose OCSP generation always fails.
+type failingCA struct{}
+
+func (ca *failingCA) GenerateOCSP(context.Context, *capb.GenerateOCSPRequest, ...grpc.CallOption) (*capb.OCSPResponse, error) {
+	return nil, status.Error(codes.Internal, "broken")
+}
+
+// fixedCA is a mockCA that returns the same OCSP response for every request.
+type fixedCA struct {
+	response []byte
+}
+
+func (ca *fixedCA) GenerateOCSP(context.Context, *capb.GenerateOCSPRequest, ...grpc.CallOption) (*capb.OCSPResponse, error) {
+	return &capb.OCSPResponse{Response: ca.response}, nil
+}
+
+func TestGenerateOCSPValidation(t *testing.T) {
+	ctx := context.Background()
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	precertResp, err := rp.generateOCSP(ctx, precertDER, goodStatus)
+	test.AssertNotError(t, err, "generating OCSP failed")
+	revoked, err := parseOrphanStatus("revoked", ocsp.KeyCompromise, "2020-01-02T03:04:05Z")
+	test.AssertNotError(t, err, "parsing status failed")
+	revokedResp, err := rp.generateOCSP(ctx, certDER, revoked)
+	test.AssertNotError(t, err, "generating revoked OCSP failed")
+	superseded := revoked
+	superseded.reason = ocsp.Superseded
+
+	testCases := []struct {
+		name     string
+		response []byte
+		status   orphanStatus
+		err      string
+	}{
+		{"malformed", []byte("HI"), goodStatus, "parsing response"},
+		{"other serial", precertResp, goodStatus, "response is for serial 03e1dea6f3349009a90e0306dbb39c3e7ca2 rather than ffa0160630d618b2eb5c0510824b14274856"},
+		{"wrong status", revokedResp, goodStatus, "has status 1 rather than 0"},
+		{"wrong reason", revokedResp, superseded, "has revocation reason 1 rather than 4"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rp := newTestReprocessor(sa, &fixedCA{tc.response})
+			_, err := rp.generateOCSP(ctx, certDER, tc.status)
+			test.AssertError(t, err, "invalid OCSP response accepted")
+			test.AssertContains(t, err.Error(), "CA returned an invalid OCSP response: ")
+			test.AssertContains(t, err.Error(), tc.err)
+		})
+	}
+
+	// A matching response is accepted
+	rp.ca = &fixedCA{revokedResp}
+	resp, err := rp.generateOCSP(ctx, certDER, revoked)
+	test.AssertNotError(t, err, "valid OCSP response refused")
+	test.AssertByteEquals(t, resp, revokedResp)
+
+	// An orphan whose response is invalid isn't stored and counts as failed
+	rp.ca = &fixedCA{[]byte("HI")}
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.Assert(t, !res.stored, "orphan with invalid OCSP response stored")
+	test.AssertError(t, res.err, "orphan with invalid OCSP response didn't fail")
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	test.AssertEquals(t, len(sa.certificates), 0)
+
+	// Validation can be skipped
+	rp.skipOCSPValidation = true
+	resp, err = rp.generateOCSP(ctx, certDER, goodStatus)
+	test.AssertNotError(t, err, "unvalidated OCSP response refused")
+	test.AssertByteEquals(t, resp, []byte("HI"))
+}
+
+func TestNoOCSPForPrecert(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	ca := &limitedCA{}
+	rp := newTestReprocessor(sa, ca)
+	rp.seen = newSerialCache(false)
+	rp.noOCSPForPrecert = true
+	logData := logLine(precertOrphan, testPreCertDER, "1001", "1") + "\n" +
+		logLine(certOrphan, testCertDER, "1001", "1") + "\n"
+
+	log.Clear()
+	sum := &summary{}
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, len(sa.certificates), 1)
+	// Only the certificate gets an OCSP response
+	test.AssertEquals(t, ca.calls, 1)
+	test.AssertContains(t, sum.String(), "precertsWithoutOCSP=1")
+	test.AssertEquals(t, len(log.GetAllMatching("Stored 1 precertificates without an OCSP response")), 1)
+}
+
+func TestSkipOCSP(t *testing.T) {
+	// A CA that can't generate OCSP doesn't stop orphans from being stored
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &failingCA{})
+	rp.seen = newSerialCache(false)
+	rp.skipOCSP = true
+	logData := logLine(precertOrphan, testPreCertDER, "1001", "1") + "\n" +
+		logLine(certOrphan, testCertDER, "1001", "1") + "\n"
+
+	log.Clear()
+	sum := &summary{}
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertContains(t, sum.String(), "precertsWithoutOCSP=1 certsWithoutOCSP=1")
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Stored 1 certificates without an OCSP response, backfill them with find-missing-ocsp --fix")), 1)
+}
+
+func TestNoOCSPServer(t *testing.T) {
+	_, err := parseNoOCSPServerPolicy("ignore")
+	test.AssertError(t, err, "unknown policy accepted")
+	withoutServer, _ := issueTestCert(t, 4242, "no-ocsp.example.com", false, nil, nil)
+	lines := []string{
+		logLine(certOrphan, hex.EncodeToString(withoutServer.Raw), "1001", "1"),
+		logLine(certOrphan, testCertDER, "1001", "2"),
+	}
+
+	for _, tc := range []struct {
+		policy   noOCSPServerPolicy
+		stored   int
+		calls    int
+		orphans  int64
+		response bool
+	}{
+		{policy: noOCSPServerGenerate, stored: 2, calls: 2, response: true},
+		{policy: noOCSPServerSkip, stored: 2, calls: 1, orphans: 1},
+		{policy: noOCSPServerFail, stored: 1, calls: 1, orphans: 1},
+	} {
+		t.Run(string(tc.policy), func(t *testing.T) {
+			sa := &mockSA{clk: clock.NewFake()}
+			ca := &limitedCA{}
+			rp := newTestReprocessor(sa, ca)
+			rp.onNoOCSPServer = tc.policy
+			// The orphan without an OCSP server is issued now, so a backdate
+			// would put its issued date in the future
+			rp.backdateDuration = 0
+			log.Clear()
+			for _, line := range lines {
+				rp.storeParsedLogLine(line)
+			}
+			test.AssertEquals(t, len(sa.certificates), tc.stored)
+			// Only the orphan without an OCSP server is handled by the policy
+			test.AssertEquals(t, ca.calls, tc.calls)
+			test.AssertEquals(t, rp.noOCSPServerOrphans, tc.orphans)
+			switch tc.policy {
+			case noOCSPServerSkip:
+				test.AssertEquals(t, rp.certsWithoutOCSP, int64(1))
+			case noOCSPServerFail:
+				test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Refusing to store certificate [0-9a-f]+ without an OCSP server`)), 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// errUsage is returned by parse if the arguments don't make up a valid
+// invocation of the command, which is answered with the usage.
+var errUsage = errors.New("invalid usage")
+
+// options are the settings of a run, taken from its flags and its config, and
+// the state set up from them. They are passed to the command that is run.
+type options struct {
+	command string
+	flags   *flag.FlagSet
+
+	configFile         string
+	logPath            string
+	derPaths           repeatedFlag
+	derDir             string
+	inspect            bool
+	inspectOnly        bool
+	pemChain           bool
+	regID              int64
+	statusName         string
+	revokedReason      int
+	revokedAt          string
+	continuationMarker string
+	allowProd          bool
+	progressEvery      int64
+	batchSize          int
+	dedupAcrossTypes   bool
+	onlyRegIDs         string
+	regIDEmail         string
+	regIDEmailAll      bool
+	canaryPath         string
+	canaryRegID        int64
+	budget             time.Duration
+	unit               string
+	since              string
+	until              string
+	follow             bool
+	force              bool
+	fix                bool
+	bucket             time.Duration
+
+	// pemOutPath, archivePath, rejectsPath and sqlitePath are the paths of
+	// the outputs openOutputs opens, or empty for those not written.
+	pemOutPath  string
+	archivePath string
+	rejectsPath string
+	sqlitePath  string
+	// The flags parse turns into the settings of rp.
+	responderURL   string
+	verifyAfter    bool
+	serials        string
+	serialsFile    string
+	onConflict     string
+	onNoOCSPServer string
+	issuedSince    string
+	shard          string
+
+	// strictBackdate causes a zero backdate to fail the run instead of only
+	// being warned about.
+	strictBackdate bool
+	// emitExemplars causes the exported counters to carry exemplars.
+	emitExemplars bool
+	// syslogTag is the tag orphan-finder's syslog entries carry, or empty for
+	// the name of the binary.
+	syslogTag string
+	// panicStatus is the exit status of a run that panicked.
+	panicStatus int
+	// outputFormat is the format of the summary written to stdout once the
+	// run is done.
+	outputFormat string
+	// waitForServices is how long setup waits for the SA and CA to become
+	// reachable. Zero doesn't wait at all and leaves the first RPC to fail if
+	// a service is down.
+	waitForServices time.Duration
+	// notifyURL is the Slack compatible webhook the end of a run is posted
+	// to, or empty to not post it.
+	notifyURL string
+	// startJitter is the upper bound of the random delay waited once the
+	// services are ready and before any orphan is stored.
+	startJitter time.Duration
+	// healthAddr is the address to serve the /healthz endpoint on, or empty
+	// to not serve it, and healthWindow the time without a line being
+	// processed after which the run is reported as stalled.
+	healthAddr   string
+	healthWindow time.Duration
+
+	// environment is the environment orphan-finder is configured for, e.g.
+	// "prod" or "staging", and ocspIssuers the certificates of the issuers
+	// the configured OCSP generator signs responses for, or nil if they
+	// aren't configured, in which case the issuers of a log aren't checked.
+	// Both are read from the config.
+	environment string
+	ocspIssuers []*x509.Certificate
+	// followStop, when set, causes parse-ca-log to follow its log like tail -f
+	// until it is closed, which catchSignals does on SIGTERM or SIGINT.
+	followStop chan struct{}
+
+	// rp is the reprocessor storing the orphans of the run, which holds the
+	// settings of how they are stored and the metrics of the run.
+	rp *reprocessor
+}
+
+// newOptions returns the options of a run of command with the default
+// settings, defining the flags that parse sets them from.
+func newOptions(command string) *options {
+	opts := &options{
+		command: command,
+		rp:      newReprocessor(),
+	}
+	rp := opts.rp
+	f := flag.NewFlagSet(command, flag.ContinueOnError)
+	opts.flags = f
+	f.StringVar(&opts.configFile, "config", "", "File path to the configuration file for this service")
+	f.StringVar(&opts.logPath, "log-file", "", "Path to boulder-ca log file to parse, which may be gzipped, or - to read stdin. parse-ca-log also takes a glob matching several")
+	f.Var(&opts.derPaths, "der-file", "Path to DER or PEM certificate file, which parse-der takes more than once to add several")
+	f.StringVar(&opts.derDir, "der-dir", "", "Path to a directory of DER certificate files, named *.der, for parse-der-dir")
+	f.BoolVar(&opts.inspect, "inspect", false, "Make parse-der print the fields of the orphan that matter to it before storing it")
+	f.BoolVar(&opts.inspectOnly, "inspect-only", false, "Like --inspect, but without storing the orphan")
+	f.BoolVar(&opts.pemChain, "der-from-pem-chain", false, "Read --der-file, or each file of --der-dir, as a PEM encoded chain and add only its leaf certificate")
+	f.Int64Var(&opts.regID, "regID", 0, "Registration ID of user who requested the certificate")
+	f.StringVar(&opts.statusName, "status", string(core.OCSPStatusGood), "OCSP status parse-der stores the orphan with: good or revoked")
+	f.IntVar(&opts.revokedReason, "reason", 0, "Revocation reason code parse-der stores a revoked orphan with")
+	f.StringVar(&opts.revokedAt, "revoked-at", "", "Time in RFC 3339 format parse-der stores a revoked orphan as revoked at")
+	f.StringVar(&opts.sqlitePath, "sqlite", "", "Path to a SQLite database to record every processed orphan in")
+	f.BoolVar(&rp.dryRun, "dry-run", false, "Check every orphan as if storing it and log the ones that would be added, without generating OCSP or writing to the database")
+	f.BoolVar(&rp.analysisOnly, "sqlite-only", false, "Only record orphans to the --sqlite database, never store them")
+	f.BoolVar(&rp.compareDER, "compare-der", false, "Compare orphans against the stored certificate with the same serial, reporting differing content as a conflict")
+	f.StringVar(&opts.continuationMarker, "continuation-marker", "", "Join log lines ending with this marker, e.g. \\, with the following line before parsing them")
+	f.BoolVar(&opts.allowProd, "i-know-this-is-prod", false, "Allow writing to a database whose config sets the environment to prod")
+	f.Int64Var(&opts.progressEvery, "progress-every", 10000, "Log progress, with an ETA when the input size is known, every this many lines (0 to disable)")
+	f.BoolVar(&rp.strictAge, "strict-age", false, "Skip orphans issued longer ago than the config's maxOrphanAge instead of storing them")
+	f.BoolVar(&opts.strictBackdate, "strict-backdate", false, "Refuse to run with a zero backdate instead of warning about it")
+	f.BoolVar(&rp.strictLabel, "strict-label", false, "Refuse orphans whose log line labels them as a certificate when their DER is a precertificate or vice versa")
+	f.BoolVar(&rp.strictRegID, "strict-regid", rp.strictRegID, "Reject orphans whose log line has a regID of 0 or less")
+	f.BoolVar(&opts.emitExemplars, "exemplars", false, "Attach the serial and run ID as an OpenMetrics exemplar to the counters exported on the config's debugAddr")
+	f.StringVar(&opts.responderURL, "verify-responder", "", "After the run, ask the OCSP responder at this URL for every added orphan and report any not served as good")
+	f.BoolVar(&opts.verifyAfter, "verify-after", false, "After the run, query the SA for every added orphan and report any missing from the database")
+	f.StringVar(&opts.syslogTag, "syslog-tag", "", "Tag to give syslog entries instead of the binary name, with {runID} replaced by the run ID")
+	f.BoolVar(&rp.verifyIssued, "verify-issued", false, "Fetch every stored orphan again and warn if its issued date differs from the one sent")
+	f.IntVar(&rp.rpcAttempts, "rpc-attempts", rp.rpcAttempts, "Number of times to attempt an SA or CA RPC failing with Unavailable or DeadlineExceeded before giving up")
+	f.DurationVar(&rp.rpcBackoffBase, "rpc-backoff", rp.rpcBackoffBase, "Backoff after an SA or CA RPC failed transiently, doubling with each further attempt")
+	f.IntVar(&rp.ocspRetries, "ocsp-retries", rp.ocspRetries, "Number of times to retry generating OCSP when the CA rate limits it")
+	f.StringVar(&opts.archivePath, "out-archive", "", "Path of a new .tar.gz archive to write the DER of every processed orphan to")
+	f.StringVar(&opts.pemOutPath, "pem-out", "", "Path to append every processed orphan to as PEM, with a comment giving its serial, type and outcome (- for stderr)")
+	f.StringVar(&opts.outputFormat, "output", outputText, "Format of the summary written to stdout at the end of the run: text, for none beyond the log, or json")
+	f.IntVar(&opts.panicStatus, "panic-status", defaultPanicStatus, "Exit status to use if orphan-finder panics")
+	f.IntVar(&rp.workers, "workers", rp.workers, "Number of orphans to store concurrently")
+	f.IntVar(&opts.batchSize, "batch-size", 1, "Number of orphans of a type parse-ca-log adds in a single call, if the SA supports it")
+	f.BoolVar(&opts.dedupAcrossTypes, "dedup-across-types", false, "Handle only one of the precertificate and certificate sharing a serial per run, instead of both")
+	f.StringVar(&opts.serials, "serials", "", "Comma separated list of serials to process, skipping all others")
+	f.StringVar(&opts.serialsFile, "serials-file", "", "Path to a file listing serials to process one per line, skipping all others")
+	f.StringVar(&opts.onlyRegIDs, "only-regids", "", "Comma separated list of registration IDs to store orphans for, skipping all others")
+	f.StringVar(&opts.regIDEmail, "regid-email", "", "Also store orphans for the registration with this contact email")
+	f.BoolVar(&opts.regIDEmailAll, "regid-email-all", false, "Accept every registration matching --regid-email instead of requiring a unique match")
+	f.StringVar(&rp.recoveryReason, "recovery-reason", rp.recoveryReason, "Reason recorded with the run ID in the audit entry of every orphan added")
+	f.DurationVar(&opts.waitForServices, "wait-for-services", 0, "How long to wait for the SA and CA to become reachable before giving up (0 to fail on the first RPC)")
+	f.StringVar(&opts.canaryPath, "canary-der", "", "Path to a DER certificate parse-ca-log adds and reads back before processing the log, aborting if that fails")
+	f.Int64Var(&opts.canaryRegID, "canary-regid", 0, "Registration ID to add the --canary-der certificate for")
+	f.DurationVar(&opts.budget, "budget", 0, "Wall-clock budget for parse-ca-log, from which each line's operations get their deadline (0 for no budget)")
+	f.StringVar(&opts.unit, "unit", "boulder-ca", "systemd unit whose journal parse-journal reads")
+	f.StringVar(&opts.since, "since", "", "Only read journal entries logged on or after this time, in any format journalctl accepts")
+	f.StringVar(&opts.until, "until", "", "Only read journal entries logged on or before this time, in any format journalctl accepts")
+	f.StringVar(&opts.onConflict, "on-conflict", string(conflictSkip), "What to do with an orphan whose serial is stored with different content: skip, overwrite or fail")
+	f.Int64Var(&rp.skipLines, "start-line", 0, "Skip the first this many lines of the log, to resume an interrupted parse-ca-log or parse-journal run")
+	f.StringVar(&opts.rejectsPath, "rejects-file", "", "Path to a file the log lines holding an orphan that couldn't be stored are appended to, each after a comment giving the reason")
+	f.Int64Var(&rp.defaultRegID, "default-regID", 0, "Registration ID of orphans whose log line has no regID, as in logs predating the regID token")
+	f.BoolVar(&opts.follow, "follow", false, "Make parse-ca-log keep reading its log as it grows, like tail -f, until SIGTERM or SIGINT")
+	f.BoolVar(&rp.reverseLines, "reverse", false, "Process the lines of the log from last to first, storing the most recent orphans first")
+	f.BoolVar(&rp.requireOrder, "require-order", false, "Only store orphans whose order still exists and belongs to their registration")
+	f.StringVar(&opts.healthAddr, "health-addr", "", "Address to serve a /healthz endpoint on, reporting whether lines are still being processed")
+	f.DurationVar(&opts.healthWindow, "health-window", defaultHealthWindow, "Time without a line processed after which /healthz reports the run as stalled")
+	f.BoolVar(&rp.skipOCSP, "skip-ocsp", false, "Store every orphan without generating an OCSP response, leaving them to be backfilled, e.g. while the OCSP generator is down")
+	f.BoolVar(&rp.noOCSPForPrecert, "no-ocsp-for-precert", false, "Store precertificate orphans without generating an OCSP response for them")
+	f.StringVar(&opts.onNoOCSPServer, "on-no-ocsp-server", string(noOCSPServerGenerate), "What to do with an orphan whose AIA extension names no OCSP server: generate an OCSP response anyway, skip generating one or fail")
+	f.BoolVar(&rp.skipOCSPValidation, "skip-ocsp-validation", false, "Store the OCSP responses generated by the CA without checking their serial and status")
+	f.StringVar(&opts.notifyURL, "notify-slack", "", "Slack compatible webhook URL to post the outcome and totals of the run to when it ends")
+	f.BoolVar(&rp.tolerateTrailing, "tolerate-trailing", false, "Parse orphan DER followed by trailing bytes without them instead of rejecting it")
+	f.DurationVar(&opts.startJitter, "start-jitter", 0, "Wait a random delay below this before processing, to spread the load of instances started together")
+	f.BoolVar(&opts.force, "force", false, "Process a log even if its orphans were issued by an issuer not in the config's ocspIssuerCerts")
+	f.BoolVar(&opts.fix, "fix", false, "Make find-missing-ocsp generate and store the OCSP responses it finds missing")
+	f.DurationVar(&rp.maxIssuedSkew, "max-issued-skew", rp.maxIssuedSkew, "How far in the future an orphan's backdated issued date may be before it is refused as a sign of a misconfigured backdate")
+	f.StringVar(&opts.issuedSince, "issued-since", "", "Skip orphans issued before this RFC 3339 time, judged by their backdated NotBefore")
+	f.StringVar(&opts.shard, "shard", "", "Only process the orphans of shard N of M, given as N/M, selected by the hash of their serial")
+	f.BoolVar(&rp.waitForWritable, "wait-for-writable", false, "Wait for a read-only SA to become writable again instead of stopping the run")
+	f.BoolVar(&rp.failFast, "fail-fast", false, "Stop parse-ca-log on the first orphan that fails to be stored instead of going on with the rest of the log")
+	f.IntVar(&rp.maxRegIDs, "max-regids", 0, "Stop parse-ca-log before storing orphans for more than this many distinct regIDs, 0 for no limit")
+	f.BoolVar(&rp.listRegIDs, "list-regids", false, "Log the IDs of the registrations orphans were added for once the run is done")
+	f.DurationVar(&opts.bucket, "bucket", time.Hour, "Width of the time buckets orphan-rate counts orphans in")
+
+	return opts
+}
+
+// parse sets the options from the command line arguments following the
+// command. It returns errUsage if they don't make up a valid invocation of the
+// command.
+func (opts *options) parse(args []string) error {
+	err := opts.flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	rp := opts.rp
+	command := opts.command
+
+	if opts.configFile == "" && command != "orphan-rate" && command != "issuers" {
+		return errUsage
+	}
+	if rp.analysisOnly && opts.sqlitePath == "" {
+		return errUsage
+	}
+	if rp.workers < 1 || opts.batchSize < 1 || rp.defaultRegID < 0 {
+		return errUsage
+	}
+	if rp.skipLines < 0 || (rp.skipLines > 0 && rp.reverseLines) {
+		return errUsage
+	}
+	if opts.follow {
+		if command != "parse-ca-log" || rp.reverseLines {
+			return errUsage
+		}
+		opts.followStop = make(chan struct{})
+	}
+	if (rp.failFast || opts.rejectsPath != "" || opts.batchSize > 1) && command != "parse-ca-log" && command != "parse-journal" {
+		return errUsage
+	}
+
+	opts.outputFormat, err = parseOutputFormat(opts.outputFormat)
+	if err != nil {
+		return fmt.Errorf("invalid --output: %s", err)
+	}
+	rp.onConflict, err = parseConflictPolicy(opts.onConflict)
+	if err != nil {
+		return fmt.Errorf("invalid --on-conflict: %s", err)
+	}
+	// Conflicts can only be told apart from orphans already stored by
+	// comparing their content
+	rp.compareDER = rp.compareDER || rp.onConflict != conflictSkip
+	rp.onNoOCSPServer, err = parseNoOCSPServerPolicy(opts.onNoOCSPServer)
+	if err != nil {
+		return fmt.Errorf("invalid --on-no-ocsp-server: %s", err)
+	}
+	rp.serialFilter, err = buildSerialFilter(opts.serials, opts.serialsFile)
+	if err != nil {
+		return fmt.Errorf("failed to build serial filter: %s", err)
+	}
+	rp.shard, err = parseShard(opts.shard)
+	if err != nil {
+		return fmt.Errorf("invalid --shard: %s", err)
+	}
+	if opts.issuedSince != "" {
+		rp.issuedSince, err = time.Parse(time.RFC3339, opts.issuedSince)
+		if err != nil {
+			return fmt.Errorf("invalid --issued-since: %s", err)
+		}
+	}
+	if rp.maxRegIDs > 0 {
+		rp.cappedRegIDs = newRegIDSet(rp.maxRegIDs)
+	}
+	if opts.verifyAfter {
+		rp.verifier = newAddedVerifier()
+	}
+	if opts.responderURL != "" {
+		rp.responderCheck = newResponderVerifier(opts.responderURL)
+	}
+	return nil
+}
+
+// usage prints the usage and the flags of the command and exits.
+func (opts *options) usage() {
+	fmt.Fprintf(os.Stderr, "%s\nargs:\n", usageString)
+	opts.flags.PrintDefaults()
+	os.Exit(1)
+}
+
+// openOutputs opens the PEM, archive, rejects and SQLite outputs of the run,
+// if any, which the invocation closes when the run ends.
+func (opts *options) openOutputs() error {
+	rp := opts.rp
+	var err error
+	if opts.pemOutPath != "" {
+		rp.pemOut, err = openPEMOut(opts.pemOutPath)
+		if err != nil {
+			return fmt.Errorf("failed to open PEM output: %s", err)
+		}
+	}
+	if opts.archivePath != "" {
+		rp.archiveOut, err = openArchiveOut(opts.archivePath)
+		if err != nil {
+			return fmt.Errorf("failed to open archive output: %s", err)
+		}
+	}
+	if opts.rejectsPath != "" {
+		rp.rejects, err = openRejects(opts.rejectsPath)
+		if err != nil {
+			return fmt.Errorf("failed to open rejects file: %s", err)
+		}
+	}
+	if opts.sqlitePath != "" {
+		rp.recordSink, err = openSQLiteSink(opts.sqlitePath)
+		if err != nil {
+			return fmt.Errorf("failed to open SQLite database: %s", err)
+		}
+	}
+	return nil
+}
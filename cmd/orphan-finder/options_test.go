@@ -0,0 +1,55 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestParseOptions(t *testing.T) {
+	opts := newOptions("parse-ca-log")
+	err := opts.parse([]string{"--config", "orphan-finder.json", "--workers", "4"})
+	test.AssertNotError(t, err, "parsing valid options failed")
+	test.AssertEquals(t, opts.configFile, "orphan-finder.json")
+	test.AssertEquals(t, opts.outputFormat, outputText)
+	test.AssertEquals(t, opts.panicStatus, defaultPanicStatus)
+	test.AssertEquals(t, opts.healthWindow, defaultHealthWindow)
+	test.Assert(t, opts.followStop == nil, "following without --follow")
+	// Flags of how orphans are stored set the reprocessor
+	test.AssertEquals(t, opts.rp.workers, 4)
+	test.Assert(t, opts.rp.strictRegID, "--strict-regid not on by default")
+	test.Assert(t, !opts.rp.compareDER, "comparing DER by default")
+
+	// A conflict can only be failed on when it is found by comparing DER
+	opts = newOptions("parse-ca-log")
+	err = opts.parse([]string{"--config", "orphan-finder.json", "--on-conflict", "fail", "--follow"})
+	test.AssertNotError(t, err, "parsing valid options failed")
+	test.Assert(t, opts.rp.compareDER, "--on-conflict fail doesn't compare DER")
+	test.Assert(t, opts.followStop != nil, "not following with --follow")
+
+	// Only orphan-rate and issuers run without a config
+	err = newOptions("orphan-rate").parse([]string{"--log-file", "ca.log"})
+	test.AssertNotError(t, err, "orphan-rate without a config refused")
+
+	for _, tc := range []struct {
+		command string
+		args    []string
+	}{
+		{"parse-ca-log", nil},
+		{"parse-ca-log", []string{"--config", "orphan-finder.json", "--workers", "0"}},
+		{"parse-ca-log", []string{"--config", "orphan-finder.json", "--batch-size", "0"}},
+		{"parse-der", []string{"--config", "orphan-finder.json", "--batch-size", "10"}},
+		{"parse-ca-log", []string{"--config", "orphan-finder.json", "--sqlite-only"}},
+		{"parse-ca-log", []string{"--config", "orphan-finder.json", "--start-line", "10", "--reverse"}},
+		{"parse-der", []string{"--config", "orphan-finder.json", "--follow"}},
+		{"reconcile", []string{"--config", "orphan-finder.json", "--fail-fast"}},
+	} {
+		err := newOptions(tc.command).parse(tc.args)
+		test.AssertEquals(t, err, errUsage)
+	}
+
+	// An invalid value is reported as such rather than answered with the usage
+	err = newOptions("parse-ca-log").parse([]string{"--config", "orphan-finder.json", "--output", "yaml"})
+	test.AssertError(t, err, "unknown output format accepted")
+	test.Assert(t, err != errUsage, "unknown output format answered with the usage")
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+
+	corepb "github.com/letsencrypt/boulder/core/proto"
+	berrors "github.com/letsencrypt/boulder/errors"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// orderGetter is implemented by storage authorities able to look up an order.
+// The gRPC SA client supports it, but it isn't part of certificateStorage.
+type orderGetter interface {
+	GetOrder(ctx context.Context, req *sapb.OrderRequest) (*corepb.Order, error)
+}
+
+var orderOrphan = regexp.MustCompile(`orderID=\[(\d+)\]`)
+
+// checkOrder looks up the order with the given ID, as named by the orphan's
+// source. It returns a reason if the source names no order or the order doesn't
+// exist or doesn't belong to regID, and an error if it can't be looked up.
+func checkOrder(ctx context.Context, sa certificateStorage, orderStr string, regID int64) (string, error) {
+	getter, ok := sa.(orderGetter)
+	if !ok {
+		return "", errors.New("the SA does not support looking up orders")
+	}
+	if orderStr == "" {
+		return "log line names no order", nil
+	}
+	orderID, err := strconv.ParseInt(orderStr, 10, 64)
+	if err != nil || orderID <= 0 {
+		return fmt.Sprintf("invalid order ID %q", orderStr), nil
+	}
+	useV2Authorizations := true
+	order, err := getter.GetOrder(ctx, &sapb.OrderRequest{Id: &orderID, UseV2Authorizations: &useV2Authorizations})
+	if berrors.Is(err, berrors.NotFound) {
+		return fmt.Sprintf("order %d not found", orderID), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to look up order %d: %s", orderID, err)
+	}
+	if order.GetRegistrationID() != regID {
+		return fmt.Sprintf("order %d belongs to registration %d", orderID, order.GetRegistrationID()), nil
+	}
+	return "", nil
+}
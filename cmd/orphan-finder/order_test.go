@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	corepb "github.com/letsencrypt/boulder/core/proto"
+	berrors "github.com/letsencrypt/boulder/errors"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+// orderSA is a mockSA able to look up orders, mapping order IDs to the
+// registration they belong to. Order 666 can't be looked up.
+type orderSA struct {
+	mockSA
+	orders map[int64]int64
+}
+
+func (m *orderSA) GetOrder(_ context.Context, req *sapb.OrderRequest) (*corepb.Order, error) {
+	if req.GetId() == 666 {
+		return nil, errors.New("SA unavailable")
+	}
+	regID, ok := m.orders[req.GetId()]
+	if !ok {
+		return nil, berrors.NotFoundError("no order found for ID %d", req.GetId())
+	}
+	return &corepb.Order{Id: req.Id, RegistrationID: &regID}, nil
+}
+
+func TestCheckOrder(t *testing.T) {
+	ctx := context.Background()
+	sa := &orderSA{orders: map[int64]int64{1: 1001}}
+
+	_, err := checkOrder(ctx, &mockSA{}, "1", 1001)
+	test.AssertError(t, err, "SA without order lookups accepted")
+
+	for _, tc := range []struct {
+		orderID string
+		regID   int64
+		reason  string
+	}{
+		{orderID: "1", regID: 1001},
+		{orderID: "1", regID: 1002, reason: "order 1 belongs to registration 1001"},
+		{orderID: "2", regID: 1001, reason: "order 2 not found"},
+		{orderID: "0", regID: 1001, reason: `invalid order ID "0"`},
+	} {
+		reason, err := checkOrder(ctx, sa, tc.orderID, tc.regID)
+		test.AssertNotError(t, err, "checking order failed")
+		test.AssertEquals(t, reason, tc.reason)
+	}
+
+	reason, err := checkOrder(ctx, sa, "", 1001)
+	test.AssertNotError(t, err, "checking order failed")
+	test.AssertEquals(t, reason, "log line names no order")
+
+	_, err = checkOrder(ctx, sa, "666", 1001)
+	test.AssertError(t, err, "failed lookup treated as missing order")
+}
+
+func TestRequireOrder(t *testing.T) {
+	sa := &orderSA{mockSA: mockSA{clk: clock.NewFake()}, orders: map[int64]int64{1: 1001}}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.requireOrder = true
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "2"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.orderlessOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] Skipping certificate [0-9a-f]+ without a backing order: order 2 not found`)), 1)
+
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "1"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.orderlessOrphans, int64(1))
+
+	// Lookups go to the read replica
+	split := splitStorage{certificateStorage: &mockSA{clk: clock.NewFake()}, reader: sa}
+	reason, err := checkOrder(context.Background(), split, "1", 1001)
+	test.AssertNotError(t, err, "checking order through read replica failed")
+	test.AssertEquals(t, reason, "")
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+const (
+	// outputText writes no summary beyond the log lines.
+	outputText = "text"
+	// outputJSON writes the summary to stdout as a single JSON object.
+	outputJSON = "json"
+)
+
+// parseOutputFormat returns the output format with the given name.
+func parseOutputFormat(name string) (string, error) {
+	switch name {
+	case outputText, outputJSON:
+		return name, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, expected %s or %s", name, outputText, outputJSON)
+}
+
+// jsonSummary is the summary of a run written for --output json.
+type jsonSummary struct {
+	Command             string  `json:"command"`
+	Status              int     `json:"status"`
+	RunID               string  `json:"runID"`
+	CertOrphansFound    int64   `json:"certOrphansFound"`
+	CertOrphansAdded    int64   `json:"certOrphansAdded"`
+	PrecertOrphansFound int64   `json:"precertOrphansFound"`
+	PrecertOrphansAdded int64   `json:"precertOrphansAdded"`
+	OrphansFailed       int64   `json:"orphansFailed"`
+	ParseFailures       int64   `json:"parseFailures"`
+	DurationSeconds     float64 `json:"durationSeconds"`
+	// The serials of the orphans by outcome, sorted so that runs over the
+	// same input write identical summaries.
+	AddedSerials       []string `json:"addedSerials"`
+	SkippedSerials     []string `json:"skippedSerials"`
+	ConflictingSerials []string `json:"conflictingSerials"`
+	FailedSerials      []string `json:"failedSerials"`
+}
+
+// writeSummary writes the summary of a run that ended with the given status,
+// in which failed orphans couldn't be added and parseFailed couldn't be
+// parsed, to w as a single line of JSON if format is outputJSON. Failing to do
+// so is only logged, as it mustn't change the outcome of the run.
+func writeSummary(logger blog.Logger, w io.Writer, format, command, runID string, status int, sum *summary, failed, parseFailed int64, elapsed time.Duration) {
+	if format != outputJSON {
+		return
+	}
+	added, skipped, conflicting, failures := sum.serials.sorted()
+	err := json.NewEncoder(w).Encode(jsonSummary{
+		Command:             command,
+		Status:              status,
+		RunID:               runID,
+		CertOrphansFound:    atomic.LoadInt64(&sum.certOrphansFound),
+		CertOrphansAdded:    atomic.LoadInt64(&sum.certOrphansAdded),
+		PrecertOrphansFound: atomic.LoadInt64(&sum.precertOrphansFound),
+		PrecertOrphansAdded: atomic.LoadInt64(&sum.precertOrphansAdded),
+		OrphansFailed:       failed,
+		ParseFailures:       parseFailed,
+		DurationSeconds:     elapsed.Seconds(),
+		AddedSerials:        added,
+		SkippedSerials:      skipped,
+		ConflictingSerials:  conflicting,
+		FailedSerials:       failures,
+	})
+	if err != nil {
+		logger.Warningf("Failed to write JSON summary: %s", err)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestWriteSummary(t *testing.T) {
+	_, err := parseOutputFormat("yaml")
+	test.AssertError(t, err, "unknown output format accepted")
+
+	sum := &summary{certOrphansFound: 3, certOrphansAdded: 2, precertOrphansFound: 1}
+	// Workers record orphans in whichever order they finish them
+	for _, res := range []orphanResult{
+		{stored: true, serial: "03"},
+		{skipped: true, serial: "05"},
+		{err: errContentConflict, conflict: true, serial: "07"},
+		{stored: true, serial: "01"},
+		{stored: true, conflict: true, serial: "06"},
+		{err: errors.New("broken"), serial: "04"},
+		{queued: true, serial: "08"},
+		{err: errors.New("unparsable")},
+		{skipped: true, serial: "02"},
+	} {
+		sum.serials.record(res)
+	}
+
+	// Nothing is written by default
+	var buf bytes.Buffer
+	writeSummary(log, &buf, outputText, "parse-ca-log", "testrun", 0, sum, 1, 4, time.Second)
+	test.AssertEquals(t, buf.Len(), 0)
+
+	format, err := parseOutputFormat("json")
+	test.AssertNotError(t, err, "json output format rejected")
+	writeSummary(log, &buf, format, "parse-ca-log", "testrun", 1, sum, 1, 4, 1500*time.Millisecond)
+	var got jsonSummary
+	err = json.Unmarshal(buf.Bytes(), &got)
+	test.AssertNotError(t, err, "parsing JSON summary")
+	test.AssertDeepEquals(t, got, jsonSummary{
+		Command:             "parse-ca-log",
+		Status:              1,
+		RunID:               "testrun",
+		CertOrphansFound:    3,
+		CertOrphansAdded:    2,
+		PrecertOrphansFound: 1,
+		OrphansFailed:       1,
+		ParseFailures:       4,
+		DurationSeconds:     1.5,
+		AddedSerials:        []string{"01", "03", "06"},
+		SkippedSerials:      []string{"02", "05"},
+		ConflictingSerials:  []string{"06", "07"},
+		FailedSerials:       []string{"04"},
+	})
+	// It's a single line
+	test.AssertEquals(t, bytes.Count(buf.Bytes(), []byte("\n")), 1)
+
+	// And identical for the same orphans recorded in another order
+	other := &summary{certOrphansFound: 3, certOrphansAdded: 2, precertOrphansFound: 1}
+	for _, res := range []orphanResult{
+		{skipped: true, serial: "02"},
+		{err: errors.New("broken"), serial: "04"},
+		{stored: true, serial: "01"},
+		{stored: true, conflict: true, serial: "06"},
+		{err: errContentConflict, conflict: true, serial: "07"},
+		{skipped: true, serial: "05"},
+		{stored: true, serial: "03"},
+	} {
+		other.serials.record(res)
+	}
+	var otherBuf bytes.Buffer
+	writeSummary(log, &otherBuf, format, "parse-ca-log", "testrun", 1, other, 1, 4, 1500*time.Millisecond)
+	test.AssertEquals(t, otherBuf.String(), buf.String())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// issues returns true if issuer appears to be the issuer of cert, judging by
+// its subject and, if both are present, its key identifier.
+func issues(issuer, cert *x509.Certificate) bool {
+	if issuer == cert || !bytes.Equal(issuer.RawSubject, cert.RawIssuer) {
+		return false
+	}
+	if len(issuer.SubjectKeyId) > 0 && len(cert.AuthorityKeyId) > 0 {
+		return bytes.Equal(issuer.SubjectKeyId, cert.AuthorityKeyId)
+	}
+	return true
+}
+
+// pemCertificateHeader starts a PEM encoded certificate.
+const pemCertificateHeader = "-----BEGIN CERTIFICATE-----"
+
+// isPEMCertificate returns true if data starts with a PEM encoded certificate,
+// ignoring leading whitespace.
+func isPEMCertificate(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(data, " \t\r\n"), []byte(pemCertificateHeader))
+}
+
+// decodePEMCertificate returns the DER of the PEM encoded certificate at the
+// start of data.
+func decodePEMCertificate(data []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("decoding PEM certificate: no valid PEM block found")
+	}
+	if block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("decoding PEM certificate: found %s block", block.Type)
+	}
+	return block.Bytes, nil
+}
+
+// leafFromPEMChain returns the DER of the leaf certificate of the PEM encoded
+// chain in data. The leaf is the one certificate that isn't a CA and doesn't
+// issue any other certificate in the chain. Every other certificate is skipped
+// with a warning. An error is returned unless there is exactly one leaf.
+func leafFromPEMChain(logger blog.Logger, data []byte) ([]byte, error) {
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse certificate %d of PEM chain: %s", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("No certificates found in PEM chain")
+	}
+
+	var leaves []*x509.Certificate
+	for _, cert := range certs {
+		isLeaf := !(cert.BasicConstraintsValid && cert.IsCA)
+		for _, other := range certs {
+			if issues(cert, other) {
+				isLeaf = false
+				break
+			}
+		}
+		if isLeaf {
+			leaves = append(leaves, cert)
+		}
+	}
+	if len(leaves) != 1 {
+		return nil, fmt.Errorf("Found %d leaf certificates in PEM chain of %d certificates, expected exactly one",
+			len(leaves), len(certs))
+	}
+	for _, cert := range certs {
+		if cert != leaves[0] {
+			logger.Warningf("Skipping non-leaf certificate %s (%s) in PEM chain",
+				core.SerialToString(cert.SerialNumber), cert.Subject)
+		}
+	}
+	return leaves[0].Raw, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+// issueTestCert issues a certificate for subject signed by parent and
+// parentKey, or self-signed if parent is nil.
+func issueTestCert(t *testing.T, serial int64, subject string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "failed to generate key")
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: subject},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	test.AssertNotError(t, err, "failed to create certificate")
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "failed to parse certificate")
+	return cert, key
+}
+
+func pemBundle(certs ...*x509.Certificate) []byte {
+	var buf bytes.Buffer
+	for _, cert := range certs {
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	}
+	return buf.Bytes()
+}
+
+func TestLeafFromPEMChain(t *testing.T) {
+	root, rootKey := issueTestCert(t, 1, "root", true, nil, nil)
+	intermediate, intermediateKey := issueTestCert(t, 2, "intermediate", true, root, rootKey)
+	leaf, _ := issueTestCert(t, 3, "leaf.example.com", false, intermediate, intermediateKey)
+
+	// The leaf is found wherever it is in the chain
+	for _, bundle := range [][]byte{
+		pemBundle(leaf, intermediate),
+		pemBundle(intermediate, leaf),
+		pemBundle(root, intermediate, leaf),
+	} {
+		log.Clear()
+		der, err := leafFromPEMChain(log, bundle)
+		test.AssertNotError(t, err, "failed to find leaf")
+		test.AssertByteEquals(t, der, leaf.Raw)
+		test.Assert(t, len(log.GetAllMatching("WARNING: Skipping non-leaf certificate")) > 0, "intermediate not skipped with a warning")
+	}
+
+	// A lone leaf is returned without warnings
+	log.Clear()
+	der, err := leafFromPEMChain(log, pemBundle(leaf))
+	test.AssertNotError(t, err, "failed to find leaf")
+	test.AssertByteEquals(t, der, leaf.Raw)
+	test.AssertEquals(t, len(log.GetAll()), 0)
+
+	otherLeaf, _ := issueTestCert(t, 4, "other.example.com", false, intermediate, intermediateKey)
+	_, err = leafFromPEMChain(log, pemBundle(leaf, otherLeaf, intermediate))
+	test.AssertError(t, err, "chain with two leaves accepted")
+	_, err = leafFromPEMChain(log, pemBundle(intermediate))
+	test.AssertError(t, err, "chain without a leaf accepted")
+	_, err = leafFromPEMChain(log, []byte("not PEM"))
+	test.AssertError(t, err, "input without certificates accepted")
+}
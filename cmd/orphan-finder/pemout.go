@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// Outcomes of processing an orphan, as written to the PEM output.
+const (
+	outcomeAdded         = "added"
+	outcomeAlreadyExists = "already-exists"
+	outcomeNotAdded      = "not-added"
+	// outcomeQueued is the outcome of an orphan queued to be added in a
+	// batch, whose result is logged once the batch is stored.
+	outcomeQueued = "queued"
+)
+
+// pemWriter writes processed orphans as PEM blocks, each preceded by a comment
+// line describing it. Text outside of PEM blocks is ignored by standard
+// tooling, so the output can be read back by it.
+type pemWriter struct {
+	sync.Mutex
+	w io.Writer
+	c io.Closer
+}
+
+// openPEMOut opens the file at path for appending PEM blocks to, or stderr if
+// path is "-".
+func openPEMOut(path string) (*pemWriter, error) {
+	if path == "-" {
+		return &pemWriter{w: os.Stderr}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &pemWriter{w: f, c: f}, nil
+}
+
+// write writes cert as a PEM block preceded by a comment giving its serial,
+// type and the outcome of processing it. It does nothing if p is nil.
+func (p *pemWriter) write(cert *x509.Certificate, typ orphanType, outcome string) error {
+	if p == nil {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	_, err := fmt.Fprintf(p.w, "# serial=%s type=%s outcome=%s\n",
+		core.SerialToString(cert.SerialNumber), typ, outcome)
+	if err != nil {
+		return err
+	}
+	return pem.Encode(p.w, &pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// Close closes the underlying file, if it isn't stderr.
+func (p *pemWriter) Close() error {
+	if p == nil || p.c == nil {
+		return nil
+	}
+	return p.c.Close()
+}
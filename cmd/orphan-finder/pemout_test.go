@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestParseLinePEMOut(t *testing.T) {
+	var buf bytes.Buffer
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.pemOut = &pemWriter{w: &buf}
+	rp.seen = newSerialCache(false)
+
+	log.Clear()
+	for i := 0; i < 2; i++ {
+		_ = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	}
+	rp.seen = nil
+	_ = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	_ = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "0", "0"))
+	test.AssertEquals(t, len(log.GetAllMatching("PEM output")), 0)
+
+	// An orphan seen twice in a run is only written once
+	out := buf.String()
+	test.AssertEquals(t, strings.Count(out, "# serial=ffa0160630d618b2eb5c0510824b14274856 type=certificate outcome=added\n"), 1)
+	test.AssertEquals(t, strings.Count(out, "# serial=ffa0160630d618b2eb5c0510824b14274856 type=certificate outcome=already-exists\n"), 1)
+	test.AssertEquals(t, strings.Count(out, "# serial=03e1dea6f3349009a90e0306dbb39c3e7ca2 type=precertificate outcome=not-added\n"), 1)
+
+	// The output can be read back as PEM
+	var blocks int
+	rest := buf.Bytes()
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		_, err := x509.ParseCertificate(block.Bytes)
+		test.AssertNotError(t, err, "failed to parse certificate from PEM output")
+		blocks++
+	}
+	test.AssertEquals(t, blocks, 3)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// rfc6962PoisonOID is the OID of the CT poison extension marking a
+// precertificate, as defined by RFC 6962 Section 3.1 -
+// https://tools.ietf.org/html/rfc6962#section-3.1
+var rfc6962PoisonOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// parseOID parses an OID in dotted decimal notation, e.g. 1.3.6.1.4.1.11129.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %q, expected at least two dotted numbers", s)
+	}
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %q: %q isn't a number", s, part)
+		}
+		oid[i] = n
+	}
+	return oid, nil
+}
+
+// buildPoisonOIDs returns the RFC 6962 poison OID followed by the extra ones
+// given in dotted decimal notation, for precertificates issued with a
+// pre-standard marker, or an error if any of them doesn't parse.
+func buildPoisonOIDs(extra []string) ([]asn1.ObjectIdentifier, error) {
+	oids := []asn1.ObjectIdentifier{rfc6962PoisonOID}
+	for _, s := range extra {
+		oid, err := parseOID(strings.TrimSpace(s))
+		if err != nil {
+			return nil, err
+		}
+		oids = append(oids, oid)
+	}
+	return oids, nil
+}
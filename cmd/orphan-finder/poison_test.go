@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestBuildPoisonOIDs(t *testing.T) {
+	oids, err := buildPoisonOIDs(nil)
+	test.AssertNotError(t, err, "no extra OIDs rejected")
+	test.AssertDeepEquals(t, oids, []asn1.ObjectIdentifier{rfc6962PoisonOID})
+
+	oids, err = buildPoisonOIDs([]string{" 1.2.3.4 "})
+	test.AssertNotError(t, err, "valid OID rejected")
+	test.AssertDeepEquals(t, oids, []asn1.ObjectIdentifier{rfc6962PoisonOID, {1, 2, 3, 4}})
+
+	for _, bad := range []string{"", "1", "1.2.x", "1.-2"} {
+		_, err = buildPoisonOIDs([]string{bad})
+		test.AssertError(t, err, "invalid OID "+bad+" accepted")
+	}
+}
+
+func TestOrphanTypeExtraPoison(t *testing.T) {
+	legacyPoison := asn1.ObjectIdentifier{1, 2, 3, 4}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "generating key")
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1338),
+		Subject:      pkix.Name{CommonName: "legacy.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{Id: legacyPoison, Critical: true, Value: []byte{0x05, 0x00}},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	test.AssertNotError(t, err, "creating certificate")
+	legacy, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "parsing certificate")
+
+	f := defaultLogFormat()
+	test.AssertEquals(t, f.orphanType(legacy), certOrphan)
+	test.AssertEquals(t, f.orphanType(mustParseHexCert(t, testPreCertDER)), precertOrphan)
+
+	f.poisonOIDs, err = buildPoisonOIDs([]string{"1.2.3.4"})
+	test.AssertNotError(t, err, "building poison OIDs")
+	test.AssertEquals(t, f.orphanType(legacy), precertOrphan)
+	// The RFC 6962 poison is still recognised
+	test.AssertEquals(t, f.orphanType(mustParseHexCert(t, testPreCertDER)), precertOrphan)
+	test.AssertEquals(t, f.orphanType(mustParseHexCert(t, testCertDER)), certOrphan)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmhodges/clock"
+	blog "github.com/letsencrypt/boulder/log"
+)
+
+// progress periodically logs how far a run has got through its input.
+type progress struct {
+	logger blog.Logger
+	clk    clock.Clock
+	// every is the number of lines between progress logs, or zero to never log
+	// progress.
+	every int64
+	// totalBytes is the size of the input, or zero if it isn't known in
+	// advance, in which case no ETA is logged.
+	totalBytes int64
+	start      time.Time
+	lines      int64
+	bytes      int64
+}
+
+func newProgress(logger blog.Logger, clk clock.Clock, every, totalBytes int64) *progress {
+	return &progress{
+		logger:     logger,
+		clk:        clk,
+		every:      every,
+		totalBytes: totalBytes,
+		start:      clk.Now(),
+	}
+}
+
+// advance records that a line of lineBytes bytes, including its newline, was
+// processed and logs the progress if it's due.
+func (p *progress) advance(lineBytes int, sum *summary) {
+	p.lines++
+	p.bytes += int64(lineBytes)
+	if p.every > 0 && p.lines%p.every == 0 {
+		p.logger.Info(p.String(sum))
+	}
+}
+
+// eta estimates the time remaining from the throughput so far. It returns
+// false if there's no basis for an estimate.
+func (p *progress) eta() (time.Duration, bool) {
+	if p.totalBytes <= 0 || p.bytes <= 0 {
+		return 0, false
+	}
+	remaining := p.totalBytes - p.bytes
+	if remaining < 0 {
+		remaining = 0
+	}
+	elapsed := p.clk.Since(p.start)
+	return time.Duration(float64(elapsed) * float64(remaining) / float64(p.bytes)), true
+}
+
+// String describes the progress so far.
+func (p *progress) String(sum *summary) string {
+	elapsed := p.clk.Since(p.start)
+	var rate float64
+	if elapsed > 0 {
+		rate = float64(p.lines) / elapsed.Seconds()
+	}
+	msg := fmt.Sprintf("Processed %d lines, %s, elapsed %s, %.1f lines/s",
+		p.lines, sum, elapsed.Round(time.Second), rate)
+	if eta, ok := p.eta(); ok {
+		msg += fmt.Sprintf(", %d%% done, ETA %s",
+			p.bytes*100/p.totalBytes, eta.Round(time.Second))
+	}
+	return msg
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestProgress(t *testing.T) {
+	fc := clock.NewFake()
+	sum := &summary{}
+	sum.count(certOrphan, true)
+
+	log.Clear()
+	p := newProgress(log, fc, 2, 400)
+	p.advance(100, sum)
+	test.AssertEquals(t, len(log.GetAll()), 0)
+	fc.Add(10 * time.Second)
+	p.advance(100, sum)
+	// Half of the input took 10s so the other half should take another 10s
+	test.AssertDeepEquals(t, log.GetAll(), []string{
+		"INFO: Processed 2 lines, certOrphansFound=1 certOrphansAdded=1 precertOrphansFound=0 precertOrphansAdded=0, " +
+			"elapsed 10s, 0.2 lines/s, 50% done, ETA 10s",
+	})
+
+	// Without a known input size there's no ETA
+	log.Clear()
+	p = newProgress(log, fc, 1, 0)
+	fc.Add(time.Second)
+	p.advance(100, sum)
+	test.AssertDeepEquals(t, log.GetAll(), []string{
+		"INFO: Processed 1 lines, certOrphansFound=1 certOrphansAdded=1 precertOrphansFound=0 precertOrphansAdded=0, " +
+			"elapsed 1s, 1.0 lines/s",
+	})
+
+	// Progress logging can be disabled
+	log.Clear()
+	p = newProgress(log, fc, 0, 0)
+	p.advance(100, sum)
+	test.AssertEquals(t, len(log.GetAll()), 0)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// lineTimestamp returns the timestamp syslog prefixed a log line with, or
+// false if the line doesn't start with an RFC 3339 timestamp.
+func lineTimestamp(line string) (time.Time, bool) {
+	field := line
+	if i := strings.IndexByte(line, ' '); i >= 0 {
+		field = line[:i]
+	}
+	t, err := time.Parse(time.RFC3339Nano, field)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// rateBucket counts the orphans logged within one bucket of time.
+type rateBucket struct {
+	start    time.Time
+	certs    int
+	precerts int
+}
+
+// orphanRate is a series of the orphans logged per bucket of time.
+type orphanRate struct {
+	format  *logFormat
+	width   time.Duration
+	buckets map[time.Time]*rateBucket
+	first   time.Time
+	last    time.Time
+	// untimed counts the orphans whose line has no timestamp.
+	untimed int
+}
+
+func newOrphanRate(format *logFormat, width time.Duration) *orphanRate {
+	return &orphanRate{
+		format:  format,
+		width:   width,
+		buckets: make(map[time.Time]*rateBucket),
+	}
+}
+
+// add counts the orphan in a log line, if there is one.
+func (r *orphanRate) add(line string) {
+	typ := orphanTypeForLabel(line)
+	if typ == unknownOrphan || !r.format.cert.MatchString(line) {
+		return
+	}
+	t, ok := lineTimestamp(line)
+	if !ok {
+		r.untimed++
+		return
+	}
+	start := t.UTC().Truncate(r.width)
+	b, ok := r.buckets[start]
+	if !ok {
+		b = &rateBucket{start: start}
+		r.buckets[start] = b
+	}
+	if typ == certOrphan {
+		b.certs++
+	} else {
+		b.precerts++
+	}
+	if r.first.IsZero() || start.Before(r.first) {
+		r.first = start
+	}
+	if start.After(r.last) {
+		r.last = start
+	}
+}
+
+// writeCSV writes the series as CSV, one row per bucket between the first and
+// last orphan, including empty ones, with the rate given in orphans per hour.
+func (r *orphanRate) writeCSV(w io.Writer) error {
+	_, err := fmt.Fprintln(w, "bucketStart,certificates,precertificates,orphans,orphansPerHour")
+	if err != nil {
+		return err
+	}
+	if len(r.buckets) == 0 {
+		return nil
+	}
+	for start := r.first; !start.After(r.last); start = start.Add(r.width) {
+		b, ok := r.buckets[start]
+		if !ok {
+			b = &rateBucket{start: start}
+		}
+		orphans := b.certs + b.precerts
+		_, err = fmt.Fprintf(w, "%s,%d,%d,%d,%.2f\n", start.Format(time.RFC3339),
+			b.certs, b.precerts, orphans, float64(orphans)/r.width.Hours())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestLineTimestamp(t *testing.T) {
+	ts, ok := lineTimestamp("2020-07-01T12:34:56.123456+02:00 hostname boulder-ca[1]: message")
+	test.AssertEquals(t, ok, true)
+	test.Assert(t, ts.Equal(time.Date(2020, 7, 1, 10, 34, 56, 123456000, time.UTC)), "wrong timestamp")
+	_, ok = lineTimestamp("0000-00-00T00:00:00+00:00 hostname boulder-ca[1]: message")
+	test.AssertEquals(t, ok, false)
+	_, ok = lineTimestamp("")
+	test.AssertEquals(t, ok, false)
+}
+
+func TestOrphanRate(t *testing.T) {
+	timed := func(ts string, typ orphanType) string {
+		return strings.Replace(logLine(typ, "abcd", "1", "0"), "0000-00-00T00:00:00+00:00", ts, 1)
+	}
+	r := newOrphanRate(defaultLogFormat(), 30*time.Minute)
+	for _, line := range []string{
+		timed("2020-07-01T10:05:00Z", certOrphan),
+		timed("2020-07-01T10:29:59Z", precertOrphan),
+		timed("2020-07-01T10:31:00Z", precertOrphan),
+		timed("2020-07-01T11:45:00Z", certOrphan),
+		timed("2020-07-01T11:46:00Z", unknownOrphan),
+		logLine(certOrphan, "abcd", "1", "0"),
+		"2020-07-01T11:47:00Z hostname boulder-ca[1]: unrelated",
+	} {
+		r.add(line)
+	}
+	test.AssertEquals(t, r.untimed, 1)
+
+	var buf bytes.Buffer
+	test.AssertNotError(t, r.writeCSV(&buf), "writeCSV failed")
+	test.AssertEquals(t, buf.String(), "bucketStart,certificates,precertificates,orphans,orphansPerHour\n"+
+		"2020-07-01T10:00:00Z,1,1,2,4.00\n"+
+		"2020-07-01T10:30:00Z,0,1,1,2.00\n"+
+		"2020-07-01T11:00:00Z,0,0,0,0.00\n"+
+		"2020-07-01T11:30:00Z,1,0,1,2.00\n")
+}
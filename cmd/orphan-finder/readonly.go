@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mysqlReadOnly and mysqlReadOnlyTransaction are the MySQL error numbers
+	// returned for writes while the server is read-only.
+	mysqlReadOnly            = 1290
+	mysqlReadOnlyTransaction = 1792
+	// writablePollInterval is the time waited between attempts to store an
+	// orphan while the SA is read-only and waitForWritable is set.
+	writablePollInterval = 30 * time.Second
+)
+
+// isReadOnly returns true if err indicates that the SA refused a write because
+// its database is read-only, as it is during maintenance. The SA has no error
+// type of its own for this, so the MySQL error is recognized either directly
+// or by its message once it has been passed on over gRPC.
+func isReadOnly(err error) bool {
+	if err == nil {
+		return false
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok {
+		return mysqlErr.Number == mysqlReadOnly || mysqlErr.Number == mysqlReadOnlyTransaction
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "read-only option") || strings.Contains(msg, "READ ONLY transaction")
+}
+
+// storeWhenWritable calls write, which stores one or more orphans, as an RPC
+// retried by callWriteRPC if it fails transiently. If the SA refuses the write because it is
+// read-only, write is retried every writablePollInterval until it is writable
+// again if rp.waitForWritable is set, and rp.readOnlyAbort is set otherwise.
+func (rp *reprocessor) storeWhenWritable(ctx context.Context, write func(ctx context.Context) error) error {
+	logger := rp.logger
+	for {
+		err := rp.callWriteRPC(ctx, write)
+		if !isReadOnly(err) {
+			return err
+		}
+		if !rp.waitForWritable {
+			rp.abortRun(&rp.readOnlyAbort, fmt.Errorf("the SA is read-only: %s", err))
+			logger.AuditErrf("The SA refused a write because it is read-only, stopping the run: %s", err)
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+		logger.Warningf("The SA is read-only, retrying in %s: %s", writablePollInterval, err)
+		sleep(writablePollInterval)
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmhodges/clock"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errReadOnly = status.Error(codes.Unknown,
+	"Error 1290: The MySQL server is running with the --read-only option so it cannot execute this statement")
+
+// readOnlySA is a mockSA whose database is read-only for the given number of
+// writes.
+type readOnlySA struct {
+	mockSA
+	readOnlyWrites int
+}
+
+func (m *readOnlySA) AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error) {
+	if m.readOnlyWrites > 0 {
+		m.readOnlyWrites--
+		return "", errReadOnly
+	}
+	return m.mockSA.AddCertificate(ctx, req)
+}
+
+func TestIsReadOnly(t *testing.T) {
+	test.AssertEquals(t, isReadOnly(nil), false)
+	test.AssertEquals(t, isReadOnly(errors.New("duplicate entry")), false)
+	test.AssertEquals(t, isReadOnly(errReadOnly), true)
+	test.AssertEquals(t, isReadOnly(&mysql.MySQLError{Number: mysqlReadOnly}), true)
+	test.AssertEquals(t, isReadOnly(&mysql.MySQLError{Number: mysqlReadOnlyTransaction}), true)
+	test.AssertEquals(t, isReadOnly(&mysql.MySQLError{Number: 1062}), false)
+}
+
+func TestReadOnlyStopsRun(t *testing.T) {
+	log.Clear()
+	rp := newTestReprocessor(&readOnlySA{mockSA: mockSA{clk: clock.NewFake()}, readOnlyWrites: 1}, &mockCA{})
+
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "1"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertError(t, rp.readOnlyAbort, "read-only SA didn't stop the run")
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] The SA refused a write because it is read-only`)), 1)
+	test.AssertEquals(t, rp.runStopped(), rp.readOnlyAbort)
+}
+
+func TestWaitForWritable(t *testing.T) {
+	defer func() { sleep = time.Sleep }()
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	log.Clear()
+	rp := newTestReprocessor(&readOnlySA{mockSA: mockSA{clk: clock.NewFake()}, readOnlyWrites: 2}, &mockCA{})
+	rp.waitForWritable = true
+
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "1"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertNotError(t, rp.readOnlyAbort, "run stopped while waiting for a writable SA")
+	test.AssertDeepEquals(t, slept, []time.Duration{writablePollInterval, writablePollInterval})
+}
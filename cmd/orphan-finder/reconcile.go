@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// reconcileResult tallies the orphans of a log by whether they are stored.
+type reconcileResult struct {
+	// missing and present count the distinct orphans missing from and
+	// already stored in the DB.
+	missing int64
+	present int64
+	// unparsable counts the lines whose orphan couldn't be parsed, and failed
+	// the orphans that couldn't be looked up.
+	unparsable int64
+	failed     int64
+}
+
+func (r reconcileResult) String() string {
+	return fmt.Sprintf("missing=%d present=%d unparsable=%d failed=%d",
+		r.missing, r.present, r.unparsable, r.failed)
+}
+
+// reconcileLog looks up every orphan in the log read from r without storing
+// any, calling report once for each orphan missing from the DB. Lines are read
+// as by reprocessLogs. An orphan found on several lines is only looked up once.
+func (rp *reprocessor) reconcileLog(ctx context.Context, prog *progress, r io.Reader, continuationMarker string, report func(orphanType, string)) (reconcileResult, error) {
+	logger := rp.logger
+	var res reconcileResult
+	src := rp.newLogSource(prog, &summary{}, r, continuationMarker)
+	seen := make(map[serialKey]bool)
+	for {
+		der, _, meta, err := src.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			res.unparsable += src.malformed
+			return res, err
+		}
+		cert, _, err := rp.parseOrphanDER(der, meta.origin)
+		if err != nil {
+			res.unparsable++
+			logger.Errf("Failed to parse orphan DER: %s, [%s]", err, meta.origin)
+			continue
+		}
+		typ := rp.format.orphanType(cert)
+		serial := core.SerialToString(cert.SerialNumber)
+		key := serialKey{serial: serial, typ: typ}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		_, _, err = rp.checkCert(ctx, rp.sa, cert)
+		switch err {
+		case nil:
+			res.missing++
+			report(typ, serial)
+		case errAlreadyExists, errContentConflict:
+			res.present++
+		default:
+			res.failed++
+			logger.Errf("%s, [%s]", err, meta.origin)
+		}
+	}
+	res.unparsable += src.malformed
+	return res, nil
+}
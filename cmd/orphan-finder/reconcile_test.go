@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestReconcileLog(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	// The certificate is already stored
+	stored := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, stored.stored, true)
+
+	logData := logLine(precertOrphan, testPreCertDER, "1001", "0") + "\n" +
+		logLine(certOrphan, testCertDER, "1001", "0") + "\n" +
+		logLine(precertOrphan, "deadbeef", "1001", "0") + "\n" +
+		logLine(certOrphan, "zz", "1001", "0") + "\n" +
+		logLine(precertOrphan, testPreCertDER, "1002", "0") + "\n"
+	var reported []string
+	log.Clear()
+	res, err := rp.reconcileLog(context.Background(), newProgress(log, clock.NewFake(), 0, 0),
+		strings.NewReader(logData), "", func(typ orphanType, serial string) {
+			reported = append(reported, serial+" "+typ.String())
+		})
+	test.AssertNotError(t, err, "reconciling log")
+	test.AssertEquals(t, res.String(), "missing=1 present=1 unparsable=2 failed=0")
+	test.AssertDeepEquals(t, reported, []string{"03e1dea6f3349009a90e0306dbb39c3e7ca2 precertificate"})
+	// Nothing is stored
+	test.AssertEquals(t, len(sa.precertificates), 0)
+	test.AssertEquals(t, len(sa.certificates), 1)
+
+	_, err = rp.reconcileLog(context.Background(), newProgress(log, clock.NewFake(), 0, 0),
+		&failingReader{}, "", func(orphanType, string) {})
+	test.AssertError(t, err, "reconciling an unreadable log succeeded")
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// registrationsByContact is implemented by storage authorities able to look up
+// the registrations using a contact address. It isn't part of the SA's gRPC
+// interface, so resolving a contact fails unless the configured SA supports it.
+type registrationsByContact interface {
+	RegistrationIDsByContact(ctx context.Context, contact string) ([]int64, error)
+}
+
+// parseRegIDList parses a comma separated list of registration IDs.
+func parseRegIDList(list string) ([]int64, error) {
+	var regIDs []int64
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		regID, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regID %q: %s", s, err)
+		}
+		regIDs = append(regIDs, regID)
+	}
+	return regIDs, nil
+}
+
+// resolveRegIDsByEmail returns the IDs of the registrations with the given
+// email as a contact. If more than one registration matches, an error listing
+// them is returned unless acceptAll is true.
+func resolveRegIDsByEmail(ctx context.Context, sa certificateStorage, email string, acceptAll bool) ([]int64, error) {
+	resolver, ok := sa.(registrationsByContact)
+	if !ok {
+		return nil, errors.New("the SA does not support looking up registrations by contact")
+	}
+	contact := email
+	if !strings.HasPrefix(contact, "mailto:") {
+		contact = "mailto:" + contact
+	}
+	regIDs, err := resolver.RegistrationIDsByContact(ctx, contact)
+	if err != nil {
+		return nil, err
+	}
+	if len(regIDs) == 0 {
+		return nil, fmt.Errorf("no registrations found with contact %q", contact)
+	}
+	if len(regIDs) > 1 && !acceptAll {
+		return nil, fmt.Errorf("%d registrations found with contact %q (%v), "+
+			"pass the intended one with --only-regids or use --regid-email-all",
+			len(regIDs), contact, regIDs)
+	}
+	return regIDs, nil
+}
+
+// buildRegIDFilter returns the regIDFilter for the given comma separated list
+// of registration IDs and an optional email to resolve to more of them. It
+// returns nil, allowing all registrations, when neither is provided.
+func buildRegIDFilter(ctx context.Context, sa certificateStorage, list, email string, acceptAll bool) (map[int64]bool, error) {
+	regIDs, err := parseRegIDList(list)
+	if err != nil {
+		return nil, err
+	}
+	if email != "" {
+		resolved, err := resolveRegIDsByEmail(ctx, sa, email, acceptAll)
+		if err != nil {
+			return nil, err
+		}
+		regIDs = append(regIDs, resolved...)
+	}
+	if len(regIDs) == 0 {
+		return nil, nil
+	}
+	filter := make(map[int64]bool, len(regIDs))
+	for _, regID := range regIDs {
+		filter[regID] = true
+	}
+	return filter, nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+// contactSA is a mockSA able to look up registrations by contact.
+type contactSA struct {
+	mockSA
+	contacts map[string][]int64
+}
+
+func (m *contactSA) RegistrationIDsByContact(_ context.Context, contact string) ([]int64, error) {
+	return m.contacts[contact], nil
+}
+
+func TestBuildRegIDFilter(t *testing.T) {
+	ctx := context.Background()
+	sa := &contactSA{contacts: map[string][]int64{
+		"mailto:one@example.com":  {1},
+		"mailto:many@example.com": {2, 3},
+	}}
+
+	filter, err := buildRegIDFilter(ctx, sa, "", "", false)
+	test.AssertNotError(t, err, "empty filter failed")
+	test.Assert(t, filter == nil, "empty filter should allow all registrations")
+
+	filter, err = buildRegIDFilter(ctx, sa, "10, 11,", "one@example.com", false)
+	test.AssertNotError(t, err, "filter failed")
+	test.AssertDeepEquals(t, filter, map[int64]bool{1: true, 10: true, 11: true})
+
+	_, err = buildRegIDFilter(ctx, sa, "", "many@example.com", false)
+	test.AssertError(t, err, "ambiguous email should require disambiguation")
+	filter, err = buildRegIDFilter(ctx, sa, "", "mailto:many@example.com", true)
+	test.AssertNotError(t, err, "accepting all matches failed")
+	test.AssertDeepEquals(t, filter, map[int64]bool{2: true, 3: true})
+
+	// A read replica resolves the email if it supports doing so
+	split := splitStorage{certificateStorage: &mockSA{}, reader: sa}
+	filter, err = buildRegIDFilter(ctx, split, "", "one@example.com", false)
+	test.AssertNotError(t, err, "resolving through a read replica failed")
+	test.AssertDeepEquals(t, filter, map[int64]bool{1: true})
+
+	_, err = buildRegIDFilter(ctx, sa, "", "nobody@example.com", false)
+	test.AssertError(t, err, "unknown email should fail")
+	_, err = buildRegIDFilter(ctx, sa, "ten", "", false)
+	test.AssertError(t, err, "invalid regID should fail")
+	_, err = buildRegIDFilter(ctx, &mockSA{}, "", "one@example.com", false)
+	test.AssertError(t, err, "SA without contact lookup should fail")
+}
+
+func TestParseLineRegIDFilter(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.regIDFilter = map[int64]bool{1001: true}
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "9999", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, true)
+	checkNoErrors(t)
+}
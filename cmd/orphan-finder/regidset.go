@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// maxTrackedRegIDs bounds the memory used to track the distinct registrations
+// touched by a run. Beyond it the count is a lower bound.
+const maxTrackedRegIDs = 100000
+
+// regIDSet tracks the distinct registration IDs of the orphans added by a run,
+// to quantify how many accounts it affected.
+type regIDSet struct {
+	sync.Mutex
+	max int
+	ids map[int64]struct{}
+	// overflowed is set once an ID was dropped because the set was full.
+	overflowed bool
+}
+
+func newRegIDSet(max int) *regIDSet {
+	return &regIDSet{max: max, ids: make(map[int64]struct{})}
+}
+
+// add records regID. It does nothing if s is nil.
+func (s *regIDSet) add(regID int64) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.ids[regID]; ok {
+		return
+	}
+	if len(s.ids) >= s.max {
+		s.overflowed = true
+		return
+	}
+	s.ids[regID] = struct{}{}
+}
+
+// admit records regID and returns true, unless the set is full and regID isn't
+// already in it. It always returns true if s is nil.
+func (s *regIDSet) admit(regID int64) bool {
+	if s == nil {
+		return true
+	}
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.ids[regID]; ok {
+		return true
+	}
+	if len(s.ids) >= s.max {
+		return false
+	}
+	s.ids[regID] = struct{}{}
+	return true
+}
+
+// admitRegID returns true if an orphan for regID may be stored without the run
+// touching more than rp.maxRegIDs distinct registrations. Otherwise it sets
+// rp.regIDAbort to stop the run.
+func (rp *reprocessor) admitRegID(typ orphanType, serial string, regID int64, origin string) bool {
+	if rp.cappedRegIDs.admit(regID) {
+		return true
+	}
+	rp.abortRun(&rp.regIDAbort, fmt.Errorf("%s %s would touch more than %d distinct regIDs", typ, serial, rp.maxRegIDs))
+	rp.logger.AuditErrf("%s %s for registration %d exceeds the limit of %d distinct regIDs, stopping the run, [%s]",
+		typ, serial, regID, rp.maxRegIDs, origin)
+	return false
+}
+
+// list returns the recorded IDs in ascending order.
+func (s *regIDSet) list() []int64 {
+	s.Lock()
+	defer s.Unlock()
+	ids := make([]int64, 0, len(s.ids))
+	for id := range s.ids {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// String returns the number of distinct IDs, followed by a + if there were
+// more than could be tracked.
+func (s *regIDSet) String() string {
+	s.Lock()
+	defer s.Unlock()
+	if s.overflowed {
+		return fmt.Sprintf("%d+", len(s.ids))
+	}
+	return fmt.Sprintf("%d", len(s.ids))
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestRegIDSet(t *testing.T) {
+	var nilSet *regIDSet
+	nilSet.add(1)
+
+	s := newRegIDSet(3)
+	test.AssertEquals(t, s.String(), "0")
+	for _, id := range []int64{1003, 1001, 1003, 1002} {
+		s.add(id)
+	}
+	test.AssertEquals(t, s.String(), "3")
+	test.AssertDeepEquals(t, s.list(), []int64{1001, 1002, 1003})
+
+	// Once full, known IDs are still accepted but new ones only mark the count
+	// as a lower bound
+	s.add(1001)
+	test.AssertEquals(t, s.String(), "3")
+	s.add(1004)
+	test.AssertEquals(t, s.String(), "3+")
+	test.AssertDeepEquals(t, s.list(), []int64{1001, 1002, 1003})
+
+	sum := &summary{regIDs: s}
+	test.AssertEquals(t, sum.String(),
+		"certOrphansFound=0 certOrphansAdded=0 precertOrphansFound=0 precertOrphansAdded=0 distinctRegIDs=3+")
+}
+
+func TestTouchedRegIDs(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "1"))
+	test.AssertEquals(t, res.stored, true)
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1002", "1"))
+	test.AssertEquals(t, res.stored, true)
+	// Orphans that aren't added don't count
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1003", "1"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertDeepEquals(t, rp.touchedRegIDs.list(), []int64{1001, 1002})
+}
+
+func TestMaxRegIDs(t *testing.T) {
+	var nilSet *regIDSet
+	test.AssertEquals(t, nilSet.admit(1), true)
+
+	log.Clear()
+	rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+	rp.maxRegIDs = 1
+	rp.cappedRegIDs = newRegIDSet(rp.maxRegIDs)
+
+	res := rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "1"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertNotError(t, rp.regIDAbort, "run aborted within the limit")
+	// A second registration exceeds the limit and stops the run
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1002", "1"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertError(t, rp.regIDAbort, "run not aborted beyond the limit")
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] certificate .* for registration 1002 exceeds the limit of 1 distinct regIDs`)), 1)
+	// Registrations already admitted are still accepted
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "1"))
+	test.AssertEquals(t, res.stored, true)
+}
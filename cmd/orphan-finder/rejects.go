@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// rejectWriter appends the log lines holding an orphan that couldn't be
+// stored to a file, each preceded by a comment line giving the reason, so that
+// they can be fixed up and fed back to parse-ca-log, which ignores the
+// comments. Writes are buffered until the file is closed.
+type rejectWriter struct {
+	sync.Mutex
+	f      *os.File
+	w      *bufio.Writer
+	closed bool
+}
+
+// openRejects opens the rejects file at path, creating it if needed. Lines
+// are appended, so that the rejects of several runs can share a file.
+func openRejects(path string) (*rejectWriter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &rejectWriter{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// add records line as rejected for the given reason. It does nothing if r is
+// nil or line is empty, as an orphan that wasn't read from a log has no line
+// to retry.
+func (r *rejectWriter) add(line string, reason error) error {
+	if r == nil || line == "" {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return errors.New("rejects file already closed")
+	}
+	comment := strings.Replace(reason.Error(), "\n", " ", -1)
+	_, err := fmt.Fprintf(r.w, "# rejected: %s\n%s\n", comment, line)
+	return err
+}
+
+// Close flushes the rejected lines and closes the file. Only the first call
+// has any effect, and it does nothing if r is nil.
+func (r *rejectWriter) Close() error {
+	if r == nil {
+		return nil
+	}
+	r.Lock()
+	defer r.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err := r.w.Flush()
+	if fErr := r.f.Close(); err == nil {
+		err = fErr
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestRejectsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir failed")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rejects.log")
+	test.AssertNotError(t, ioutil.WriteFile(path, []byte("earlier\n"), 0600), "writing rejects file failed")
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+	rp.rejects, err = openRejects(path)
+	test.AssertNotError(t, err, "opening rejects file failed")
+
+	badHex := logLine(certOrphan, "abc", "1001", "0")
+	badDER := logLine(certOrphan, "deadbeef", "1001", "0")
+	noRegID := logLine(precertOrphan, testPreCertDER, "", "0")
+	good := logLine(certOrphan, testCertDER, "1001", "0")
+	logData := strings.Join([]string{"unrelated", badHex, badDER, noRegID, good, good}, "\n")
+	log.Clear()
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, len(sa.certificates), 1)
+
+	// Finishing the run flushes the rejects file
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: &summary{}, opts: newTestOptions(rp)}
+	inv.finish(0, "")
+	test.AssertError(t, rp.rejects.add(good, errMalformedLine), "write to closed rejects file accepted")
+	test.AssertNotError(t, rp.rejects.Close(), "closing rejects file twice failed")
+
+	// Only the lines whose orphan failed are appended, each after its reason
+	data, err := ioutil.ReadFile(path)
+	test.AssertNotError(t, err, "reading rejects file failed")
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	test.AssertEquals(t, len(lines), 7)
+	test.AssertEquals(t, lines[0], "earlier")
+	test.Assert(t, strings.HasPrefix(lines[1], "# rejected: couldn't decode hex: "), lines[1])
+	test.AssertEquals(t, lines[2], badHex)
+	test.Assert(t, strings.HasPrefix(lines[3], "# rejected: "), lines[3])
+	test.AssertEquals(t, lines[4], badDER)
+	test.AssertEquals(t, lines[5], "# rejected: regID variable is empty")
+	test.AssertEquals(t, lines[6], noRegID)
+
+	// The rejects file can be fed back, its comments holding no orphan
+	rp.rejects = nil
+	rp.seen = newSerialCache(false)
+	sum := &summary{}
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("rejects.log", strings.NewReader(string(data))))
+	test.AssertContains(t, sum.String(), "certOrphansFound=0 certOrphansAdded=0 precertOrphansFound=1 precertOrphansAdded=0")
+}
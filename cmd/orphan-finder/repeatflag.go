@@ -0,0 +1,19 @@
+package main
+
+import "strings"
+
+// repeatedFlag is a flag.Value collecting every value of a flag that may be
+// given more than once, in order.
+type repeatedFlag []string
+
+func (f *repeatedFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return strings.Join(*f, ",")
+}
+
+func (f *repeatedFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestRepeatedFlag(t *testing.T) {
+	var files repeatedFlag
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Var(&files, "der-file", "")
+	test.AssertNotError(t, fs.Parse([]string{"--der-file", "a.der", "--der-file=b.pem"}), "parsing flags")
+	test.AssertDeepEquals(t, []string(files), []string{"a.der", "b.pem"})
+	test.AssertEquals(t, files.String(), "a.der,b.pem")
+}
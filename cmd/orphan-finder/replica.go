@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/letsencrypt/boulder/core"
+	corepb "github.com/letsencrypt/boulder/core/proto"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// splitStorage is a certificateStorage that sends existence checks to a read
+// replica SA and writes to the primary SA, so that a large recovery doesn't
+// load the primary with reads.
+type splitStorage struct {
+	// certificateStorage is the primary SA, used for writes.
+	certificateStorage
+	reader certificateStorage
+}
+
+func (s splitStorage) GetCertificate(ctx context.Context, serial string) (core.Certificate, error) {
+	return s.reader.GetCertificate(ctx, serial)
+}
+
+func (s splitStorage) GetPrecertificate(ctx context.Context, serial *sapb.Serial) (*corepb.Certificate, error) {
+	return s.reader.GetPrecertificate(ctx, serial)
+}
+
+// RegistrationIDsByContact looks up registrations using the read replica, if
+// it supports doing so.
+func (s splitStorage) RegistrationIDsByContact(ctx context.Context, contact string) ([]int64, error) {
+	resolver, ok := s.reader.(registrationsByContact)
+	if !ok {
+		return nil, errors.New("the SA does not support looking up registrations by contact")
+	}
+	return resolver.RegistrationIDsByContact(ctx, contact)
+}
+
+// GetOrder looks up orders using the read replica, if it supports doing so.
+func (s splitStorage) GetOrder(ctx context.Context, req *sapb.OrderRequest) (*corepb.Order, error) {
+	getter, ok := s.reader.(orderGetter)
+	if !ok {
+		return nil, errors.New("the SA does not support looking up orders")
+	}
+	return getter.GetOrder(ctx, req)
+}
+
+// GetCertificateStatus looks up certificate statuses using the read replica, if
+// it supports doing so.
+func (s splitStorage) GetCertificateStatus(ctx context.Context, serial string) (core.CertificateStatus, error) {
+	getter, ok := s.reader.(certificateStatusGetter)
+	if !ok {
+		return core.CertificateStatus{}, errors.New("the SA does not support looking up certificate statuses")
+	}
+	return getter.GetCertificateStatus(ctx, serial)
+}
+
+// primaryStorage returns the primary SA of sa, for reads that must see the
+// writes of the run even if a read replica is lagging behind.
+func primaryStorage(sa certificateStorage) certificateStorage {
+	if split, ok := sa.(splitStorage); ok {
+		return split.certificateStorage
+	}
+	return sa
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestSplitStorage(t *testing.T) {
+	primary := &countingSA{}
+	replica := &countingSA{}
+	rp := newTestReprocessor(splitStorage{certificateStorage: primary, reader: replica}, &mockCA{})
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	checkNoErrors(t)
+
+	// Existence checks went to the replica and writes to the primary
+	test.AssertEquals(t, replica.lookups, 2)
+	test.AssertEquals(t, primary.lookups, 0)
+	test.AssertEquals(t, len(replica.certificates)+len(replica.precertificates), 0)
+	test.AssertEquals(t, len(primary.certificates), 1)
+	test.AssertEquals(t, len(primary.precertificates), 1)
+}
@@ -0,0 +1,839 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/letsencrypt/boulder/cmd"
+	"github.com/letsencrypt/boulder/core"
+	blog "github.com/letsencrypt/boulder/log"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// reprocessor adds the orphans of a run to the database: it reads them from
+// their source, checks each against the settings of the run and the DB, and
+// stores those missing from it along with a fresh OCSP response. It holds the
+// settings, outputs and tallies of the run, so that nothing outlives the run.
+// Its counters are updated atomically, as orphans may be stored by several
+// workers at once.
+type reprocessor struct {
+	sa     certificateStorage
+	ca     ocspGenerator
+	logger blog.Logger
+	// stats and health are the orphanMetrics and healthMonitor of the run, or
+	// nil if metrics aren't exported or health isn't served.
+	stats  *orphanMetrics
+	health *healthMonitor
+	// runID identifies the run in exemplars and the audit log.
+	runID string
+	// format is how orphans are recognised in a log line and by their
+	// extensions. It is read from the config.
+	format *logFormat
+	// inv is the invocation of the running command. It is set once a command
+	// starts so that a panic, in main or in a worker, can record the end of the
+	// run.
+	inv *invocation
+	// interruptStop, when set, causes the first SIGTERM or SIGINT to stop the
+	// run gracefully rather than at once, by closing it. Commands processing a
+	// log set it before starting, stop at the current line once it's closed
+	// and then call failOnInterrupt. interruptSignal is the signal it was
+	// closed for.
+	interruptStop   chan struct{}
+	interruptSignal os.Signal
+
+	// backdateDuration is the backdate the CA subtracted from the time an
+	// orphan was issued to get its NotBefore. It is read from the config.
+	backdateDuration time.Duration
+	// maxIssuedSkew is how far in the future an orphan's issued date may be
+	// before it is refused, allowing for clock skew between the CA and
+	// orphan-finder.
+	maxIssuedSkew time.Duration
+	// issuedSince, if set, causes orphans issued before it to be skipped. The
+	// issued date is the backdated NotBefore, as the SA would store it.
+	issuedSince time.Time
+	// maxOrphanAge, if positive, is how far in the past an orphan's issued
+	// date may be before it is reported as implausible. It is read from the
+	// config. strictAge causes such orphans to be skipped instead of stored.
+	maxOrphanAge time.Duration
+	strictAge    bool
+	// strictLabel causes orphans whose log line label disagrees with the type
+	// derived from their DER to be refused instead of stored as the type of
+	// their DER.
+	strictLabel bool
+	// strictRegID causes orphans with a non-positive regID to be rejected
+	// instead of being stored for a nonexistent account.
+	strictRegID bool
+	// defaultRegID, if positive, is the regID of orphans whose log line has no
+	// regID, as those of logs predating the regID token don't. A regID in the
+	// line takes precedence.
+	defaultRegID int64
+	// analysisOnly causes orphans to be looked up and recorded but never
+	// stored, and dryRun causes them to be checked as if they were to be
+	// stored, but neither their OCSP response generated nor they stored.
+	analysisOnly bool
+	dryRun       bool
+	// compareDER causes an orphan whose serial already exists in the DB to be
+	// compared against the stored certificate, and onConflict is the policy
+	// applied if they differ.
+	compareDER bool
+	onConflict conflictPolicy
+	// failFast causes the run to stop on the first orphan that fails to be
+	// stored, rather than going on with the rest of its input.
+	failFast bool
+	// waitForWritable causes writes refused because the SA is read-only to be
+	// retried until it is writable again instead of stopping the run.
+	waitForWritable bool
+	// listRegIDs causes the registrations touched by the run to be logged
+	// once it is done.
+	listRegIDs bool
+	// maxRegIDs is the number of distinct registrations the run may store
+	// orphans for before it is aborted, or zero for no limit.
+	maxRegIDs int
+	// rpcAttempts is the number of times an RPC to the SA or CA is attempted
+	// when it keeps failing transiently, and rpcBackoffBase the backoff after
+	// the first failure, doubling with each further one.
+	rpcAttempts    int
+	rpcBackoffBase time.Duration
+	// rpcTimeout bounds each RPC made to the SA and CA for an orphan, so that
+	// a wedged service fails the orphan instead of hanging the run. It is
+	// read from the config's grpcTimeout. There is no bound if it is zero.
+	rpcTimeout time.Duration
+	// allowedSigAlgs is the set of signature algorithms orphans may be signed
+	// with, or nil to allow any. It is read from the config.
+	allowedSigAlgs map[x509.SignatureAlgorithm]bool
+	// trustedIssuers are the certificates of the issuers orphans must be
+	// signed by to be stored, or nil if orphans from any issuer are stored.
+	// They are read from the config.
+	trustedIssuers []*x509.Certificate
+	// allowedIssuerKeyIDs and deniedIssuerKeyIDs are the hex encoded subject
+	// key identifiers of the issuers whose orphans may be stored and of those
+	// whose orphans are always rejected, matched against an orphan's
+	// authority key identifier. Either is nil if it isn't configured, and
+	// orphans from any issuer not denied are stored if allowedIssuerKeyIDs is
+	// nil. They are read from the config.
+	allowedIssuerKeyIDs, deniedIssuerKeyIDs map[string]bool
+	// tolerateTrailing causes DER that fails to parse because of bytes
+	// following the certificate to be parsed without them.
+	tolerateTrailing bool
+	// verifyIssued causes every stored orphan to be fetched again to check
+	// that the SA stored the issued date it was sent.
+	verifyIssued bool
+	// requireOrder causes orphans to only be stored if the order they were
+	// issued for still exists and belongs to their registration.
+	requireOrder bool
+	// ocspRetries is the number of times OCSP generation is retried when the
+	// CA rate limits it.
+	ocspRetries int
+	// noOCSPForPrecert causes precertificate orphans, and skipOCSP every
+	// orphan, to be stored without an OCSP response. skipOCSPValidation causes
+	// the OCSP responses generated by the CA to be stored without checking
+	// that they are for the orphan's serial and assert the requested status.
+	noOCSPForPrecert   bool
+	skipOCSP           bool
+	skipOCSPValidation bool
+	// onNoOCSPServer is the policy applied to orphans without an OCSP server.
+	onNoOCSPServer noOCSPServerPolicy
+	// workers is the number of orphans processSource stores at once.
+	workers int
+	// skipLines is the number of physical lines at the start of the input a
+	// logSource skips, to resume a run interrupted after processing them.
+	skipLines int64
+	// reverseLines causes a logSource to yield the lines of a log from last to
+	// first, so that the most recent orphans are stored first.
+	reverseLines bool
+	// serialFilter, if not nil, is the set of canonical serials orphans must
+	// have in order to be processed, and shard, if not nil, the shard of
+	// serials the run processes. Orphans with any other serial are skipped.
+	serialFilter map[string]bool
+	shard        *shardSpec
+	// regIDFilter, if not nil, is the set of registration IDs orphans must
+	// belong to in order to be stored.
+	regIDFilter map[int64]bool
+	// downgradedAuditErrors is the set of categories of malformed lines
+	// logged as warnings instead of audit errors. It is read from the config.
+	downgradedAuditErrors map[string]bool
+	// recoveryReason is recorded alongside the run ID for every orphan added.
+	recoveryReason string
+
+	// pemOut, archiveOut, rejects and recordSink are where processed orphans
+	// are written as PEM, archived, where the lines of rejected ones are
+	// written and where processed orphans are recorded, if anywhere. invocation
+	// closes them when the run ends.
+	pemOut     *pemWriter
+	archiveOut *archiveWriter
+	rejects    *rejectWriter
+	recordSink orphanSink
+	// verifier and responderCheck record the orphans added to check that the
+	// DB and the OCSP responder have them once the run is done, if they are
+	// checked.
+	verifier       *addedVerifier
+	responderCheck *responderVerifier
+
+	// batcher, if not nil, queues the orphans to be added in batches instead
+	// of one at a time.
+	batcher *orphanBatcher
+	// seen, if not nil, is consulted before looking an orphan up in the DB
+	// and updated with the outcome, so that a serial appearing more than once
+	// in the run is only looked up and added once.
+	seen *serialCache
+	// funnel counts the log lines reaching each stage of parsing.
+	funnel *parseFunnel
+	// budget is the runBudget of the run, or nil if it has no budget.
+	budget *runBudget
+	// touchedRegIDs tracks the registrations orphans were added for, and
+	// cappedRegIDs, if maxRegIDs is set, those the run has tried to store
+	// orphans for.
+	touchedRegIDs *regIDSet
+	cappedRegIDs  *regIDSet
+
+	// abortMu guards conflictAbort, regIDAbort, readOnlyAbort and storeAbort,
+	// which may be set by several workers while the source reads runStopped.
+	// Each is set to stop the run: conflictAbort once a conflict was found
+	// under conflictFail, regIDAbort once an orphan was refused by admitRegID,
+	// readOnlyAbort once a write was refused because the SA is read-only and
+	// waitForWritable isn't set, and storeAbort once an orphan failed to be
+	// stored under failFast.
+	abortMu       sync.Mutex
+	conflictAbort error
+	regIDAbort    error
+	readOnlyAbort error
+	storeAbort    error
+
+	// failedOrphans counts the orphans that couldn't be added for any reason
+	// but already being stored or being skipped on purpose, which fail the
+	// run, and parseFailures those that couldn't be parsed from their log
+	// line or DER.
+	failedOrphans int64
+	parseFailures int64
+	// The orphans skipped or rejected for the reason given by their name.
+	tooOldOrphans         int64
+	implausiblyOldOrphans int64
+	labelMismatches       int64
+	invalidRegIDs         int64
+	duplicatesSkipped     int64
+	// pendingSkipped are the duplicatesSkipped that arrived while an earlier
+	// line with the serial was still being stored. They aren't retried if
+	// that line fails.
+	pendingSkipped        int64
+	dryRunOrphans         int64
+	contentConflicts      int64
+	issuedMismatches      int64
+	deniedIssuerOrphans   int64
+	unlistedIssuerOrphans int64
+	untrustedOrphans      int64
+	disallowedSigAlgs     int64
+	noOCSPServerOrphans   int64
+	orderlessOrphans      int64
+	trailingStripped      int64
+	// precertsWithoutOCSP and certsWithoutOCSP count the orphans stored
+	// without an OCSP response because of skipOCSP, noOCSPForPrecert or
+	// onNoOCSPServer.
+	precertsWithoutOCSP int64
+	certsWithoutOCSP    int64
+	// rpcRetries counts the RPCs retried after failing transiently.
+	rpcRetries int64
+}
+
+// newReprocessor returns a reprocessor with the default settings, which
+// stores nothing until its SA, CA and logger are set.
+func newReprocessor() *reprocessor {
+	return &reprocessor{
+		maxIssuedSkew:  5 * time.Minute,
+		strictRegID:    true,
+		onConflict:     conflictSkip,
+		rpcAttempts:    3,
+		rpcBackoffBase: time.Second,
+		ocspRetries:    5,
+		onNoOCSPServer: noOCSPServerGenerate,
+		workers:        1,
+		recoveryReason: "orphan",
+		runID:          newRunID(),
+		format:         defaultLogFormat(),
+		funnel:         &parseFunnel{},
+		touchedRegIDs:  newRegIDSet(maxTrackedRegIDs),
+	}
+}
+
+// countParseFailure records that an orphan couldn't be parsed.
+func (rp *reprocessor) countParseFailure() {
+	atomic.AddInt64(&rp.parseFailures, 1)
+	rp.stats.parseFailed()
+}
+
+// errMalformedLine is the error of the orphanResult of a log line meant to
+// hold an orphan that couldn't be extracted from it.
+var errMalformedLine = errors.New("malformed orphan log line")
+
+// orphanResult is the outcome of storing an orphan. At most one of stored,
+// queued, skipped and err is set.
+type orphanResult struct {
+	// matched is set by storeParsedLogLine if the line was meant to hold an
+	// orphan, even if it turned out to be malformed.
+	matched bool
+	// stored is set if the orphan was added to the database.
+	stored bool
+	// queued is set if the orphan was queued to be added in a batch, whose
+	// outcome is only known once the batch is stored.
+	queued bool
+	// skipped is set if the orphan was deliberately not stored, e.g. because
+	// it already exists, was filtered out or the run is a dry run.
+	skipped bool
+	// conflict is set if the orphan conflicts with the stored one, whether it
+	// was stored anyway or not.
+	conflict bool
+	// err is the reason the orphan couldn't be stored, if storing it failed
+	// or it was refused.
+	err error
+	// typ and serial identify the orphan. They are unknownOrphan and empty if
+	// its DER couldn't be parsed.
+	typ    orphanType
+	serial string
+}
+
+// reprocessLogs stores the orphans in the boulder-ca logs read from inputs, in
+// turn, counting them in sum, and logs the totals once done. Lines split by
+// the logging infrastructure are joined if they end with continuationMarker.
+// With several inputs, such as rotated logs, the totals of each are logged at
+// debug level. It stops at the first log that can't be read and returns an
+// error, after logging the totals of the lines read until then.
+func (rp *reprocessor) reprocessLogs(ctx context.Context, prog *progress, sum *summary, continuationMarker string, inputs ...logInput) error {
+	rp.funnel = &parseFunnel{stats: rp.stats}
+	sum.funnel = rp.funnel
+	var readErr error
+	for _, input := range inputs {
+		if rp.runStopped() != nil || rp.interrupted() {
+			break
+		}
+		r, err := input.open()
+		if err != nil {
+			readErr = err
+			break
+		}
+		// A snapshot of the counts so far
+		before := sum.minus(&summary{})
+		src := rp.newLogSource(prog, sum, r, continuationMarker)
+		err = rp.processSource(ctx, sum, src)
+		_ = r.Close()
+		if len(inputs) > 1 {
+			rp.logger.Debugf("Processed %s: %s", input.name, sum.minus(before))
+		}
+		if err != nil {
+			readErr = fmt.Errorf("%s: %s", input.name, err)
+			break
+		}
+	}
+	logger := rp.logger
+	logger.Infof("Found %d certificate orphans and added %d to the database", sum.certOrphansFound, sum.certOrphansAdded)
+	logger.Infof("Found %d precertificate orphans and added %d to the database", sum.precertOrphansFound, sum.precertOrphansAdded)
+	logger.Infof("Lines reaching each stage of parsing: %s", rp.funnel)
+	if rp.skipLines > 0 && prog.lines < rp.skipLines {
+		logger.Warningf("Skipped all %d lines of the log, which is shorter than --start-line %d", prog.lines, rp.skipLines)
+	}
+	if rp.interrupted() {
+		atomic.StoreInt64(&sum.resumeLine, prog.lines)
+		logger.Warningf("Interrupted after processing line %d, pass --start-line %d to resume", prog.lines, prog.lines)
+	}
+	if n := atomic.LoadInt64(&rp.labelMismatches); n > 0 {
+		logger.Warningf("Found %d orphans whose log line label disagreed with their DER", n)
+	}
+	if n := atomic.LoadInt64(&rp.invalidRegIDs); n > 0 {
+		logger.Warningf("Rejected %d orphans with an invalid regID", n)
+	}
+	if n := atomic.LoadInt64(&rp.issuedMismatches); n > 0 {
+		logger.Warningf("Found %d stored orphans whose issued date differed from the one sent", n)
+	}
+	if rp.listRegIDs && rp.touchedRegIDs != nil {
+		logger.Infof("Added orphans for %s registrations: %v", rp.touchedRegIDs, rp.touchedRegIDs.list())
+	}
+	if n := atomic.LoadInt64(&rp.orderlessOrphans); n > 0 {
+		logger.Warningf("Skipped %d orphans without a backing order", n)
+	}
+	if n := atomic.LoadInt64(&rp.rpcRetries); n > 0 {
+		logger.Warningf("Retried %d SA and CA calls that failed transiently", n)
+	}
+	if n := atomic.LoadInt64(&rp.duplicatesSkipped); n > 0 {
+		atomic.StoreInt64(&sum.duplicatesSkipped, n)
+		logger.Infof("Skipped %d duplicate orphans already handled in this run", n)
+	}
+	if n := atomic.LoadInt64(&rp.pendingSkipped); n > 0 {
+		atomic.StoreInt64(&sum.pendingSkipped, n)
+		logger.Warningf("Skipped %d duplicate orphans while an earlier line was being stored, they aren't retried if it failed", n)
+	}
+	if n := atomic.LoadInt64(&rp.trailingStripped); n > 0 {
+		logger.Warningf("Stripped trailing bytes from the DER of %d orphans", n)
+	}
+	rp.logTooOld(sum)
+	if n := atomic.LoadInt64(&rp.disallowedSigAlgs); n > 0 {
+		logger.Warningf("Skipped %d orphans signed with a disallowed signature algorithm", n)
+	}
+	if n := atomic.LoadInt64(&rp.untrustedOrphans); n > 0 {
+		logger.Warningf("Rejected %d orphans not signed by a trusted issuer", n)
+	}
+	if n := atomic.LoadInt64(&rp.deniedIssuerOrphans); n > 0 {
+		logger.Warningf("Rejected %d orphans issued by a denied issuer", n)
+	}
+	if n := atomic.LoadInt64(&rp.unlistedIssuerOrphans); n > 0 {
+		logger.Warningf("Rejected %d orphans issued by an issuer that isn't allowed", n)
+	}
+	if n := atomic.LoadInt64(&rp.noOCSPServerOrphans); n > 0 {
+		logger.Warningf("Found %d orphans without an OCSP server, handled by %s", n, rp.onNoOCSPServer)
+	}
+	if n := atomic.LoadInt64(&rp.dryRunOrphans); n > 0 {
+		logger.Infof("Would have added %d orphans to the database without --dry-run", n)
+	}
+	if n := atomic.LoadInt64(&rp.precertsWithoutOCSP); n > 0 {
+		atomic.StoreInt64(&sum.precertsWithoutOCSP, n)
+		logger.Infof("Stored %d precertificates without an OCSP response", n)
+	}
+	if n := atomic.LoadInt64(&rp.certsWithoutOCSP); n > 0 {
+		atomic.StoreInt64(&sum.certsWithoutOCSP, n)
+		logger.Warningf("Stored %d certificates without an OCSP response, backfill them with find-missing-ocsp --fix", n)
+	}
+	if conflicts := atomic.LoadInt64(&rp.contentConflicts); conflicts > 0 {
+		sum.conflicts(conflicts, rp.onConflict)
+		logger.Warningf("Found %d orphans conflicting with stored content, resolved by %s", conflicts, rp.onConflict)
+	}
+	if rp.budget != nil && atomic.LoadInt64(&rp.budget.skipped) > 0 {
+		logger.Warningf("Budget exhausted, skipped the last %d lines", rp.budget.skipped)
+	}
+	return readErr
+}
+
+// Summary is the outcome of a call to ReprocessLog.
+type Summary struct {
+	CertOrphansFound    int64
+	CertOrphansAdded    int64
+	PrecertOrphansFound int64
+	PrecertOrphansAdded int64
+	// OrphansFailed counts the orphans that couldn't be added for any reason
+	// but already being stored or being skipped on purpose, including the
+	// ParseFailures that couldn't be parsed from their log line or DER.
+	OrphansFailed int64
+	ParseFailures int64
+}
+
+// ReprocessLog stores the orphans in the boulder-ca log read from r with sa and
+// ca, as parse-ca-log does with its default settings, and returns the totals.
+// The issued date of an orphan is its NotBefore plus backdate. It returns an
+// error if the log can't be read or the run was stopped, such as by the SA
+// being read-only, in which case the totals are those until then.
+func ReprocessLog(ctx context.Context, sa certificateStorage, ca ocspGenerator, logger blog.Logger, backdate time.Duration, r io.Reader) (Summary, error) {
+	rp := newReprocessor()
+	rp.sa, rp.ca, rp.logger = sa, ca, logger
+	rp.backdateDuration = backdate
+	rp.seen = newSerialCache(false)
+	sum := &summary{regIDs: rp.touchedRegIDs}
+	prog := newProgress(logger, cmd.Clock(), 0, 0)
+	err := rp.reprocessLogs(ctx, prog, sum, "", readerLogInput("log", r))
+	if err == nil {
+		err = rp.runStopped()
+	}
+	return Summary{
+		CertOrphansFound:    sum.certOrphansFound,
+		CertOrphansAdded:    sum.certOrphansAdded,
+		PrecertOrphansFound: sum.precertOrphansFound,
+		PrecertOrphansAdded: sum.precertOrphansAdded,
+		OrphansFailed:       rp.failedOrphans,
+		ParseFailures:       rp.parseFailures,
+	}, err
+}
+
+// logTooOld logs the number of orphans skipped for being issued before
+// issuedSince and of those issued more than maxOrphanAge ago, if any, and adds
+// them to sum.
+func (rp *reprocessor) logTooOld(sum *summary) {
+	if n := atomic.LoadInt64(&rp.tooOldOrphans); n > 0 {
+		atomic.StoreInt64(&sum.orphansTooOld, n)
+		rp.logger.Infof("Skipped %d orphans issued before %s", n, rp.issuedSince.Format(time.RFC3339))
+	}
+	if n := atomic.LoadInt64(&rp.implausiblyOldOrphans); n > 0 {
+		atomic.StoreInt64(&sum.orphansImplausiblyOld, n)
+		if rp.strictAge {
+			rp.logger.Warningf("Skipped %d orphans issued more than %s ago", n, rp.maxOrphanAge)
+		} else {
+			rp.logger.Warningf("Stored %d orphans issued more than %s ago", n, rp.maxOrphanAge)
+		}
+	}
+}
+
+// storeParsedLogLine attempts to parse one log line according to the format
+// used when orphaning certificates and precertificates and stores the orphan it
+// holds with storeOrphan, returning its result. A line without an orphan
+// yields a zero orphanResult.
+func (rp *reprocessor) storeParsedLogLine(line string) orphanResult {
+	der, regID, meta, malformed := rp.orphanFromLine(line)
+	if der == nil {
+		if malformed != nil {
+			atomic.AddInt64(&rp.failedOrphans, 1)
+			return orphanResult{matched: true, err: errMalformedLine}
+		}
+		return orphanResult{}
+	}
+	res := rp.storeOrphan(context.Background(), der, regID, meta)
+	res.matched = true
+	return res
+}
+
+// storeOrphan stores the orphan with the given DER and regID yielded by a
+// derSource and returns its result. As part of adding an orphan to the DB, it requests a fresh
+// OCSP response from the CA to store alongside the precertificate/certificate.
+// The operations storing it expire at the deadline given by meta, if any.
+func (rp *reprocessor) storeOrphan(ctx context.Context, der []byte, regID int64, meta sourceMeta) (res orphanResult) {
+	ctx, cancel := lineContext(ctx, meta.deadline)
+	defer cancel()
+	logger := rp.logger
+	origin := meta.origin
+	defer func() {
+		if res.err == nil {
+			return
+		}
+		if err := rp.rejects.add(meta.line, res.err); err != nil {
+			logger.Errf("Failed to write rejected line: %s, [%s]", err, origin)
+		}
+	}()
+
+	// Parse the DER and determine the orphan type
+	cert, der, err := rp.parseOrphanDER(der, origin)
+	if err != nil {
+		rp.countParseFailure()
+		atomic.AddInt64(&rp.failedOrphans, 1)
+		logger.Errf("Failed to parse orphan DER: %s, [%s]", err, origin)
+		res = orphanResult{err: err}
+		auditOrphanEvent(logger, rp.runID, nil, regID, rp.backdateDuration, res)
+		return res
+	}
+	rp.funnel.reach(stageParsed)
+	defer func() {
+		auditOrphanEvent(logger, rp.runID, cert, regID, rp.backdateDuration, res)
+	}()
+	typ := rp.format.orphanType(cert)
+	serial := core.SerialToString(cert.SerialNumber)
+	skip := func() orphanResult {
+		return orphanResult{skipped: true, typ: typ, serial: serial}
+	}
+	fail := func(err error) orphanResult {
+		return orphanResult{err: err, typ: typ, serial: serial}
+	}
+	if meta.label != unknownOrphan && typ != meta.label {
+		atomic.AddInt64(&rp.labelMismatches, 1)
+		if rp.strictLabel {
+			atomic.AddInt64(&rp.failedOrphans, 1)
+			logger.AuditErrf("Log line labels orphan %s as a %s but its DER is a %s, refusing to store it, [%s]",
+				serial, meta.label, typ, origin)
+			return fail(fmt.Errorf("log line labels it as a %s", meta.label))
+		}
+		logger.Warningf("Log line labels orphan %s as a %s but its DER is a %s, treating it as a %s, [%s]",
+			serial, meta.label, typ, typ, origin)
+	}
+	if rp.serialFilter != nil && !rp.serialFilter[serial] {
+		logger.Debugf("Skipping %s with serial %s not in the serial filter", typ, serial)
+		return skip()
+	}
+	if !rp.shard.includes(serial) {
+		logger.Debugf("Skipping %s with serial %s outside of shard %s", typ, serial, rp.shard)
+		return skip()
+	}
+	if issued := issuedDateForCert(cert, rp.backdateDuration); issued.Before(rp.issuedSince) {
+		atomic.AddInt64(&rp.tooOldOrphans, 1)
+		logger.Debugf("Skipping %s %s issued at %s, before %s", typ, serial,
+			issued.Format(time.RFC3339), rp.issuedSince.Format(time.RFC3339))
+		return skip()
+	}
+	if !rp.sigAlgAllowed(cert) {
+		atomic.AddInt64(&rp.disallowedSigAlgs, 1)
+		logger.AuditErrf("Skipping %s %s signed with disallowed signature algorithm %s, [%s]",
+			typ, serial, cert.SignatureAlgorithm, origin)
+		return skip()
+	}
+	if !rp.issuedByTrustedIssuer(cert) {
+		atomic.AddInt64(&rp.untrustedOrphans, 1)
+		logger.AuditErrf("Rejecting %s %s issued by %q, which isn't a trusted issuer, [%s]",
+			typ, serial, cert.Issuer, origin)
+		return fail(fmt.Errorf("issued by untrusted issuer %q", cert.Issuer))
+	}
+	if rp.issuerDenied(cert) {
+		atomic.AddInt64(&rp.deniedIssuerOrphans, 1)
+		logger.AuditErrf("Rejecting %s %s issued by %q with denied key identifier %s, [%s]",
+			typ, serial, cert.Issuer, issuerKeyID(cert), origin)
+		return fail(fmt.Errorf("issuer key identifier %s is denied", issuerKeyID(cert)))
+	}
+	if !rp.issuerAllowed(cert) {
+		atomic.AddInt64(&rp.unlistedIssuerOrphans, 1)
+		logger.AuditErrf("Rejecting %s %s issued by %q with key identifier %s, which isn't allowed, [%s]",
+			typ, serial, cert.Issuer, issuerKeyID(cert), origin)
+		return fail(fmt.Errorf("issuer key identifier %s isn't allowed", issuerKeyID(cert)))
+	}
+	// If this serial has already been handled earlier in the run there is no
+	// need to ask the DB about it again
+	key := serialKey{serial: serial, typ: typ}
+	prev, claimed := rp.seen.claim(key)
+	if !claimed {
+		atomic.AddInt64(&rp.duplicatesSkipped, 1)
+		if prev == serialPending {
+			atomic.AddInt64(&rp.pendingSkipped, 1)
+		}
+		logger.Infof("Skipping %s already %s in this run, [%s]", typ, prev, origin)
+		return skip()
+	}
+	// Write the orphan to the PEM output and archive, if any, once its outcome
+	// is known
+	outcome := outcomeNotAdded
+	defer func() {
+		if res.stored {
+			outcome = outcomeAdded
+		}
+		if err := rp.pemOut.write(cert, typ, outcome); err != nil {
+			logger.Errf("Failed to write %s to PEM output: %s, [%s]", typ, err, origin)
+		}
+		if err := rp.archiveOut.write(cert, typ); err != nil {
+			logger.Errf("Failed to write %s to archive: %s, [%s]", typ, err, origin)
+		}
+	}()
+	// stored is only set once the store succeeds, so any earlier return leaves
+	// the serial to be retried by lines read after the release. Lines read
+	// while it is pending were already skipped and counted in pendingSkipped.
+	defer func() {
+		if !res.queued {
+			rp.seen.release(key, res.stored)
+		}
+	}()
+	// Ensure the orphan doesn't already exist in the DB, unless an earlier line
+	// in this run already confirmed it doesn't
+	var overwrite bool
+	if prev != serialAbsent {
+		_, typ, err = rp.checkCert(ctx, rp.sa, cert)
+		if err == errContentConflict {
+			defer func() { res.conflict = true }()
+			if !rp.resolveConflict(typ, serial, origin) {
+				return fail(err)
+			}
+			overwrite = true
+		} else if err != nil {
+			logFunc := logger.Errf
+			if err == errAlreadyExists {
+				logFunc = logger.Infof
+				outcome = outcomeAlreadyExists
+				rp.seen.mark(key, serialPresent)
+				rp.recordOrphan(cert, typ, regID, origin, true)
+			} else {
+				atomic.AddInt64(&rp.failedOrphans, 1)
+			}
+			logFunc("%s, [%s]", err, origin)
+			if err == errAlreadyExists {
+				return skip()
+			}
+			return fail(err)
+		}
+		if overwrite {
+			rp.seen.mark(key, serialPresent)
+		} else {
+			rp.seen.mark(key, serialAbsent)
+		}
+		rp.recordOrphan(cert, typ, regID, origin, overwrite)
+	}
+	rp.funnel.reach(stageChecked)
+	if rp.analysisOnly {
+		logger.Infof("Not storing %s in analysis mode, [%s]", typ, origin)
+		return skip()
+	}
+	if meta.regIDErr != nil {
+		atomic.AddInt64(&rp.failedOrphans, 1)
+		rp.auditErrf(meta.regIDCategory, "%s, [%s]", meta.regIDErr, origin)
+		return fail(meta.regIDErr)
+	}
+	if rp.strictRegID && regID <= 0 {
+		atomic.AddInt64(&rp.invalidRegIDs, 1)
+		atomic.AddInt64(&rp.failedOrphans, 1)
+		rp.auditErrf(auditInvalidRegID, "Invalid regID %d, [%s]", regID, origin)
+		return fail(fmt.Errorf("invalid regID %d", regID))
+	}
+	if rp.regIDFilter != nil && !rp.regIDFilter[regID] {
+		logger.Infof("Skipping %s for registration %d not in the regID filter, [%s]", typ, regID, origin)
+		return skip()
+	}
+	if rp.requireOrder {
+		reason, err := checkOrder(ctx, rp.sa, meta.orderID, regID)
+		if err != nil {
+			atomic.AddInt64(&rp.failedOrphans, 1)
+			logger.AuditErrf("%s, [%s]", err, origin)
+			return fail(err)
+		}
+		if reason != "" {
+			atomic.AddInt64(&rp.orderlessOrphans, 1)
+			logger.AuditInfof("Skipping %s %s without a backing order: %s, [%s]", typ, serial, reason, origin)
+			return skip()
+		}
+	}
+	issuedDate := issuedDateForCert(cert, rp.backdateDuration)
+	// An issued date in the future means the backdate is misconfigured, which
+	// would be stored for every orphan of the run
+	if now := cmd.Clock().Now(); issuedDate.After(now.Add(rp.maxIssuedSkew)) {
+		atomic.AddInt64(&rp.failedOrphans, 1)
+		logger.AuditErrf("Refusing to store %s %s with issued date %s in the future, check the config's backdate, [%s]",
+			typ, serial, issuedDate.Format(time.RFC3339), origin)
+		return fail(fmt.Errorf("issued date %s is in the future", issuedDate.Format(time.RFC3339)))
+	}
+	// An issued date implausibly far in the past hints at a corrupted NotBefore
+	if rp.maxOrphanAge > 0 && issuedDate.Before(cmd.Clock().Now().Add(-rp.maxOrphanAge)) {
+		atomic.AddInt64(&rp.implausiblyOldOrphans, 1)
+		if rp.strictAge {
+			logger.AuditErrf("Skipping %s %s with issued date %s more than %s ago, check its NotBefore, [%s]",
+				typ, serial, issuedDate.Format(time.RFC3339), rp.maxOrphanAge, origin)
+			return skip()
+		}
+		logger.AuditInfof("Storing %s %s with issued date %s more than %s ago, check its NotBefore, [%s]",
+			typ, serial, issuedDate.Format(time.RFC3339), rp.maxOrphanAge, origin)
+	}
+	if !rp.admitRegID(typ, serial, regID, origin) {
+		return fail(fmt.Errorf("registration %d exceeds the limit of %d distinct regIDs", regID, rp.maxRegIDs))
+	}
+	if rp.onNoOCSPServer != noOCSPServerGenerate && !hasOCSPServer(cert) {
+		atomic.AddInt64(&rp.noOCSPServerOrphans, 1)
+		if rp.onNoOCSPServer == noOCSPServerFail {
+			atomic.AddInt64(&rp.failedOrphans, 1)
+			logger.AuditErrf("Refusing to store %s %s without an OCSP server, [%s]", typ, serial, origin)
+			return fail(errors.New("no OCSP server in its AIA extension"))
+		}
+		logger.Infof("Storing %s %s without an OCSP response as it names no OCSP server, [%s]", typ, serial, origin)
+	}
+	if rp.dryRun {
+		atomic.AddInt64(&rp.dryRunOrphans, 1)
+		logger.Infof("Would add %s %s for registration %d in a dry run, [%s]", typ, serial, regID, origin)
+		return skip()
+	}
+	if meta.status.revoked {
+		logger.AuditInfof("Storing %s %s as %s, [%s]", typ, serial, meta.status, origin)
+	}
+	response, err := rp.orphanOCSP(ctx, typ, cert, der, meta.status)
+	if err != nil {
+		rp.stats.orphanFailed(typ, serial)
+		atomic.AddInt64(&rp.failedOrphans, 1)
+		logger.AuditErrf("Couldn't generate OCSP: %s, [%s]", err, origin)
+		return fail(err)
+	}
+	// report reports the result of storing the orphan
+	report := func(err error) orphanResult {
+		if !rp.reportStored(ctx, typ, cert, regID, issuedDate, origin, err) {
+			return fail(err)
+		}
+		return orphanResult{stored: true, typ: typ, serial: serial}
+	}
+	issued := issuedDate.UnixNano()
+	req := &sapb.AddCertificateRequest{
+		Der:    der,
+		RegID:  &regID,
+		Ocsp:   response,
+		Issued: &issued,
+	}
+	if overwrite {
+		err = rp.storeWhenWritable(ctx, func(ctx context.Context) error {
+			return rp.overwriteOrphan(ctx, typ, req)
+		})
+		return report(err)
+	}
+	if rp.batcher != nil {
+		rp.batcher.add(ctx, pendingOrphan{
+			req:    req,
+			typ:    typ,
+			key:    key,
+			cert:   cert,
+			issued: issuedDate,
+			origin: origin,
+			line:   meta.line,
+		})
+		// The batcher releases the serial once the batch has been stored
+		outcome = outcomeQueued
+		return orphanResult{queued: true, typ: typ, serial: serial}
+	}
+	err = rp.storeWhenWritable(ctx, func(ctx context.Context) error {
+		var err error
+		switch typ {
+		case certOrphan:
+			_, err = rp.sa.AddCertificate(ctx, req)
+		case precertOrphan:
+			_, err = rp.sa.AddPrecertificate(ctx, req)
+		default:
+			// Shouldn't happen but be defensive anyway
+			err = errors.New("unknown orphan type")
+		}
+		return err
+	})
+	return report(err)
+}
+
+// reportStored reports the result of storing cert with the given issued date
+// from origin, returning true if it was added.
+func (rp *reprocessor) reportStored(ctx context.Context, typ orphanType, cert *x509.Certificate, regID int64, issuedDate time.Time, origin string, err error) bool {
+	logger := rp.logger
+	serial := core.SerialToString(cert.SerialNumber)
+	if err != nil {
+		rp.stats.orphanFailed(typ, serial)
+		atomic.AddInt64(&rp.failedOrphans, 1)
+		logger.AuditErrf("Failed to store certificate: %s, [%s]", err, origin)
+		rp.abortOnStoreFailure(typ, serial, origin, err)
+		return false
+	}
+	rp.stats.orphanAdded(typ, serial)
+	rp.funnel.reach(stageStored)
+	auditRecovered(logger, rp.runID, typ, serial, rp.recoveryReason)
+	rp.touchedRegIDs.add(regID)
+	if rp.withoutOCSP(typ, cert) {
+		if typ == precertOrphan {
+			atomic.AddInt64(&rp.precertsWithoutOCSP, 1)
+		} else {
+			atomic.AddInt64(&rp.certsWithoutOCSP, 1)
+		}
+	}
+	rp.verifier.record(typ, serial)
+	if err := rp.responderCheck.record(cert); err != nil {
+		logger.Warningf("Not checking OCSP responder: %s, [%s]", err, origin)
+	}
+	if rp.verifyIssued {
+		err = rp.checkIssued(ctx, typ, serial, issuedDate)
+		if err != nil {
+			logger.Warningf("%s, [%s]", err, origin)
+		}
+	}
+	return true
+}
+
+// recordOrphan records a processed orphan to the recordSink, if there is one.
+// The regID is zero if the orphan's source has no usable regID for it.
+func (rp *reprocessor) recordOrphan(cert *x509.Certificate, typ orphanType, regID int64, origin string, inDB bool) {
+	if rp.recordSink == nil {
+		return
+	}
+	err := rp.recordSink.Record(newOrphanRecord(cert, typ, regID, rp.backdateDuration, inDB))
+	if err != nil {
+		rp.logger.Errf("Failed to record %s: %s, [%s]", typ, err, origin)
+	}
+}
+
+// closeOutputs completes the archive, rejects, PEM and SQLite outputs, if
+// any. It does nothing if rp is nil.
+func (rp *reprocessor) closeOutputs(logger blog.Logger) {
+	if rp == nil {
+		return
+	}
+	if err := rp.archiveOut.Close(); err != nil {
+		logger.Errf("Failed to complete archive output: %s", err)
+	}
+	if err := rp.rejects.Close(); err != nil {
+		logger.Errf("Failed to write rejects file: %s", err)
+	}
+	if err := rp.pemOut.Close(); err != nil {
+		logger.Errf("Failed to write PEM output: %s", err)
+	}
+	if rp.recordSink != nil {
+		if err := rp.recordSink.Close(); err != nil {
+			logger.Errf("Failed to write SQLite database: %s", err)
+		}
+	}
+}
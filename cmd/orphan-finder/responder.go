@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/sha1"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	"golang.org/x/crypto/ocsp"
+)
+
+// responderTimeout is the timeout of each request to the OCSP responder.
+const responderTimeout = 10 * time.Second
+
+// responderVerifier records the orphans added during a run so that the OCSP
+// responder can be asked about each of them once the run is done, confirming
+// that the responses stored for them are actually served.
+type responderVerifier struct {
+	sync.Mutex
+	url    string
+	client *http.Client
+	// requests holds the DER encoded OCSP request for every serial added, in
+	// the order they were added.
+	requests map[string][]byte
+	serials  []string
+}
+
+func newResponderVerifier(url string) *responderVerifier {
+	return &responderVerifier{
+		url:      url,
+		client:   &http.Client{Timeout: responderTimeout},
+		requests: make(map[string][]byte),
+	}
+}
+
+// ocspRequestFor returns an OCSP request for cert. The issuer's key hash is
+// taken from the authority key identifier, which Boulder computes the same
+// way, so that the issuer certificate isn't needed.
+func ocspRequestFor(cert *x509.Certificate) ([]byte, error) {
+	if len(cert.AuthorityKeyId) == 0 {
+		return nil, fmt.Errorf("%s has no authority key identifier", core.SerialToString(cert.SerialNumber))
+	}
+	nameHash := sha1.Sum(cert.RawIssuer)
+	req := &ocsp.Request{
+		HashAlgorithm:  crypto.SHA1,
+		IssuerNameHash: nameHash[:],
+		IssuerKeyHash:  cert.AuthorityKeyId,
+		SerialNumber:   cert.SerialNumber,
+	}
+	return req.Marshal()
+}
+
+// record records that cert was added. A serial added as both a precertificate
+// and a certificate is only checked once, as the responder serves a single
+// response for it. It does nothing if v is nil.
+func (v *responderVerifier) record(cert *x509.Certificate) error {
+	if v == nil {
+		return nil
+	}
+	serial := core.SerialToString(cert.SerialNumber)
+	req, err := ocspRequestFor(cert)
+	if err != nil {
+		return err
+	}
+	v.Lock()
+	defer v.Unlock()
+	if _, ok := v.requests[serial]; ok {
+		return nil
+	}
+	v.requests[serial] = req
+	v.serials = append(v.serials, serial)
+	return nil
+}
+
+// query asks the responder for the status of the serial the DER encoded OCSP
+// request is for. The response's signature isn't checked, as only the status
+// served matters here.
+func (v *responderVerifier) query(ctx context.Context, serial string, req []byte) (int, error) {
+	httpReq, err := http.NewRequest(http.MethodPost, v.url, bytes.NewReader(req))
+	if err != nil {
+		return 0, err
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	resp, err := v.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("querying OCSP responder for %s: %s", serial, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("OCSP responder returned %s for %s", resp.Status, serial)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("reading OCSP response for %s: %s", serial, err)
+	}
+	parsed, err := ocsp.ParseResponse(body, nil)
+	if err != nil {
+		return 0, fmt.Errorf("parsing OCSP response for %s: %s", serial, err)
+	}
+	if got := core.SerialToString(parsed.SerialNumber); got != serial {
+		return 0, fmt.Errorf("OCSP responder returned a response for %s when asked for %s", got, serial)
+	}
+	return parsed.Status, nil
+}
+
+// verify asks the responder about every recorded serial and returns the
+// number checked and a description of every mismatch by serial. Every orphan
+// is stored with a good response, so any other status or a failed query is a
+// mismatch.
+func (v *responderVerifier) verify(ctx context.Context) (int64, map[string]string) {
+	v.Lock()
+	defer v.Unlock()
+	mismatches := make(map[string]string)
+	for _, serial := range v.serials {
+		status, err := v.query(ctx, serial, v.requests[serial])
+		if err != nil {
+			mismatches[serial] = err.Error()
+		} else if status != ocsp.Good {
+			mismatches[serial] = fmt.Sprintf("OCSP responder serves status %s for %s, stored good",
+				ocspStatusName(status), serial)
+		}
+	}
+	return int64(len(v.serials)), mismatches
+}
+
+// ocspStatusName returns the name of an OCSP certificate status.
+func ocspStatusName(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	case ocsp.Unknown:
+		return "unknown"
+	}
+	return fmt.Sprintf("%d", status)
+}
+
+// verifyResponder checks the OCSP responder's status for the orphans recorded
+// by rp.responderCheck, logging any mismatches and adding the result to sum.
+// It does nothing if rp.responderCheck is nil.
+func (rp *reprocessor) verifyResponder(ctx context.Context, inv *invocation, sum *summary) {
+	if rp.responderCheck == nil {
+		return
+	}
+	checked, mismatches := rp.responderCheck.verify(ctx)
+	for _, serial := range rp.responderCheck.serials {
+		if msg, ok := mismatches[serial]; ok {
+			inv.logger.AuditErrf("Added orphan %s isn't served correctly: %s", serial, msg)
+		}
+	}
+	sum.responderVerified(checked, int64(len(mismatches)))
+	inv.logger.Infof("Checked %d added orphans against the OCSP responder, %d mismatched", checked, len(mismatches))
+}
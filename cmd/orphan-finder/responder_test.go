@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/test"
+	"golang.org/x/crypto/ocsp"
+)
+
+// newTestResponder returns an OCSP responder serving the given status for
+// every serial in statuses and failing for every other serial.
+func newTestResponder(t *testing.T, statuses map[string]int) *httptest.Server {
+	responder, key := newOCSPSigner()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		status, ok := statuses[core.SerialToString(req.SerialNumber)]
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		resp, err := ocsp.CreateResponse(responder, responder, ocsp.Response{
+			Status:       status,
+			SerialNumber: req.SerialNumber,
+			ThisUpdate:   time.Now(),
+			NextUpdate:   time.Now().Add(time.Hour),
+		}, key)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write(resp)
+	}))
+}
+
+func TestVerifyResponder(t *testing.T) {
+	certSerial := "ffa0160630d618b2eb5c0510824b14274856"
+	precertSerial := "03e1dea6f3349009a90e0306dbb39c3e7ca2"
+	srv := newTestResponder(t, map[string]int{certSerial: ocsp.Good, precertSerial: ocsp.Revoked})
+	defer srv.Close()
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.responderCheck = newResponderVerifier(srv.URL)
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertDeepEquals(t, rp.responderCheck.serials, []string{certSerial, precertSerial})
+
+	checked, mismatches := rp.responderCheck.verify(context.Background())
+	test.AssertEquals(t, checked, int64(2))
+	test.AssertDeepEquals(t, mismatches, map[string]string{
+		precertSerial: "OCSP responder serves status revoked for " + precertSerial + ", stored good",
+	})
+
+	// A serial the responder can't answer for is a mismatch too
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	sum := &summary{}
+	inv := &invocation{logger: log, command: "parse-ca-log", summary: sum, opts: newTestOptions(rp)}
+	log.Clear()
+	rp.verifyResponder(context.Background(), inv, sum)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Added orphan .* isn't served correctly: OCSP responder returned 404 Not Found`)), 2)
+	test.AssertContains(t, sum.String(), "responderChecked=2 responderMismatched=2")
+}
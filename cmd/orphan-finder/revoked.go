@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	capb "github.com/letsencrypt/boulder/ca/proto"
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/revocation"
+)
+
+// orphanStatus is the status the OCSP response generated for an orphan
+// asserts. The zero value is good.
+type orphanStatus struct {
+	revoked   bool
+	reason    revocation.Reason
+	revokedAt time.Time
+}
+
+// goodStatus is the status of orphans neither the command line nor their log
+// line say were revoked.
+var goodStatus = orphanStatus{}
+
+var (
+	statusOrphan    = regexp.MustCompile(`ocspStatus=\[(\w+)\]`)
+	reasonOrphan    = regexp.MustCompile(`revocationReason=\[(\d+)\]`)
+	revokedAtOrphan = regexp.MustCompile(`revokedAt=\[([^\]]+)\]`)
+)
+
+// parseOrphanStatus returns the status with the given name, which is either
+// good or revoked. A revoked status needs one of the reasons of
+// revocation.ReasonToString and the time of the revocation in RFC 3339 format,
+// which a good status mustn't have.
+func parseOrphanStatus(name string, reason int, revokedAt string) (orphanStatus, error) {
+	switch core.OCSPStatus(name) {
+	case core.OCSPStatusGood:
+		if reason != 0 || revokedAt != "" {
+			return goodStatus, errors.New("a revocation reason or time is only allowed for revoked orphans")
+		}
+		return goodStatus, nil
+	case core.OCSPStatusRevoked:
+		if _, ok := revocation.ReasonToString[revocation.Reason(reason)]; !ok {
+			return goodStatus, fmt.Errorf("unknown revocation reason %d", reason)
+		}
+		if revokedAt == "" {
+			return goodStatus, errors.New("revoked orphans need a revocation time")
+		}
+		at, err := time.Parse(time.RFC3339, revokedAt)
+		if err != nil {
+			return goodStatus, fmt.Errorf("parsing revocation time: %s", err)
+		}
+		return orphanStatus{revoked: true, reason: revocation.Reason(reason), revokedAt: at}, nil
+	}
+	return goodStatus, fmt.Errorf("unknown OCSP status %q, expected %s or %s",
+		name, core.OCSPStatusGood, core.OCSPStatusRevoked)
+}
+
+// statusFromLine returns the status given by the ocspStatus, revocationReason
+// and revokedAt fields of a log line, or goodStatus if it has no ocspStatus.
+func statusFromLine(line string) (orphanStatus, error) {
+	statusStr := statusOrphan.FindStringSubmatch(line)
+	if len(statusStr) <= 1 {
+		return goodStatus, nil
+	}
+	var reason int
+	if reasonStr := reasonOrphan.FindStringSubmatch(line); len(reasonStr) > 1 {
+		var err error
+		reason, err = strconv.Atoi(reasonStr[1])
+		if err != nil {
+			return goodStatus, fmt.Errorf("parsing revocation reason: %s", err)
+		}
+	}
+	var revokedAt string
+	if revokedAtStr := revokedAtOrphan.FindStringSubmatch(line); len(revokedAtStr) > 1 {
+		revokedAt = revokedAtStr[1]
+	}
+	return parseOrphanStatus(statusStr[1], reason, revokedAt)
+}
+
+// ocspRequest returns the request for an OCSP response asserting s for the
+// certificate with the given DER.
+func (s orphanStatus) ocspRequest(certDER []byte) *capb.GenerateOCSPRequest {
+	if !s.revoked {
+		return &capb.GenerateOCSPRequest{
+			CertDER: certDER,
+			Status:  string(core.OCSPStatusGood),
+		}
+	}
+	return &capb.GenerateOCSPRequest{
+		CertDER:   certDER,
+		Status:    string(core.OCSPStatusRevoked),
+		Reason:    int32(s.reason),
+		RevokedAt: s.revokedAt.UnixNano(),
+	}
+}
+
+func (s orphanStatus) String() string {
+	if !s.revoked {
+		return string(core.OCSPStatusGood)
+	}
+	return fmt.Sprintf("%s (reason %d at %s)", core.OCSPStatusRevoked, s.reason, s.revokedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	capb "github.com/letsencrypt/boulder/ca/proto"
+	"github.com/letsencrypt/boulder/test"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/grpc"
+)
+
+// recordingCA is a mockCA recording the OCSP requests it is sent.
+type recordingCA struct {
+	mockCA
+	reqs []*capb.GenerateOCSPRequest
+}
+
+func (ca *recordingCA) GenerateOCSP(ctx context.Context, req *capb.GenerateOCSPRequest, opts ...grpc.CallOption) (*capb.OCSPResponse, error) {
+	ca.reqs = append(ca.reqs, req)
+	return ca.mockCA.GenerateOCSP(ctx, req, opts...)
+}
+
+func TestParseOrphanStatus(t *testing.T) {
+	status, err := parseOrphanStatus("good", 0, "")
+	test.AssertNotError(t, err, "parsing good status")
+	test.AssertEquals(t, status, goodStatus)
+
+	status, err = parseOrphanStatus("revoked", ocsp.KeyCompromise, "2020-01-02T03:04:05Z")
+	test.AssertNotError(t, err, "parsing revoked status")
+	test.Assert(t, status.revoked, "status isn't revoked")
+	test.AssertEquals(t, status.String(), "revoked (reason 1 at 2020-01-02T03:04:05Z)")
+	req := status.ocspRequest([]byte{1})
+	test.AssertEquals(t, req.Status, "revoked")
+	test.AssertEquals(t, req.Reason, int32(ocsp.KeyCompromise))
+	test.AssertEquals(t, req.RevokedAt, time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC).UnixNano())
+
+	for _, tc := range []struct {
+		name      string
+		reason    int
+		revokedAt string
+	}{
+		{"good", ocsp.KeyCompromise, ""},
+		{"good", 0, "2020-01-02T03:04:05Z"},
+		{"revoked", 7, "2020-01-02T03:04:05Z"},
+		{"revoked", 0, ""},
+		{"revoked", 0, "yesterday"},
+		{"unknown", 0, ""},
+	} {
+		_, err := parseOrphanStatus(tc.name, tc.reason, tc.revokedAt)
+		test.AssertError(t, err, "invalid status accepted")
+	}
+}
+
+func TestRevokedOrphanFromLine(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	ca := &recordingCA{}
+	revoked := " ocspStatus=[revoked] revocationReason=[4] revokedAt=[2020-01-02T03:04:05Z]"
+	logData := logLine(certOrphan, testCertDER, "1001", "1") + revoked + "\n" +
+		logLine(precertOrphan, testPreCertDER, "1001", "1") + "\n"
+
+	rp := newTestReprocessor(sa, ca)
+	rp.seen = newSerialCache(false)
+	log.Clear()
+	sum := &summary{}
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, len(ca.reqs), 2)
+	// Lines without an ocspStatus stay good
+	statuses := map[string]int32{}
+	for _, req := range ca.reqs {
+		statuses[req.Status] = req.Reason
+	}
+	test.AssertDeepEquals(t, statuses, map[string]int32{"revoked": 4, "good": 0})
+	test.AssertEquals(t, len(log.GetAllMatching(`INFO: \[AUDIT\] Storing certificate ffa0160630d618b2eb5c0510824b14274856 as revoked \(reason 4 at 2020-01-02T03:04:05Z\)`)), 1)
+
+	// A line with a broken status isn't stored
+	log.Clear()
+	sa = &mockSA{clk: clock.NewFake()}
+	rp.sa = sa
+	rp.seen = newSerialCache(false)
+	logData = logLine(certOrphan, testCertDER, "1001", "1") + " ocspStatus=[revoked]\n"
+	_ = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertEquals(t, len(sa.certificates), 0)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Couldn't parse OCSP status: revoked orphans need a revocation time`)), 1)
+}
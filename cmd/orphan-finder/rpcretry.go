@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// rpcBackoffMax bounds the backoff between attempts at an RPC that failed
+// transiently.
+const rpcBackoffMax = 30 * time.Second
+
+// isTransient returns true if err indicates that an RPC failed because the
+// service was briefly unavailable or too slow to answer, rather than because
+// it refused the request.
+func isTransient(err error) bool {
+	if isTimeout(err) {
+		return true
+	}
+	return status.Code(err) == codes.Unavailable
+}
+
+// isTimeout returns true if err indicates that an RPC wasn't answered in time,
+// in which case the service may still have carried it out.
+func isTimeout(err error) bool {
+	return err == context.DeadlineExceeded || status.Code(err) == codes.DeadlineExceeded
+}
+
+// callRPC calls rpc with a context bounded by rp.rpcTimeout. If it fails
+// transiently it is attempted up to rp.rpcAttempts times in all, backing off
+// exponentially in between, unless ctx expires first.
+func (rp *reprocessor) callRPC(ctx context.Context, rpc func(ctx context.Context) error) error {
+	return rp.retryRPC(ctx, rpc, isTransient)
+}
+
+// callWriteRPC is like callRPC for an RPC storing something, which isn't
+// retried if it timed out. The SA may have stored it anyway, and a retry
+// would then be refused as a duplicate and mistaken for an orphan stored by an
+// earlier run.
+func (rp *reprocessor) callWriteRPC(ctx context.Context, write func(ctx context.Context) error) error {
+	return rp.retryRPC(ctx, write, func(err error) bool {
+		return isTransient(err) && !isTimeout(err)
+	})
+}
+
+// retryRPC calls rpc with a context bounded by rp.rpcTimeout, attempting it up
+// to rp.rpcAttempts times in all as long as retryable returns true for its
+// error and ctx hasn't expired.
+func (rp *reprocessor) retryRPC(ctx context.Context, rpc func(ctx context.Context) error, retryable func(error) bool) error {
+	for attempt := 1; ; attempt++ {
+		rpcCtx, cancel := rp.rpcContext(ctx)
+		err := rpc(rpcCtx)
+		cancel()
+		if err == nil || !retryable(err) || attempt >= rp.rpcAttempts || ctx.Err() != nil {
+			return err
+		}
+		atomic.AddInt64(&rp.rpcRetries, 1)
+		if sleepContext(ctx, core.RetryBackoff(attempt, rp.rpcBackoffBase, rpcBackoffMax, 2)) != nil {
+			return err
+		}
+	}
+}
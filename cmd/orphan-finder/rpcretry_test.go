@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// flakySA is a mockSA whose certificate lookups fail with err the first
+// failures times.
+type flakySA struct {
+	mockSA
+	failures int
+	err      error
+	calls    int
+}
+
+func (m *flakySA) GetCertificate(ctx context.Context, s string) (core.Certificate, error) {
+	m.calls++
+	if m.calls <= m.failures {
+		return core.Certificate{}, m.err
+	}
+	return m.mockSA.GetCertificate(ctx, s)
+}
+
+func TestCallRPC(t *testing.T) {
+	var slept []time.Duration
+	realSleepContext := sleepContext
+	sleepContext = func(_ context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		return nil
+	}
+	defer func() { sleepContext = realSleepContext }()
+	unavailable := status.Error(codes.Unavailable, "overloaded")
+	rp := newTestReprocessor(nil, &mockCA{})
+
+	// Transient failures are retried with a growing backoff
+	sa := &flakySA{failures: rp.rpcAttempts - 1, err: unavailable}
+	rp.sa = sa
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, sa.calls, rp.rpcAttempts)
+	test.AssertEquals(t, rp.rpcRetries, int64(rp.rpcAttempts-1))
+	test.AssertEquals(t, len(slept), rp.rpcAttempts-1)
+	test.Assert(t, slept[1] > slept[0], "backoff didn't grow")
+
+	// But only up to rpcAttempts times
+	sa = &flakySA{failures: rp.rpcAttempts, err: status.Error(codes.DeadlineExceeded, "too slow")}
+	rp.sa = sa
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, sa.calls, rp.rpcAttempts)
+
+	// Other errors aren't retried
+	for _, err := range []error{status.Error(codes.Internal, "broken"), errors.New("invalid DER")} {
+		calls := 0
+		got := rp.callRPC(context.Background(), func(context.Context) error {
+			calls++
+			return err
+		})
+		test.AssertEquals(t, got, err)
+		test.AssertEquals(t, calls, 1)
+	}
+
+	// Nor are RPCs once the context of the line has expired
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	_ = rp.callRPC(ctx, func(context.Context) error {
+		calls++
+		return unavailable
+	})
+	test.AssertEquals(t, calls, 1)
+
+	// A write that timed out may have been carried out, so it isn't retried,
+	// while one the SA was unavailable for is
+	for _, tc := range []struct {
+		err   error
+		calls int
+	}{
+		{status.Error(codes.DeadlineExceeded, "too slow"), 1},
+		{context.DeadlineExceeded, 1},
+		{unavailable, rp.rpcAttempts},
+	} {
+		calls := 0
+		got := rp.callWriteRPC(context.Background(), func(context.Context) error {
+			calls++
+			return tc.err
+		})
+		test.AssertEquals(t, got, tc.err)
+		test.AssertEquals(t, calls, tc.calls)
+	}
+}
+
+func TestCallRPCBackoffCanceled(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.rpcBackoffBase = time.Hour
+	ctx, cancel := context.WithCancel(context.Background())
+	unavailable := status.Error(codes.Unavailable, "overloaded")
+
+	// The backoff ends as soon as the context of the line is canceled
+	calls := 0
+	done := make(chan error)
+	go func() {
+		done <- rp.callRPC(ctx, func(context.Context) error {
+			calls++
+			return unavailable
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		test.AssertEquals(t, err, unavailable)
+	case <-time.After(5 * time.Second):
+		t.Fatal("backoff went on after the context was canceled")
+	}
+	test.AssertEquals(t, calls, 1)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"context"
+)
+
+// rpcContext returns a context for a single RPC, which expires rp.rpcTimeout
+// from now or at the deadline of parent, whichever is earlier.
+func (rp *reprocessor) rpcContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if rp.rpcTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	return context.WithTimeout(parent, rp.rpcTimeout)
+}
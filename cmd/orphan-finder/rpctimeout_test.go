@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	capb "github.com/letsencrypt/boulder/ca/proto"
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/test"
+	"google.golang.org/grpc"
+)
+
+// wedgedSA is a mockSA whose certificate lookups never return before their
+// context expires.
+type wedgedSA struct {
+	mockSA
+}
+
+func (m *wedgedSA) GetCertificate(ctx context.Context, s string) (core.Certificate, error) {
+	<-ctx.Done()
+	return core.Certificate{}, ctx.Err()
+}
+
+// wedgedCA is a mockCA that never returns before its context expires.
+type wedgedCA struct {
+	mockCA
+}
+
+func (ca *wedgedCA) GenerateOCSP(ctx context.Context, req *capb.GenerateOCSPRequest, _ ...grpc.CallOption) (*capb.OCSPResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRPCTimeout(t *testing.T) {
+	realSleepContext := sleepContext
+	sleepContext = func(context.Context, time.Duration) error { return nil }
+	defer func() { sleepContext = realSleepContext }()
+	rp := newTestReprocessor(&wedgedSA{}, &mockCA{})
+	rp.rpcTimeout = 10 * time.Millisecond
+
+	// A wedged SA fails the orphan instead of hanging
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.failedOrphans, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: .*Existing certificate lookup failed: context deadline exceeded`)), 1)
+
+	// As does a wedged CA
+	log.Clear()
+	rp.sa, rp.ca = &mockSA{}, &wedgedCA{}
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.failedOrphans, int64(2))
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Couldn't generate OCSP: context deadline exceeded`)), 1)
+}
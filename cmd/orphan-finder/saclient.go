@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	bgrpc "github.com/letsencrypt/boulder/grpc"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// saClient is the certificateStorage of an SA reached over gRPC. Its
+// AddCertificate takes a request like AddPrecertificate does, which the
+// wrapper turns back into the same RPC it would send for its positional
+// arguments.
+type saClient struct {
+	*bgrpc.StorageAuthorityClientWrapper
+}
+
+func (c saClient) AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error) {
+	var issued *time.Time
+	if req.Issued != nil {
+		t := time.Unix(0, *req.Issued)
+		issued = &t
+	}
+	return c.StorageAuthorityClientWrapper.AddCertificate(ctx, req.Der, req.GetRegID(), req.Ocsp, issued)
+}
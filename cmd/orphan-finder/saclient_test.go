@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	bgrpc "github.com/letsencrypt/boulder/grpc"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+	"google.golang.org/grpc"
+)
+
+// recordingSAClient is a gRPC SA client recording the AddCertificate request
+// it's sent. Its other methods aren't implemented.
+type recordingSAClient struct {
+	sapb.StorageAuthorityClient
+	req *sapb.AddCertificateRequest
+}
+
+func (c *recordingSAClient) AddCertificate(_ context.Context, req *sapb.AddCertificateRequest, _ ...grpc.CallOption) (*sapb.AddCertificateResponse, error) {
+	c.req = req
+	digest := "digest"
+	return &sapb.AddCertificateResponse{Digest: &digest}, nil
+}
+
+func TestSAClientAddCertificate(t *testing.T) {
+	inner := &recordingSAClient{}
+	sa := saClient{bgrpc.NewStorageAuthorityClient(inner)}
+	der, regID, issued := []byte{1, 2, 3}, int64(1001), int64(1443849660000000000)
+	_, err := sa.AddCertificate(context.Background(), &sapb.AddCertificateRequest{
+		Der:    der,
+		RegID:  &regID,
+		Ocsp:   []byte{4},
+		Issued: &issued,
+	})
+	test.AssertNotError(t, err, "adding certificate failed")
+	test.AssertByteEquals(t, inner.req.Der, der)
+	test.AssertEquals(t, inner.req.GetRegID(), regID)
+	test.AssertByteEquals(t, inner.req.Ocsp, []byte{4})
+	test.AssertEquals(t, inner.req.GetIssued(), issued)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// legacySerialLength is the length of the serials stored before serials were
+// lengthened to the 36 hex characters produced by core.SerialToString.
+const legacySerialLength = 32
+
+// legacySerialToString returns the legacy form of serial, the lower case hex
+// encoding zero padded to 32 characters, and true if it fits into that length.
+// Certificates issued with such serials may have been stored under this form
+// rather than the canonical one, so they have to be looked up under both to
+// avoid adding them again.
+func legacySerialToString(serial *big.Int) (string, bool) {
+	if serial.Sign() < 0 || serial.BitLen() > legacySerialLength*4 {
+		return "", false
+	}
+	return fmt.Sprintf("%0*x", legacySerialLength, serial), true
+}
+
+// normalizeSerial canonicalizes a hex serial to the format produced by
+// core.SerialToString, which is how serials are stored and looked up. Besides
+// the canonical form it accepts an optional 0x prefix, colon or space separated
+// bytes, upper case hex, and any amount of zero padding.
+func normalizeSerial(serial string) (string, error) {
+	s := strings.TrimSpace(serial)
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+	}
+	s = strings.NewReplacer(":", "", " ", "", "\t", "").Replace(s)
+	if s == "" {
+		return "", errors.New("empty serial")
+	}
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok || n.Sign() < 0 {
+		return "", fmt.Errorf("invalid serial %q", serial)
+	}
+	return core.SerialToString(n), nil
+}
+
+// buildSerialFilter returns the serialFilter for the given comma separated list
+// of serials and optional file of serials, normalizing each of them. It returns
+// nil, allowing all serials, when neither is provided.
+func buildSerialFilter(list, path string) (map[string]bool, error) {
+	var serials []string
+	if list != "" {
+		serials = strings.Split(list, ",")
+	}
+	if path != "" {
+		fromFile, err := readListFile(path)
+		if err != nil {
+			return nil, err
+		}
+		serials = append(serials, fromFile...)
+	}
+	if len(serials) == 0 {
+		return nil, nil
+	}
+	filter := make(map[string]bool, len(serials))
+	for _, serial := range serials {
+		normalized, err := normalizeSerial(serial)
+		if err != nil {
+			return nil, err
+		}
+		filter[normalized] = true
+	}
+	return filter, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestNormalizeSerial(t *testing.T) {
+	canonical := "0000ffa0160630d618b2eb5c0510824b1427"
+	testCases := []struct {
+		Name  string
+		Input string
+	}{
+		{"Canonical", canonical},
+		{"Unpadded", "ffa0160630d618b2eb5c0510824b1427"},
+		{"Upper case", "FFA0160630D618B2EB5C0510824B1427"},
+		{"0x prefixed", "0xffa0160630d618b2eb5c0510824b1427"},
+		{"Colon separated", "ff:a0:16:06:30:d6:18:b2:eb:5c:05:10:82:4b:14:27"},
+		{"Space separated", "ff a0 16 06 30 d6 18 b2 eb 5c 05 10 82 4b 14 27"},
+		{"Surrounding whitespace", "  00ffa0160630d618b2eb5c0510824b1427\t"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			normalized, err := normalizeSerial(tc.Input)
+			test.AssertNotError(t, err, "normalizeSerial failed")
+			test.AssertEquals(t, normalized, canonical)
+		})
+	}
+
+	for _, invalid := range []string{"", "0x", "not hex", "-ff"} {
+		_, err := normalizeSerial(invalid)
+		test.AssertError(t, err, "normalizeSerial accepted "+invalid)
+	}
+}
+
+func TestBuildSerialFilter(t *testing.T) {
+	filter, err := buildSerialFilter("", "")
+	test.AssertNotError(t, err, "empty filter failed")
+	test.Assert(t, filter == nil, "empty filter should allow all serials")
+
+	f, err := ioutil.TempFile("", "serials")
+	test.AssertNotError(t, err, "creating serials file")
+	defer os.Remove(f.Name())
+	_, err = f.WriteString("# from the incident ticket\n\n0x03e1dea6f3349009a90e0306dbb39c3e7ca2\n")
+	test.AssertNotError(t, err, "writing serials file")
+	f.Close()
+
+	filter, err = buildSerialFilter("ff:a0", f.Name())
+	test.AssertNotError(t, err, "filter failed")
+	test.AssertDeepEquals(t, filter, map[string]bool{
+		"00000000000000000000000000000000ffa0": true,
+		"03e1dea6f3349009a90e0306dbb39c3e7ca2": true,
+	})
+
+	_, err = buildSerialFilter("zz", "")
+	test.AssertError(t, err, "invalid serial accepted")
+}
+
+func TestParseLineSerialFilter(t *testing.T) {
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	var err error
+	rp.serialFilter, err = buildSerialFilter("ff:a0:16:06:30:d6:18:b2:eb:5c:05:10:82:4b:14:27:48:56", "")
+	test.AssertNotError(t, err, "building serial filter")
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	checkNoErrors(t)
+}
+
+func TestLegacySerialToString(t *testing.T) {
+	testCases := []struct {
+		Name      string
+		Serial    string
+		Canonical string
+		Legacy    string
+		LegacyOK  bool
+	}{
+		{"Leading zeros", "ff", "0000000000000000000000000000000000ff", "000000000000000000000000000000ff", true},
+		{"High bit set in 16 bytes", "ff0000000000000000000000000000a1", "0000ff0000000000000000000000000000a1", "ff0000000000000000000000000000a1", true},
+		{"High bit set in 18 bytes", "ff00000000000000000000000000000000a1", "ff00000000000000000000000000000000a1", "", false},
+		{"17 bytes", "0100000000000000000000000000000000", "000100000000000000000000000000000000", "", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			serial, ok := new(big.Int).SetString(tc.Serial, 16)
+			test.Assert(t, ok, "invalid test serial")
+			test.AssertEquals(t, core.SerialToString(serial), tc.Canonical)
+			legacy, ok := legacySerialToString(serial)
+			test.AssertEquals(t, ok, tc.LegacyOK)
+			test.AssertEquals(t, legacy, tc.Legacy)
+		})
+	}
+	_, ok := legacySerialToString(big.NewInt(-1))
+	test.AssertEquals(t, ok, false)
+}
+
+func TestCheckCertLegacySerial(t *testing.T) {
+	serial, _ := new(big.Int).SetString("ff0000000000000000000000000000a1", 16)
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "failed to generate key")
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "legacy.example.com"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	test.AssertNotError(t, err, "failed to create certificate")
+	cert, err := x509.ParseCertificate(der)
+	test.AssertNotError(t, err, "failed to parse certificate")
+
+	// An orphan stored under its legacy serial isn't added again
+	legacy, _ := legacySerialToString(serial)
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	sa := &mockSA{certificates: []core.Certificate{{Serial: legacy, DER: der}}}
+	_, _, err = rp.checkCert(context.Background(), sa, cert)
+	test.AssertEquals(t, err, errAlreadyExists)
+
+	sa = &mockSA{certificates: []core.Certificate{{Serial: core.SerialToString(serial), DER: der}}}
+	_, _, err = rp.checkCert(context.Background(), sa, cert)
+	test.AssertEquals(t, err, errAlreadyExists)
+
+	sa = &mockSA{}
+	found, _, err := rp.checkCert(context.Background(), sa, cert)
+	test.AssertNotError(t, err, "missing orphan not found")
+	test.Assert(t, found == cert, "missing orphan not returned")
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	blog "github.com/letsencrypt/boulder/log"
+	"google.golang.org/grpc/connectivity"
+)
+
+// jitterRand returns a random duration in [0, n). It is replaced in tests.
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano())).Int63n
+
+// waitStartJitter waits a random delay below maxJitter, so that instances
+// launched together don't all start writing at once. It doesn't wait if
+// maxJitter is zero or nothing is going to be written because the run is
+// analysisOnly.
+func waitStartJitter(logger blog.Logger, maxJitter time.Duration, analysisOnly bool) {
+	if maxJitter <= 0 || analysisOnly {
+		return
+	}
+	jitter := time.Duration(jitterRand(int64(maxJitter)))
+	logger.Infof("Waiting %s of start jitter before processing", jitter)
+	sleep(jitter)
+}
+
+// serviceConn is the part of a *grpc.ClientConn used to wait for its service.
+type serviceConn interface {
+	GetState() connectivity.State
+	WaitForStateChange(ctx context.Context, sourceState connectivity.State) bool
+}
+
+// waitForReady waits until conn is connected to the named service, logging
+// every attempt gRPC makes to reconnect, and returns an error if ctx expires
+// first.
+func waitForReady(ctx context.Context, logger blog.Logger, name string, conn serviceConn) error {
+	var attempts int
+	for {
+		state := conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			if attempts > 0 {
+				logger.Infof("Connected to %s after %d attempts", name, attempts)
+			}
+			return nil
+		case connectivity.Connecting:
+			attempts++
+			logger.Infof("Waiting for %s, connection attempt %d", name, attempts)
+		case connectivity.Shutdown:
+			return fmt.Errorf("connection to %s was shut down", name)
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("%s not reachable after %d attempts", name, attempts)
+		}
+	}
+}
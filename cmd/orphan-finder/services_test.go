@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+	"google.golang.org/grpc/connectivity"
+)
+
+// fakeServiceConn steps through a fixed sequence of states, after which the
+// wait for a state change times out.
+type fakeServiceConn struct {
+	states []connectivity.State
+}
+
+func (c *fakeServiceConn) GetState() connectivity.State {
+	return c.states[0]
+}
+
+func (c *fakeServiceConn) WaitForStateChange(_ context.Context, _ connectivity.State) bool {
+	if len(c.states) == 1 {
+		return false
+	}
+	c.states = c.states[1:]
+	return true
+}
+
+func TestWaitForReady(t *testing.T) {
+	ctx := context.Background()
+
+	log.Clear()
+	conn := &fakeServiceConn{states: []connectivity.State{connectivity.Ready}}
+	test.AssertNotError(t, waitForReady(ctx, log, "SA", conn), "waiting for a ready service failed")
+	test.AssertEquals(t, len(log.GetAll()), 0)
+
+	log.Clear()
+	conn = &fakeServiceConn{states: []connectivity.State{
+		connectivity.Connecting,
+		connectivity.TransientFailure,
+		connectivity.Connecting,
+		connectivity.Ready,
+	}}
+	test.AssertNotError(t, waitForReady(ctx, log, "SA", conn), "waiting for a recovering service failed")
+	test.AssertEquals(t, len(log.GetAllMatching(`Waiting for SA, connection attempt \d`)), 2)
+	test.AssertEquals(t, len(log.GetAllMatching(`Connected to SA after 2 attempts`)), 1)
+
+	conn = &fakeServiceConn{states: []connectivity.State{connectivity.Connecting, connectivity.TransientFailure}}
+	err := waitForReady(ctx, log, "CA", conn)
+	test.AssertError(t, err, "waiting for an unreachable service succeeded")
+	test.AssertContains(t, err.Error(), "CA not reachable after 1 attempts")
+
+	conn = &fakeServiceConn{states: []connectivity.State{connectivity.Shutdown}}
+	test.AssertError(t, waitForReady(ctx, log, "CA", conn), "waiting for a shut down connection succeeded")
+}
+
+func TestWaitStartJitter(t *testing.T) {
+	defer func() {
+		sleep = time.Sleep
+		jitterRand = rand.New(rand.NewSource(time.Now().UnixNano())).Int63n
+	}()
+	var slept []time.Duration
+	sleep = func(d time.Duration) { slept = append(slept, d) }
+	jitterRand = func(n int64) int64 { return n / 4 }
+	log.Clear()
+
+	waitStartJitter(log, 0, false)
+	test.AssertEquals(t, len(slept), 0)
+
+	waitStartJitter(log, time.Minute, false)
+	test.AssertDeepEquals(t, slept, []time.Duration{15 * time.Second})
+	test.AssertEquals(t, len(log.GetAllMatching("Waiting 15s of start jitter before processing")), 1)
+
+	// Nothing is written in analysis mode, so there is nothing to spread
+	waitStartJitter(log, time.Minute, true)
+	test.AssertEquals(t, len(slept), 1)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// shardSpec selects the orphans one of several instances run over the same log
+// processes, by the hash of their serial.
+type shardSpec struct {
+	index, count uint64
+}
+
+// parseShard parses a shard given as N/M, instance N counting from 0 of M. It
+// returns nil, selecting every orphan, if spec is empty.
+func parseShard(spec string) (*shardSpec, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid shard %q, expected N/M", spec)
+	}
+	index, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard index %q: %s", parts[0], err)
+	}
+	count, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid shard count %q: %s", parts[1], err)
+	}
+	if count == 0 || index >= count {
+		return nil, fmt.Errorf("invalid shard %q, N must be below M", spec)
+	}
+	return &shardSpec{index: index, count: count}, nil
+}
+
+// includes returns true if the orphan with the given canonical serial belongs
+// to the shard. Every serial belongs to exactly one of the shards of a count,
+// whichever instance hashes it. It returns true if s is nil.
+func (s *shardSpec) includes(serial string) bool {
+	if s == nil {
+		return true
+	}
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(serial))
+	return h.Sum64()%s.count == s.index
+}
+
+func (s *shardSpec) String() string {
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
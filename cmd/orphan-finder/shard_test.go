@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestParseShard(t *testing.T) {
+	s, err := parseShard("")
+	test.AssertNotError(t, err, "empty shard rejected")
+	test.Assert(t, s == nil, "empty shard doesn't select every orphan")
+
+	s, err = parseShard("2/5")
+	test.AssertNotError(t, err, "valid shard rejected")
+	test.AssertEquals(t, *s, shardSpec{index: 2, count: 5})
+
+	for _, spec := range []string{"5/5", "0/0", "1", "a/2", "1/2/3", "-1/2"} {
+		_, err := parseShard(spec)
+		test.AssertError(t, err, fmt.Sprintf("invalid shard %q accepted", spec))
+	}
+}
+
+func TestShardIncludes(t *testing.T) {
+	var none *shardSpec
+	test.Assert(t, none.includes("00"), "nil shard skips a serial")
+
+	// Every serial belongs to exactly one shard
+	shards := []*shardSpec{{0, 3}, {1, 3}, {2, 3}}
+	counts := make([]int, len(shards))
+	for i := 0; i < 300; i++ {
+		serial := fmt.Sprintf("%036x", i)
+		var in int
+		for j, s := range shards {
+			if s.includes(serial) {
+				in++
+				counts[j]++
+			}
+		}
+		test.AssertEquals(t, in, 1)
+	}
+	for j, n := range counts {
+		test.Assert(t, n > 0, fmt.Sprintf("shard %d got no serials", j))
+	}
+}
+
+func TestShardSkipsOrphans(t *testing.T) {
+	serial := "ffa0160630d618b2eb5c0510824b14274856"
+
+	// Only the shard the serial hashes to stores the orphan
+	var stored int
+	for i := uint64(0); i < 4; i++ {
+		rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+		rp.shard = &shardSpec{index: i, count: 4}
+		res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+		test.AssertEquals(t, res.stored, rp.shard.includes(serial))
+		if res.stored {
+			stored++
+		}
+	}
+	test.AssertEquals(t, stored, 1)
+}
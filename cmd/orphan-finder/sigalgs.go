@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// knownSigAlgs returns the signature algorithms known to crypto/x509 by name.
+func knownSigAlgs() map[string]x509.SignatureAlgorithm {
+	known := make(map[string]x509.SignatureAlgorithm)
+	for alg := x509.MD2WithRSA; alg < 64; alg++ {
+		// Algorithms crypto/x509 doesn't know are named by their number
+		if name := alg.String(); strings.Trim(name, "0123456789") != "" {
+			known[name] = alg
+		}
+	}
+	return known
+}
+
+// buildAllowedSigAlgs returns the set of the signature algorithms with the
+// given names, as crypto/x509 names them, e.g. SHA256-RSA. It returns nil if
+// no names are given, allowing any algorithm, and an error if any of them
+// isn't known.
+func buildAllowedSigAlgs(names []string) (map[x509.SignatureAlgorithm]bool, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := knownSigAlgs()
+	allowed := make(map[x509.SignatureAlgorithm]bool, len(names))
+	for _, name := range names {
+		alg, ok := known[name]
+		if !ok {
+			var knownNames []string
+			for n := range known {
+				knownNames = append(knownNames, n)
+			}
+			sort.Strings(knownNames)
+			return nil, fmt.Errorf("unknown signature algorithm %q, expected one of %s",
+				name, strings.Join(knownNames, ", "))
+		}
+		allowed[alg] = true
+	}
+	return allowed, nil
+}
+
+// sigAlgAllowed returns true if cert is signed with an allowed algorithm.
+func (rp *reprocessor) sigAlgAllowed(cert *x509.Certificate) bool {
+	return rp.allowedSigAlgs == nil || rp.allowedSigAlgs[cert.SignatureAlgorithm]
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestAllowedSigAlgs(t *testing.T) {
+	_, err := buildAllowedSigAlgs([]string{"SHA256-RSA", "ROT13"})
+	test.AssertError(t, err, "unknown signature algorithm accepted")
+
+	allowed, err := buildAllowedSigAlgs(nil)
+	test.AssertNotError(t, err, "empty list rejected")
+	test.Assert(t, allowed == nil, "empty list doesn't allow every algorithm")
+
+	allowed, err = buildAllowedSigAlgs([]string{"SHA256-RSA", "ECDSA-SHA256"})
+	test.AssertNotError(t, err, "known signature algorithms rejected")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	test.AssertNotError(t, err, "generating key")
+	template := &x509.Certificate{
+		SerialNumber:       big.NewInt(1337),
+		Subject:            pkix.Name{CommonName: "sha1.example.com"},
+		NotBefore:          time.Now(),
+		NotAfter:           time.Now().Add(time.Hour),
+		SignatureAlgorithm: x509.ECDSAWithSHA1,
+	}
+	sha1DER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	test.AssertNotError(t, err, "creating SHA-1 signed certificate")
+
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.allowedSigAlgs = allowed
+
+	// An orphan signed with SHA-1 is skipped with an audit error
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, hex.EncodeToString(sha1DER), "1001", "0"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Skipping certificate .* disallowed signature algorithm ECDSA-SHA1`)), 1)
+	test.AssertEquals(t, rp.disallowedSigAlgs, int64(1))
+	test.AssertEquals(t, len(sa.certificates), 0)
+
+	// An orphan signed with an allowed algorithm is still stored
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.disallowedSigAlgs, int64(1))
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sourceMeta describes where an orphan yielded by a derSource came from.
+type sourceMeta struct {
+	// origin identifies where the orphan came from and is appended in brackets
+	// to the log messages about it. For an orphan from a log it is the log line.
+	origin string
+	// line is the log line the orphan was read from, or empty if it wasn't read
+	// from a log. It is written to the rejects file if the orphan isn't stored.
+	line string
+	// label is the orphan type the source claims the orphan is, or
+	// unknownOrphan if it makes no claim. The type is always determined from
+	// the DER, but a disagreeing label is counted and warned about.
+	label orphanType
+	// regIDErr is set if the source has no usable regID for the orphan, in
+	// which case regIDCategory is the category of the audit error logged for
+	// it. Such an orphan is still looked up and recorded, but never stored.
+	regIDErr      error
+	regIDCategory string
+	// orderID is the ID of the order the orphan was issued for as given by the
+	// source, or empty if the source doesn't name one.
+	orderID string
+	// status is the status the orphan's OCSP response asserts, which is good
+	// unless the source says it was revoked.
+	status orphanStatus
+	// deadline is the deadline of the operations storing the orphan given by
+	// the run budget, or zero if there is none.
+	deadline time.Time
+}
+
+// derSource yields the orphans to process. Every input format is an adapter
+// implementing it, so that they all share the processing done by
+// processSource.
+type derSource interface {
+	// Next returns the next orphan, or io.EOF once there are none left. Input
+	// the source can't make an orphan of is logged and skipped by the source.
+	Next() (der []byte, regID int64, meta sourceMeta, err error)
+}
+
+// sourcedOrphan is an orphan as yielded by a derSource.
+type sourcedOrphan struct {
+	der   []byte
+	regID int64
+	meta  sourceMeta
+}
+
+// memorySource is a derSource yielding a fixed list of orphans.
+type memorySource struct {
+	orphans []sourcedOrphan
+}
+
+func (s *memorySource) Next() ([]byte, int64, sourceMeta, error) {
+	if len(s.orphans) == 0 {
+		return nil, 0, sourceMeta{}, io.EOF
+	}
+	o := s.orphans[0]
+	s.orphans = s.orphans[1:]
+	return o.der, o.regID, o.meta, nil
+}
+
+// processSource stores every orphan yielded by src, counting them in sum. It
+// returns an error only if src fails. With more than one worker the orphans
+// are read from src one at a time but stored concurrently, so they may be
+// stored and logged out of order. It returns once all of them are stored,
+// including those still queued to be added in a batch. If a worker panics the
+// others stop taking orphans and, once they are done, the panic is raised
+// again as a *workerPanic for recoverPanic in the calling goroutine.
+func (rp *reprocessor) processSource(ctx context.Context, sum *summary, src derSource) error {
+	defer rp.batcher.flush(ctx)
+	if rp.workers <= 1 {
+		for {
+			der, regID, meta, err := src.Next()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			rp.processOrphan(ctx, sum, sourcedOrphan{der: der, regID: regID, meta: meta})
+		}
+	}
+	orphans := make(chan sourcedOrphan)
+	// A panic in a goroutine can't be recovered by main, so the first one is
+	// kept and stops the pool, to be raised again once every worker is done
+	// and nothing writes to the outputs anymore
+	var panicked *workerPanic
+	var panicOnce sync.Once
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < rp.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				if r := recover(); r != nil {
+					panicOnce.Do(func() {
+						panicked = &workerPanic{value: r, stack: debug.Stack()}
+						close(stop)
+					})
+				}
+			}()
+			for o := range orphans {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+				rp.processOrphan(ctx, sum, o)
+			}
+		}()
+	}
+	var srcErr error
+read:
+	for {
+		der, regID, meta, err := src.Next()
+		if err != nil {
+			if err != io.EOF {
+				srcErr = err
+			}
+			break
+		}
+		select {
+		case orphans <- sourcedOrphan{der: der, regID: regID, meta: meta}:
+		case <-stop:
+			break read
+		}
+	}
+	close(orphans)
+	wg.Wait()
+	if panicked != nil {
+		panic(panicked)
+	}
+	return srcErr
+}
+
+// processOrphan stores an orphan yielded by a derSource, counting it in sum.
+func (rp *reprocessor) processOrphan(ctx context.Context, sum *summary, o sourcedOrphan) {
+	res := rp.storeOrphan(ctx, o.der, o.regID, o.meta)
+	if res.typ != certOrphan && res.typ != precertOrphan {
+		rp.logger.Errf("Found orphan type %s", res.typ)
+		return
+	}
+	rp.stats.orphanFound(res.typ)
+	sum.count(res.typ, res.stored)
+	sum.serials.record(res)
+}
+
+// abortRun sets *abort, one of rp.conflictAbort, rp.regIDAbort,
+// rp.readOnlyAbort and rp.storeAbort, to err to stop the run.
+func (rp *reprocessor) abortRun(abort *error, err error) {
+	rp.abortMu.Lock()
+	defer rp.abortMu.Unlock()
+	*abort = err
+}
+
+// runStopped returns the reason the run was stopped before reaching the end of
+// its input, or nil if it wasn't.
+func (rp *reprocessor) runStopped() error {
+	rp.abortMu.Lock()
+	defer rp.abortMu.Unlock()
+	for _, err := range []error{rp.conflictAbort, rp.regIDAbort, rp.readOnlyAbort, rp.storeAbort} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logSource is a derSource yielding the orphans in a boulder-ca log. Lines
+// split by the logging infrastructure are joined if they end with the
+// continuation marker, and the lines are read from last to first if the
+// reprocessor's reverseLines is set. The progress through the log is logged as
+// it is read. The log is streamed, so only the current line is held in memory,
+// unless it is read in reverse, which requires reading it whole first.
+type logSource struct {
+	rp   *reprocessor
+	prog *progress
+	sum  *summary
+	// scanner and joiner are used to read the log forwards, and reader and
+	// joined to read it in reverse.
+	scanner *bufio.Scanner
+	joiner  *lineJoiner
+	reader  io.Reader
+	marker  string
+	joined  []joinedLine
+	pos     int
+	// err is the error reading the log failed with, if it did.
+	err error
+	// malformed counts the lines meant to hold an orphan that couldn't be
+	// parsed.
+	malformed int64
+}
+
+func (rp *reprocessor) newLogSource(prog *progress, sum *summary, r io.Reader, continuationMarker string) *logSource {
+	s := &logSource{rp: rp, prog: prog, sum: sum}
+	if rp.reverseLines {
+		s.reader = r
+		s.marker = continuationMarker
+	} else {
+		s.scanner = newLogScanner(r)
+		s.joiner = &lineJoiner{marker: continuationMarker}
+	}
+	return s
+}
+
+// nextLine returns the next logical line, or false at the end of the log or if
+// reading it failed, in which case err is set.
+func (s *logSource) nextLine() (string, bool) {
+	if s.joiner == nil {
+		if s.reader != nil {
+			logData, err := ioutil.ReadAll(s.reader)
+			s.reader = nil
+			if err != nil {
+				s.err = err
+				return "", false
+			}
+			s.joined = joinLogLines(logData, s.marker)
+			s.pos = len(s.joined) - 1
+		}
+		if s.pos < 0 {
+			return "", false
+		}
+		line := s.joined[s.pos]
+		s.pos--
+		s.prog.advance(line.bytes, s.sum)
+		s.rp.health.touch()
+		return line.line, true
+	}
+	for s.scanner.Scan() {
+		physical := s.scanner.Text()
+		s.prog.advance(len(physical)+1, s.sum)
+		s.rp.health.touch()
+		if s.prog.lines <= s.rp.skipLines {
+			continue
+		}
+		if line, ok := s.joiner.add(physical); ok {
+			return line, true
+		}
+	}
+	if err := s.scanner.Err(); err != nil {
+		s.err = err
+		return "", false
+	}
+	return s.joiner.flush()
+}
+
+func (s *logSource) Next() ([]byte, int64, sourceMeta, error) {
+	rp := s.rp
+	for {
+		// Checked before reading on, so that every line read was processed and
+		// a followed log isn't waited on once the run is stopped
+		if rp.interrupted() || rp.runStopped() != nil {
+			return nil, 0, sourceMeta{}, io.EOF
+		}
+		line, ok := s.nextLine()
+		if !ok && s.err != nil {
+			return nil, 0, sourceMeta{}, fmt.Errorf("reading log: %s", s.err)
+		}
+		if !ok {
+			return nil, 0, sourceMeta{}, io.EOF
+		}
+		if line == "" {
+			continue
+		}
+		deadline, ok := rp.budget.startLine(len(line) + 1)
+		if !ok {
+			continue
+		}
+		der, regID, meta, malformed := rp.orphanFromLine(line)
+		if der != nil {
+			meta.deadline = deadline
+			return der, regID, meta, nil
+		}
+		if malformed != nil {
+			s.malformed++
+			atomic.AddInt64(&rp.failedOrphans, 1)
+			rp.countParseFailure()
+			if err := rp.rejects.add(line, malformed); err != nil {
+				rp.logger.Errf("Failed to write rejected line: %s, [%s]", err, line)
+			}
+		}
+		rp.logger.Errf("Found orphan type %s", unknownOrphan)
+	}
+}
+
+// malformedLine is the error of a log line meant to hold an orphan that can't
+// be extracted from it.
+type malformedLine struct {
+	// category is the audit error category of the problem and message the
+	// audit error logged for it by orphanFromLine.
+	category string
+	message  string
+	err      error
+}
+
+func (m *malformedLine) Error() string {
+	return m.err.Error()
+}
+
+// parsedLine is the orphan parseOrphanLine extracted from a log line.
+type parsedLine struct {
+	// der is nil if the line holds no usable orphan.
+	der   []byte
+	regID int64
+	meta  sourceMeta
+	// reached is the last funnelStage the line reached.
+	reached funnelStage
+	// usedDefaultRegID is set if the line has no regID and regID is the
+	// default one.
+	usedDefaultRegID bool
+}
+
+// parseOrphanLine extracts the orphan from a boulder-ca log line of format f,
+// as logged when orphaning certificates and precertificates, without
+// logging or counting anything, so that commands only reading a log can share
+// it. A line without a regID has defaultRegID if it is positive. If the line
+// was meant to hold an orphan but is malformed, the error is a *malformedLine.
+func (f *logFormat) parseOrphanLine(line string, defaultRegID int64) (parsedLine, error) {
+	p := parsedLine{reached: stageScanned, meta: sourceMeta{origin: line, line: line}}
+
+	// The log line should contain a label indicating it is a cert or a precert
+	// orphan. The orphan's type is determined from its DER rather than the
+	// label.
+	p.meta.label = orphanTypeForLabel(line)
+	if p.meta.label == unknownOrphan {
+		return p, nil
+	}
+	p.reached = stageMarker
+	// The log line should also contain certificate DER
+	if !strings.Contains(line, f.certToken) {
+		return p, nil
+	}
+	// Extract and decode the orphan DER
+	derStr := f.cert.FindStringSubmatch(line)
+	if len(derStr) <= 1 {
+		return p, &malformedLine{
+			category: auditUnmatchedCert,
+			message:  fmt.Sprintf("Didn't match regex for cert: %s", line),
+			err:      errors.New("didn't match regex for cert"),
+		}
+	}
+	p.reached = stageCertMatched
+	der, err := hex.DecodeString(derStr[1])
+	if err != nil {
+		return p, &malformedLine{
+			category: auditBadHex,
+			message:  fmt.Sprintf("Couldn't decode hex: %s, [%s]", err, line),
+			err:      fmt.Errorf("couldn't decode hex: %s", err),
+		}
+	}
+	p.reached = stageDecoded
+
+	regStr := f.regID.FindStringSubmatch(line)
+	if len(regStr) <= 1 && defaultRegID > 0 {
+		p.regID = defaultRegID
+		p.usedDefaultRegID = true
+	} else if len(regStr) <= 1 {
+		p.meta.regIDErr = errors.New("regID variable is empty")
+		p.meta.regIDCategory = auditMissingRegID
+	} else {
+		p.regID, err = strconv.ParseInt(regStr[1], 10, 64)
+		if err != nil {
+			p.meta.regIDErr = fmt.Errorf("Couldn't parse regID: %s", err)
+			p.meta.regIDCategory = auditBadRegID
+		}
+	}
+	if orderStr := orderOrphan.FindStringSubmatch(line); len(orderStr) > 1 {
+		p.meta.orderID = orderStr[1]
+	}
+	p.meta.status, err = statusFromLine(line)
+	if err != nil {
+		return p, &malformedLine{
+			category: auditBadStatus,
+			message:  fmt.Sprintf("Couldn't parse OCSP status: %s, [%s]", err, line),
+			err:      fmt.Errorf("couldn't parse OCSP status: %s", err),
+		}
+	}
+	p.der = der
+	return p, nil
+}
+
+// orphanFromLine extracts the orphan from a boulder-ca log line with
+// parseOrphanLine for a command storing it. It returns a nil DER if the line
+// holds no usable orphan, along with the reason if the line was meant to hold
+// one but is malformed, which is logged. The stages the line reached are
+// counted in the funnel.
+func (rp *reprocessor) orphanFromLine(line string) ([]byte, int64, sourceMeta, error) {
+	p, err := rp.format.parseOrphanLine(line, rp.defaultRegID)
+	for stage := stageScanned; stage <= p.reached; stage++ {
+		rp.funnel.reach(stage)
+	}
+	if p.usedDefaultRegID {
+		rp.logger.AuditInfof("Using --default-regID %d for orphan without a regID, [%s]", p.regID, line)
+	}
+	if m, ok := err.(*malformedLine); ok {
+		rp.auditErrf(m.category, "%s", m.message)
+		return nil, 0, p.meta, err
+	}
+	return p.der, p.regID, p.meta, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/core"
+	corepb "github.com/letsencrypt/boulder/core/proto"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestProcessSource(t *testing.T) {
+	log.Clear()
+	rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+
+	src := &memorySource{orphans: []sourcedOrphan{
+		{der: certDER, regID: 1001, meta: sourceMeta{origin: "first"}},
+		{der: []byte("not DER"), regID: 1001, meta: sourceMeta{origin: "garbage"}},
+		{der: precertDER, meta: sourceMeta{
+			origin:        "no-regid",
+			regIDErr:      errors.New("regID variable is empty"),
+			regIDCategory: auditMissingRegID,
+		}},
+		{der: precertDER, regID: 1001, meta: sourceMeta{origin: "second", label: certOrphan}},
+	}}
+	sum := &summary{}
+	err := rp.processSource(context.Background(), sum, src)
+	test.AssertNotError(t, err, "processing source failed")
+	test.AssertEquals(t, sum.String(),
+		"certOrphansFound=1 certOrphansAdded=1 precertOrphansFound=2 precertOrphansAdded=1")
+	test.AssertEquals(t, len(log.GetAllMatching(`Failed to parse orphan DER: .*\[garbage\]`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`regID variable is empty, \[no-regid\]`)), 1)
+	test.AssertEquals(t, len(log.GetAllMatching(`labels orphan .* as a certificate but its DER is a precertificate.*\[second\]`)), 1)
+
+	_, _, _, err = src.Next()
+	test.AssertEquals(t, err, io.EOF)
+}
+
+func TestIssuedDateMatchesAcrossCommands(t *testing.T) {
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+
+	// parse-der stores orphans from a memorySource, parse-ca-log from log lines
+	derSA := &mockSA{clk: clock.NewFake()}
+	src := &memorySource{orphans: []sourcedOrphan{
+		{der: certDER, regID: 1001},
+		{der: precertDER, regID: 1001},
+	}}
+	err := newTestReprocessor(derSA, &mockCA{}).processSource(context.Background(), &summary{}, src)
+	test.AssertNotError(t, err, "processing source failed")
+	logSA := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(logSA, &mockCA{})
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.Assert(t, res.stored, "certificate not stored from log line")
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.Assert(t, res.stored, "precertificate not stored from log line")
+
+	cert, _ := x509.ParseCertificate(certDER)
+	precert, _ := x509.ParseCertificate(precertDER)
+	test.AssertEquals(t, len(derSA.certificates), 1)
+	test.AssertEquals(t, len(logSA.certificates), 1)
+	test.Assert(t, derSA.certificates[0].Issued.Equal(issuedDateForCert(cert, time.Hour)), "parse-der certificate issued date")
+	test.Assert(t, logSA.certificates[0].Issued.Equal(derSA.certificates[0].Issued), "certificate issued dates differ")
+	// The precertificate's issued date survives the round trip through
+	// UnixNano that the SA undoes with time.Unix
+	test.AssertEquals(t, len(derSA.precertificates), 1)
+	test.AssertEquals(t, len(logSA.precertificates), 1)
+	test.Assert(t, derSA.precertificates[0].Issued.Equal(issuedDateForCert(precert, time.Hour)), "parse-der precertificate issued date")
+	test.Assert(t, logSA.precertificates[0].Issued.Equal(derSA.precertificates[0].Issued), "precertificate issued dates differ")
+}
+
+// lockedSA is a mockSA safe for concurrent use.
+type lockedSA struct {
+	sync.Mutex
+	mockSA
+}
+
+func (m *lockedSA) AddCertificate(ctx context.Context, req *sapb.AddCertificateRequest) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.mockSA.AddCertificate(ctx, req)
+}
+
+func (m *lockedSA) GetCertificate(ctx context.Context, s string) (core.Certificate, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.mockSA.GetCertificate(ctx, s)
+}
+
+func (m *lockedSA) AddPrecertificate(ctx context.Context, req *sapb.AddCertificateRequest) (*corepb.Empty, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.mockSA.AddPrecertificate(ctx, req)
+}
+
+func (m *lockedSA) GetPrecertificate(ctx context.Context, req *sapb.Serial) (*corepb.Certificate, error) {
+	m.Lock()
+	defer m.Unlock()
+	return m.mockSA.GetPrecertificate(ctx, req)
+}
+
+func TestProcessSourceWorkers(t *testing.T) {
+	sa := &lockedSA{mockSA: mockSA{clk: clock.NewFake()}}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.workers = 4
+	rp.seen = newSerialCache(false)
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	src := &memorySource{}
+	for i := 0; i < 20; i++ {
+		src.orphans = append(src.orphans,
+			sourcedOrphan{der: certDER, regID: 1001},
+			sourcedOrphan{der: precertDER, regID: 1001})
+	}
+
+	log.Clear()
+	sum := &summary{}
+	err := rp.processSource(context.Background(), sum, src)
+	test.AssertNotError(t, err, "processing source failed")
+	// Every orphan is counted and each serial is only stored once
+	test.AssertEquals(t, sum.String(),
+		"certOrphansFound=20 certOrphansAdded=1 precertOrphansFound=20 precertOrphansAdded=1")
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, rp.duplicatesSkipped, int64(38))
+
+	// A failing source stops the workers
+	rp.seen = nil
+	failing := rp.newLogSource(newProgress(log, clock.NewFake(), 0, 0), &summary{}, &failingReader{data: "unrelated\n"}, "")
+	err = rp.processSource(context.Background(), &summary{}, failing)
+	test.AssertError(t, err, "failing source succeeded")
+}
+
+// panickingSA is a lockedSA that panics when adding a precertificate.
+type panickingSA struct {
+	lockedSA
+}
+
+func (m *panickingSA) AddPrecertificate(context.Context, *sapb.AddCertificateRequest) (*corepb.Empty, error) {
+	panic("precertificate bug")
+}
+
+func TestProcessSourceWorkerPanic(t *testing.T) {
+	var status int64
+	exit = func(code int) { atomic.StoreInt64(&status, int64(code)) }
+	defer func() { exit = os.Exit }()
+	rp := newTestReprocessor(&panickingSA{lockedSA{mockSA: mockSA{clk: clock.NewFake()}}}, &mockCA{})
+	rp.workers = 2
+	rp.seen = newSerialCache(false)
+	sum := &summary{}
+	rp.inv = &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: sum, opts: newTestOptions(rp)}
+	certDER, _ := hex.DecodeString(testCertDER)
+	precertDER, _ := hex.DecodeString(testPreCertDER)
+	src := &memorySource{orphans: []sourcedOrphan{
+		{der: precertDER, regID: 1001},
+		{der: certDER, regID: 1001},
+	}}
+
+	// The panic in a worker stops the pool and is raised again once the other
+	// workers are done, to be recorded as the end of the run by main rather
+	// than crashing the process without a trace in the audit log
+	log.Clear()
+	var raised interface{}
+	func() {
+		defer recoverPanic(&rp.inv)
+		defer func() {
+			raised = recover()
+			panic(raised)
+		}()
+		_ = rp.processSource(context.Background(), sum, src)
+	}()
+	_, ok := raised.(*workerPanic)
+	test.Assert(t, ok, "worker panic not raised again by processSource")
+	test.AssertEquals(t, atomic.LoadInt64(&status), int64(defaultPanicStatus))
+	// The stack logged is the worker's, where the bug is
+	panics := log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder panic: precertificate bug`)
+	test.AssertEquals(t, len(panics), 1)
+	test.AssertContains(t, panics[0], "AddPrecertificate")
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] orphan-finder finished: command=\[parse-ca-log\] status=70 reason=\[panic: precertificate bug\]`)), 1)
+}
+
+func TestLogSource(t *testing.T) {
+	log.Clear()
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	logData := []byte(strings.Join([]string{
+		logLine(certOrphan, testCertDER, "1001", "1"),
+		"unrelated",
+		logLine(precertOrphan, "abc", "1002", "2"),
+		logLine(precertOrphan, testPreCertDER, "1003", "3"),
+	}, "\n"))
+
+	for _, reverse := range []bool{false, true} {
+		rp.reverseLines = reverse
+		src := rp.newLogSource(newProgress(log, clock.NewFake(), 0, 0), &summary{}, bytes.NewReader(logData), "")
+		var regIDs []int64
+		var orderIDs []string
+		for {
+			_, regID, meta, err := src.Next()
+			if err == io.EOF {
+				break
+			}
+			test.AssertNotError(t, err, "reading log source failed")
+			regIDs = append(regIDs, regID)
+			orderIDs = append(orderIDs, meta.orderID)
+		}
+		if reverse {
+			test.AssertDeepEquals(t, regIDs, []int64{1003, 1001})
+			test.AssertDeepEquals(t, orderIDs, []string{"3", "1"})
+		} else {
+			test.AssertDeepEquals(t, regIDs, []int64{1001, 1003})
+			test.AssertDeepEquals(t, orderIDs, []string{"1", "3"})
+		}
+	}
+	// The unrelated and malformed lines are reported on each pass
+	test.AssertEquals(t, len(log.GetAllMatching("Found orphan type unknown")), 4)
+}
+
+// failingReader yields data and then fails.
+type failingReader struct {
+	data string
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, errors.New("disk on fire")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestReprocessLogReadError(t *testing.T) {
+	for _, reverse := range []bool{false, true} {
+		rp := newTestReprocessor(&mockSA{clk: clock.NewFake()}, &mockCA{})
+		rp.seen = newSerialCache(false)
+		rp.reverseLines = reverse
+		sum := &summary{}
+		log.Clear()
+		r := &failingReader{data: logLine(certOrphan, testCertDER, "1001", "0") + "\n"}
+		err := rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), sum, "", readerLogInput("ca.log", r))
+		test.AssertError(t, err, "failing read didn't fail the run")
+		test.AssertContains(t, err.Error(), "disk on fire")
+		// The totals are still logged, counting the lines read before the
+		// failure when reading forwards
+		if reverse {
+			test.AssertEquals(t, sum.certOrphansAdded, int64(0))
+		} else {
+			test.AssertEquals(t, sum.certOrphansAdded, int64(1))
+		}
+		test.AssertEquals(t, len(log.GetAllMatching(`Found \d+ certificate orphans`)), 1)
+	}
+}
+
+func TestStartLine(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.seen = newSerialCache(false)
+	rp.skipLines = 2
+	logData := logLine(certOrphan, testCertDER, "1001", "1") + "\n" +
+		"unrelated\n" +
+		logLine(precertOrphan, testPreCertDER, "1001", "1") + "\n"
+
+	log.Clear()
+	sum := &summary{}
+	prog := newProgress(log, clock.NewFake(), 0, 0)
+	err := rp.reprocessLogs(context.Background(), prog, sum, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertNotError(t, err, "parsing log")
+	test.AssertEquals(t, len(sa.certificates), 0)
+	test.AssertEquals(t, len(sa.precertificates), 1)
+	test.AssertEquals(t, prog.lines, int64(3))
+	test.AssertEquals(t, sum.funnel.String(), "scanned:1,marker:1,cert:1,decoded:1,parsed:1,checked:1,stored:1")
+
+	// A log shorter than the lines to skip is warned about
+	rp.skipLines = 5
+	rp.seen = newSerialCache(false)
+	log.Clear()
+	err = rp.reprocessLogs(context.Background(), newProgress(log, clock.NewFake(), 0, 0), &summary{}, "", readerLogInput("ca.log", strings.NewReader(logData)))
+	test.AssertNotError(t, err, "parsing log")
+	test.AssertEquals(t, len(log.GetAllMatching("WARNING: Skipped all 3 lines of the log, which is shorter than --start-line 5")), 1)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/letsencrypt/boulder/core"
+	// Registers the sqlite3 driver used by openSQLiteSink
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// orphanRecord describes one processed orphan for offline analysis.
+type orphanRecord struct {
+	Serial     string
+	Type       orphanType
+	RegID      int64
+	NotBefore  time.Time
+	NotAfter   time.Time
+	Issuer     string
+	IssuedDate time.Time
+	// InDB is true if the orphan was already present in the DB when it was
+	// looked up, i.e. before this run could have added it.
+	InDB bool
+}
+
+// newOrphanRecord builds the orphanRecord for a parsed orphan, whose issued
+// date is its NotBefore plus backdate.
+func newOrphanRecord(cert *x509.Certificate, typ orphanType, regID int64, backdate time.Duration, inDB bool) orphanRecord {
+	return orphanRecord{
+		Serial:     core.SerialToString(cert.SerialNumber),
+		Type:       typ,
+		RegID:      regID,
+		NotBefore:  cert.NotBefore,
+		NotAfter:   cert.NotAfter,
+		Issuer:     cert.Issuer.String(),
+		IssuedDate: issuedDateForCert(cert, backdate),
+		InDB:       inDB,
+	}
+}
+
+// orphanSink receives an orphanRecord for every processed orphan.
+type orphanSink interface {
+	Record(orphanRecord) error
+	Close() error
+}
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS orphans (
+	serial TEXT NOT NULL,
+	type TEXT NOT NULL,
+	regID INTEGER NOT NULL,
+	notBefore DATETIME NOT NULL,
+	notAfter DATETIME NOT NULL,
+	issuer TEXT NOT NULL,
+	issuedDate DATETIME NOT NULL,
+	inDB BOOLEAN NOT NULL
+)`
+
+// sqliteSink inserts orphanRecords as rows of the orphans table of a SQLite
+// database for offline analysis. All rows are inserted in a single transaction
+// committed by Close, so that nothing is written until the run ends and a
+// crashed run leaves no partial rows. It is safe for concurrent use.
+type sqliteSink struct {
+	sync.Mutex
+	db     *sql.DB
+	tx     *sql.Tx
+	insert *sql.Stmt
+	closed bool
+}
+
+// openSQLiteSink returns a sqliteSink populating the SQLite database at path,
+// creating it and its orphans table if needed. Rows are added to those of
+// earlier runs, so that several runs can share a database.
+func openSQLiteSink(path string) (*sqliteSink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	s := &sqliteSink{db: db}
+	err = s.begin()
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// begin creates the orphans table if needed and starts the transaction rows
+// are inserted in.
+func (s *sqliteSink) begin() error {
+	_, err := s.db.Exec(sqliteSchema)
+	if err != nil {
+		return fmt.Errorf("creating orphans table: %s", err)
+	}
+	s.tx, err = s.db.Begin()
+	if err != nil {
+		return err
+	}
+	s.insert, err = s.tx.Prepare("INSERT INTO orphans " +
+		"(serial, type, regID, notBefore, notAfter, issuer, issuedDate, inDB) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		_ = s.tx.Rollback()
+		return err
+	}
+	return nil
+}
+
+// Record inserts a row for the orphanRecord.
+func (s *sqliteSink) Record(r orphanRecord) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return errors.New("SQLite database already closed")
+	}
+	_, err := s.insert.Exec(
+		r.Serial,
+		r.Type.String(),
+		r.RegID,
+		r.NotBefore.UTC(),
+		r.NotAfter.UTC(),
+		r.Issuer,
+		r.IssuedDate.UTC(),
+		r.InDB)
+	return err
+}
+
+// Close commits the inserted rows and closes the database. Only the first call
+// has any effect.
+func (s *sqliteSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	_ = s.insert.Close()
+	err := s.tx.Commit()
+	if dbErr := s.db.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+// memorySink records orphanRecords in memory.
+type memorySink struct {
+	records []orphanRecord
+}
+
+func (m *memorySink) Record(r orphanRecord) error {
+	m.records = append(m.records, r)
+	return nil
+}
+
+func (m *memorySink) Close() error { return nil }
+
+// readOrphanRows returns the rows of the orphans table of the SQLite database
+// at path, each with its columns joined by |.
+func readOrphanRows(t *testing.T, path string) []string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", path)
+	test.AssertNotError(t, err, "opening SQLite database")
+	defer db.Close()
+	rows, err := db.Query("SELECT serial, type, regID, notBefore, notAfter, issuer, issuedDate, inDB FROM orphans ORDER BY rowid")
+	test.AssertNotError(t, err, "querying orphans")
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var serial, typ, issuer string
+		var regID int64
+		var notBefore, notAfter, issuedDate time.Time
+		var inDB bool
+		err := rows.Scan(&serial, &typ, &regID, &notBefore, &notAfter, &issuer, &issuedDate, &inDB)
+		test.AssertNotError(t, err, "scanning orphan row")
+		out = append(out, fmt.Sprintf("%s|%s|%d|%s|%s|%s|%s|%t", serial, typ, regID,
+			notBefore.Format(time.RFC3339), notAfter.Format(time.RFC3339), issuer,
+			issuedDate.Format(time.RFC3339), inDB))
+	}
+	test.AssertNotError(t, rows.Err(), "reading orphan rows")
+	return out
+}
+
+func TestSQLiteSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "orphans.db")
+
+	sink, err := openSQLiteSink(path)
+	test.AssertNotError(t, err, "openSQLiteSink failed")
+	err = sink.Record(orphanRecord{
+		Serial:     "00ff",
+		Type:       precertOrphan,
+		RegID:      1001,
+		NotBefore:  time.Date(2015, 3, 4, 5, 0, 0, 0, time.UTC),
+		NotAfter:   time.Date(2015, 6, 2, 5, 0, 0, 0, time.UTC),
+		Issuer:     "CN=happy hacker's fake CA",
+		IssuedDate: time.Date(2015, 3, 4, 6, 0, 0, 0, time.UTC),
+		InDB:       true,
+	})
+	test.AssertNotError(t, err, "Record failed")
+	test.AssertNotError(t, sink.Close(), "Close failed")
+	test.AssertNotError(t, sink.Close(), "second Close failed")
+	test.AssertError(t, sink.Record(orphanRecord{}), "Record after Close succeeded")
+
+	// A later run adds its rows to the same database
+	sink, err = openSQLiteSink(path)
+	test.AssertNotError(t, err, "reopening SQLite database")
+	test.AssertNotError(t, sink.Record(orphanRecord{Serial: "0100", Type: certOrphan}), "Record failed")
+	test.AssertNotError(t, sink.Close(), "Close failed")
+
+	rows := readOrphanRows(t, path)
+	test.AssertEquals(t, len(rows), 2)
+	test.AssertEquals(t, rows[0], "00ff|precertificate|1001|2015-03-04T05:00:00Z|2015-06-02T05:00:00Z|"+
+		"CN=happy hacker's fake CA|2015-03-04T06:00:00Z|true")
+	test.AssertDeepEquals(t, strings.SplitN(rows[1], "|", 3)[:2], []string{"0100", "certificate"})
+}
+
+func TestParseLineAnalysisOnly(t *testing.T) {
+	sa := &mockSA{}
+	sink := &memorySink{}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.recordSink = sink
+	rp.analysisOnly = true
+
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, res.typ, certOrphan)
+	checkNoErrors(t)
+	test.AssertEquals(t, len(sa.certificates), 0)
+	test.AssertEquals(t, len(sink.records), 1)
+	test.AssertEquals(t, sink.records[0].Type, certOrphan)
+	test.AssertEquals(t, sink.records[0].RegID, int64(1001))
+	test.AssertEquals(t, sink.records[0].InDB, false)
+}
+
+func TestFinishClosesSQLite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orphan-finder")
+	test.AssertNotError(t, err, "creating temp dir")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "orphans.db")
+	sink, err := openSQLiteSink(path)
+	test.AssertNotError(t, err, "openSQLiteSink failed")
+	rp := newTestReprocessor(&mockSA{}, &mockCA{})
+	rp.recordSink = sink
+	test.AssertNotError(t, sink.Record(orphanRecord{Serial: "00ff", Type: certOrphan}), "Record failed")
+
+	// Nothing is committed until the run finishes, as exiting skips deferred
+	// calls
+	test.AssertEquals(t, len(readOrphanRows(t, path)), 0)
+
+	inv := &invocation{logger: log, command: "parse-ca-log", start: time.Now(), summary: &summary{}, opts: newTestOptions(rp)}
+	inv.finish(0, "")
+	rows := readOrphanRows(t, path)
+	test.AssertEquals(t, len(rows), 1)
+	test.AssertEquals(t, strings.SplitN(rows[0], "|", 2)[0], "00ff")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// summary accumulates the totals of a run. Its counters are only updated
+// atomically so that it can be read while the run is still in progress.
+type summary struct {
+	certOrphansFound    int64
+	certOrphansAdded    int64
+	precertOrphansFound int64
+	precertOrphansAdded int64
+	// orphansFailed is only set if the run failed because orphans couldn't be
+	// added.
+	orphansFailed int64
+	// precertsWithoutOCSP and certsWithoutOCSP are only set if
+	// precertificates or certificates were stored without an OCSP response.
+	precertsWithoutOCSP int64
+	certsWithoutOCSP    int64
+	// duplicatesSkipped is only set if orphans were skipped for having been
+	// handled earlier in the run.
+	duplicatesSkipped int64
+	// pendingSkipped is only set if some of duplicatesSkipped were skipped
+	// while an earlier line with their serial was still being stored, so they
+	// weren't retried if it failed.
+	pendingSkipped int64
+	// orphansTooOld is only set if orphans were skipped for being issued
+	// before issuedSince.
+	orphansTooOld int64
+	// orphansImplausiblyOld is only set if orphans were issued more than
+	// maxOrphanAge ago.
+	orphansImplausiblyOld int64
+	// resumeLine is only set if the run was interrupted, to the number of log
+	// lines processed, which --start-line resumes it after.
+	resumeLine int64
+	// orphansVerified and orphansMissing are only set if the added orphans
+	// were verified after the run.
+	orphansVerified int64
+	orphansMissing  int64
+	// responderChecked and responderMismatched are only set if the OCSP
+	// responder was checked for the added orphans after the run.
+	responderChecked    int64
+	responderMismatched int64
+	// contentConflicts and onConflict are only set if orphans conflicting with
+	// stored content were found.
+	contentConflicts int64
+	onConflict       conflictPolicy
+	// funnel counts the log lines reaching each stage of parsing, if the run
+	// parses a log.
+	funnel *parseFunnel
+	// regIDs tracks the distinct registrations orphans were added for, if
+	// they are tracked.
+	regIDs *regIDSet
+	// budget is the budget of the run, if it has one.
+	budget *runBudget
+	// serials lists the orphans by outcome for the JSON summary.
+	serials orphanSerials
+}
+
+// orphanSerials collects the serials of the orphans of a run by outcome. It is
+// safe for concurrent use.
+type orphanSerials struct {
+	sync.Mutex
+	added       []string
+	skipped     []string
+	conflicting []string
+	failed      []string
+}
+
+// record records the serial of an orphan with the given result. An orphan
+// conflicting with the stored one is only listed as conflicting unless it was
+// stored anyway. Orphans whose DER couldn't be parsed or that are still queued
+// to be added in a batch aren't recorded.
+func (s *orphanSerials) record(res orphanResult) {
+	if res.serial == "" || res.queued {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	if res.conflict {
+		s.conflicting = append(s.conflicting, res.serial)
+	}
+	switch {
+	case res.stored:
+		s.added = append(s.added, res.serial)
+	case res.conflict:
+	case res.skipped:
+		s.skipped = append(s.skipped, res.serial)
+	case res.err != nil:
+		s.failed = append(s.failed, res.serial)
+	}
+}
+
+// sorted returns sorted copies of the added, skipped, conflicting and failed
+// serials, so that runs over the same input list them in the same order
+// however many workers stored them.
+func (s *orphanSerials) sorted() (added, skipped, conflicting, failed []string) {
+	s.Lock()
+	defer s.Unlock()
+	sortedCopy := func(serials []string) []string {
+		c := make([]string, len(serials))
+		copy(c, serials)
+		sort.Strings(c)
+		return c
+	}
+	return sortedCopy(s.added), sortedCopy(s.skipped), sortedCopy(s.conflicting), sortedCopy(s.failed)
+}
+
+// count records the result of processing one orphan.
+func (s *summary) count(typ orphanType, added bool) {
+	var foundStat, addStat *int64
+	switch typ {
+	case certOrphan:
+		foundStat = &s.certOrphansFound
+		addStat = &s.certOrphansAdded
+	case precertOrphan:
+		foundStat = &s.precertOrphansFound
+		addStat = &s.precertOrphansAdded
+	default:
+		return
+	}
+	atomic.AddInt64(foundStat, 1)
+	if added {
+		atomic.AddInt64(addStat, 1)
+	}
+}
+
+// countAdded records that an orphan already counted as found by count was
+// added after all.
+func (s *summary) countAdded(typ orphanType) {
+	switch typ {
+	case certOrphan:
+		atomic.AddInt64(&s.certOrphansAdded, 1)
+	case precertOrphan:
+		atomic.AddInt64(&s.precertOrphansAdded, 1)
+	}
+}
+
+// verified records the result of verifying the added orphans.
+func (s *summary) verified(checked, missing int64) {
+	atomic.StoreInt64(&s.orphansVerified, checked)
+	atomic.StoreInt64(&s.orphansMissing, missing)
+}
+
+// responderVerified records the result of checking the OCSP responder for the
+// added orphans.
+func (s *summary) responderVerified(checked, mismatched int64) {
+	atomic.StoreInt64(&s.responderChecked, checked)
+	atomic.StoreInt64(&s.responderMismatched, mismatched)
+}
+
+// conflicts records the number of orphans found to conflict with stored
+// content and the policy they were resolved by.
+func (s *summary) conflicts(n int64, policy conflictPolicy) {
+	atomic.StoreInt64(&s.contentConflicts, n)
+	s.onConflict = policy
+}
+
+// minus returns a summary of the orphans found and added since s held the
+// counts of prev.
+func (s *summary) minus(prev *summary) *summary {
+	return &summary{
+		certOrphansFound:    atomic.LoadInt64(&s.certOrphansFound) - prev.certOrphansFound,
+		certOrphansAdded:    atomic.LoadInt64(&s.certOrphansAdded) - prev.certOrphansAdded,
+		precertOrphansFound: atomic.LoadInt64(&s.precertOrphansFound) - prev.precertOrphansFound,
+		precertOrphansAdded: atomic.LoadInt64(&s.precertOrphansAdded) - prev.precertOrphansAdded,
+	}
+}
+
+// String returns the totals in a form suitable for a single log line.
+func (s *summary) String() string {
+	str := fmt.Sprintf("certOrphansFound=%d certOrphansAdded=%d precertOrphansFound=%d precertOrphansAdded=%d",
+		atomic.LoadInt64(&s.certOrphansFound),
+		atomic.LoadInt64(&s.certOrphansAdded),
+		atomic.LoadInt64(&s.precertOrphansFound),
+		atomic.LoadInt64(&s.precertOrphansAdded))
+	if n := atomic.LoadInt64(&s.orphansFailed); n > 0 {
+		str += fmt.Sprintf(" orphansFailed=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.precertsWithoutOCSP); n > 0 {
+		str += fmt.Sprintf(" precertsWithoutOCSP=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.certsWithoutOCSP); n > 0 {
+		str += fmt.Sprintf(" certsWithoutOCSP=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.duplicatesSkipped); n > 0 {
+		str += fmt.Sprintf(" duplicatesSkipped=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.pendingSkipped); n > 0 {
+		str += fmt.Sprintf(" pendingSkipped=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.orphansTooOld); n > 0 {
+		str += fmt.Sprintf(" orphansTooOld=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.orphansImplausiblyOld); n > 0 {
+		str += fmt.Sprintf(" orphansImplausiblyOld=%d", n)
+	}
+	if n := atomic.LoadInt64(&s.resumeLine); n > 0 {
+		str += fmt.Sprintf(" resumeLine=%d", n)
+	}
+	if verified := atomic.LoadInt64(&s.orphansVerified); verified > 0 {
+		str += fmt.Sprintf(" orphansVerified=%d orphansMissing=%d", verified, atomic.LoadInt64(&s.orphansMissing))
+	}
+	if checked := atomic.LoadInt64(&s.responderChecked); checked > 0 {
+		str += fmt.Sprintf(" responderChecked=%d responderMismatched=%d", checked, atomic.LoadInt64(&s.responderMismatched))
+	}
+	if conflicts := atomic.LoadInt64(&s.contentConflicts); conflicts > 0 {
+		str += fmt.Sprintf(" contentConflicts=%d onConflict=%s", conflicts, s.onConflict)
+	}
+	if s.regIDs != nil {
+		str += " distinctRegIDs=" + s.regIDs.String()
+	}
+	if s.funnel != nil {
+		str += " funnel=" + s.funnel.String()
+	}
+	if s.budget != nil {
+		str += " " + s.budget.String()
+	}
+	return str
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// runIDPlaceholder is replaced by the run ID in a configured syslog tag.
+const runIDPlaceholder = "{runID}"
+
+// expandSyslogTag returns the syslog tag to use for tag, replacing any
+// {runID} placeholder with runID. An empty tag results in the name of the
+// binary, as used by every other boulder component.
+func expandSyslogTag(tag, runID string) string {
+	if tag == "" {
+		return path.Base(os.Args[0])
+	}
+	return strings.Replace(tag, runIDPlaceholder, runID, -1)
+}
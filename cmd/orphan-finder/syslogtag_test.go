@@ -0,0 +1,15 @@
+package main
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestExpandSyslogTag(t *testing.T) {
+	test.AssertEquals(t, expandSyslogTag("", "testrun"), path.Base(os.Args[0]))
+	test.AssertEquals(t, expandSyslogTag("recovery", "testrun"), "recovery")
+	test.AssertEquals(t, expandSyslogTag("orphan-finder-{runID}", "testrun"), "orphan-finder-testrun")
+}
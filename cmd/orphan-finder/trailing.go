@@ -0,0 +1,32 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"sync/atomic"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// parseOrphanDER parses the certificate in der. If that fails and
+// rp.tolerateTrailing is set, the leading ASN.1 element is parsed on its own
+// instead, and its DER is returned in place of der if that succeeds.
+func (rp *reprocessor) parseOrphanDER(der []byte, origin string) (*x509.Certificate, []byte, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err == nil || !rp.tolerateTrailing {
+		return cert, der, err
+	}
+	var leading asn1.RawValue
+	rest, asn1Err := asn1.Unmarshal(der, &leading)
+	if asn1Err != nil || len(rest) == 0 {
+		return nil, der, err
+	}
+	cert, strippedErr := x509.ParseCertificate(leading.FullBytes)
+	if strippedErr != nil {
+		return nil, der, err
+	}
+	atomic.AddInt64(&rp.trailingStripped, 1)
+	rp.logger.Warningf("Stripped %d trailing bytes from the DER of orphan %s, [%s]",
+		len(rest), core.SerialToString(cert.SerialNumber), origin)
+	return cert, leading.FullBytes, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestTolerateTrailing(t *testing.T) {
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	padded := logLine(certOrphan, testCertDER+"0000deadbeef", "1001", "1")
+
+	// Strict parsing rejects the trailing bytes
+	log.Clear()
+	res := rp.storeParsedLogLine(padded)
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, len(log.GetAllMatching("Failed to parse orphan DER")), 1)
+
+	rp.tolerateTrailing = true
+	log.Clear()
+	res = rp.storeParsedLogLine(padded)
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, res.typ, certOrphan)
+	test.AssertEquals(t, rp.trailingStripped, int64(1))
+	test.AssertEquals(t, len(log.GetAllMatching(`Stripped 6 trailing bytes from the DER of orphan ffa0160630d618b2eb5c0510824b14274856`)), 1)
+	// The certificate is stored without the trailing bytes
+	test.AssertEquals(t, len(sa.certificates), 1)
+	test.AssertEquals(t, len(sa.certificates[0].DER)*2, len(testCertDER))
+
+	// DER that doesn't parse even without trailing bytes is still rejected
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(certOrphan, "3003020100deadbeef", "1001", "1"))
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.trailingStripped, int64(1))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/letsencrypt/boulder/core"
+)
+
+// loadTrustedIssuers loads the PEM encoded issuer certificates at the given
+// paths.
+func loadTrustedIssuers(paths []string) ([]*x509.Certificate, error) {
+	var issuers []*x509.Certificate
+	for _, path := range paths {
+		issuer, err := core.LoadCert(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading trusted issuer certificate %s: %s", path, err)
+		}
+		issuers = append(issuers, issuer)
+	}
+	return issuers, nil
+}
+
+// issuedByTrustedIssuer returns true if the signature of cert validates
+// against the key of one of rp.trustedIssuers, or if none are configured.
+func (rp *reprocessor) issuedByTrustedIssuer(cert *x509.Certificate) bool {
+	if len(rp.trustedIssuers) == 0 {
+		return true
+	}
+	for _, issuer := range rp.trustedIssuers {
+		if cert.CheckSignatureFrom(issuer) == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jmhodges/clock"
+	"github.com/letsencrypt/boulder/test"
+)
+
+func TestTrustedIssuers(t *testing.T) {
+	_, err := loadTrustedIssuers([]string{"../../test/test-ca.pem", "missing.pem"})
+	test.AssertError(t, err, "loading a missing issuer succeeded")
+
+	issuers, err := loadTrustedIssuers([]string{"../../test/test-ca.pem"})
+	test.AssertNotError(t, err, "loading trusted issuers")
+	sa := &mockSA{clk: clock.NewFake()}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.trustedIssuers = issuers
+
+	// The precertificate fixture is signed by another issuer and rejected
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.matched, true)
+	test.AssertEquals(t, res.stored, false)
+	test.AssertEquals(t, rp.untrustedOrphans, int64(1))
+	test.AssertEquals(t, len(sa.precertificates), 0)
+	test.AssertEquals(t, len(log.GetAllMatching(
+		`ERR: \[AUDIT\] Rejecting precertificate 03e1dea6f3349009a90e0306dbb39c3e7ca2 issued by "CN=Let's Encrypt Authority X3,O=Let's Encrypt,C=US", which isn't a trusted issuer`)), 1)
+
+	// The certificate fixture is signed by the test CA and stored
+	log.Clear()
+	res = rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	test.AssertEquals(t, rp.untrustedOrphans, int64(1))
+
+	// Without trusted issuers any issuer is accepted
+	rp.trustedIssuers = nil
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	berrors "github.com/letsencrypt/boulder/errors"
+	sapb "github.com/letsencrypt/boulder/sa/proto"
+)
+
+// addedVerifier records the orphans added during a run so that their presence
+// in the database can be confirmed once the run is done.
+type addedVerifier struct {
+	sync.Mutex
+	added map[orphanType][]string
+}
+
+func newAddedVerifier() *addedVerifier {
+	return &addedVerifier{added: make(map[orphanType][]string)}
+}
+
+// record records that the orphan with the given serial was added. It does
+// nothing if v is nil.
+func (v *addedVerifier) record(typ orphanType, serial string) {
+	if v == nil {
+		return
+	}
+	v.Lock()
+	defer v.Unlock()
+	v.added[typ] = append(v.added[typ], serial)
+}
+
+// verify queries the SA of rp for every recorded orphan and returns the number
+// checked and the serials of those missing from the database, sorted so that
+// runs over the same input report them in the same order. Reads are sent to
+// the primary SA even if a read replica is configured, as a replica lagging
+// behind would report recent writes as missing.
+func (v *addedVerifier) verify(ctx context.Context, rp *reprocessor) (int64, map[orphanType][]string, error) {
+	sa := primaryStorage(rp.sa)
+	v.Lock()
+	defer v.Unlock()
+	var checked int64
+	missing := make(map[orphanType][]string)
+	for _, typ := range []orphanType{certOrphan, precertOrphan} {
+		absent, err := rp.missingSerials(ctx, sa, typ, v.added[typ])
+		if err != nil {
+			return checked, missing, err
+		}
+		checked += int64(len(v.added[typ]))
+		missing[typ] = absent
+		sort.Strings(missing[typ])
+	}
+	return checked, missing, nil
+}
+
+// missingSerials returns those of the given serials for which no orphan of the
+// given type is stored. The lookups are retried like every other RPC.
+func (rp *reprocessor) missingSerials(ctx context.Context, sa certificateStorage, typ orphanType, serials []string) ([]string, error) {
+	var missing []string
+	for _, serial := range serials {
+		serial := serial
+		err := rp.callRPC(ctx, func(ctx context.Context) error {
+			var err error
+			switch typ {
+			case certOrphan:
+				_, err = sa.GetCertificate(ctx, serial)
+			case precertOrphan:
+				_, err = sa.GetPrecertificate(ctx, &sapb.Serial{Serial: &serial})
+			}
+			return err
+		})
+		if berrors.Is(err, berrors.NotFound) {
+			missing = append(missing, serial)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// verifyAdded verifies the orphans recorded by rp.verifier, logging any missing
+// ones and adding the result to sum. It does nothing if rp.verifier is nil.
+func (rp *reprocessor) verifyAdded(ctx context.Context, inv *invocation, sum *summary) {
+	if rp.verifier == nil {
+		return
+	}
+	checked, missing, err := rp.verifier.verify(ctx, rp)
+	inv.failOnError(err, "Failed to verify added orphans")
+	var absent int64
+	for _, typ := range []orphanType{certOrphan, precertOrphan} {
+		for _, serial := range missing[typ] {
+			inv.logger.AuditErrf("Added %s %s is missing from the database", typ, serial)
+			absent++
+		}
+	}
+	sum.verified(checked, absent)
+	inv.logger.Infof("Verified %d added orphans, %d missing from the database", checked, absent)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/letsencrypt/boulder/test"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestVerifyAdded(t *testing.T) {
+	ctx := context.Background()
+	sa := &mockSA{}
+	rp := newTestReprocessor(sa, &mockCA{})
+	rp.verifier = newAddedVerifier()
+	log.Clear()
+	res := rp.storeParsedLogLine(logLine(certOrphan, testCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+	res = rp.storeParsedLogLine(logLine(precertOrphan, testPreCertDER, "1001", "0"))
+	test.AssertEquals(t, res.stored, true)
+
+	checked, missing, err := rp.verifier.verify(ctx, rp)
+	test.AssertNotError(t, err, "verify failed")
+	test.AssertEquals(t, checked, int64(2))
+	test.AssertEquals(t, len(missing[certOrphan])+len(missing[precertOrphan]), 0)
+
+	// A write that silently didn't persist is reported as missing
+	certSerial := sa.certificates[0].Serial
+	sa.certificates = nil
+	checked, missing, err = rp.verifier.verify(ctx, rp)
+	test.AssertNotError(t, err, "verify failed")
+	test.AssertEquals(t, checked, int64(2))
+	test.AssertDeepEquals(t, missing[certOrphan], []string{certSerial})
+	test.AssertEquals(t, len(missing[precertOrphan]), 0)
+
+	// The result is reported in the summary
+	sum := &summary{}
+	inv := &invocation{logger: log, command: "parse-ca-log", summary: sum, opts: newTestOptions(rp)}
+	log.Clear()
+	rp.verifyAdded(ctx, inv, sum)
+	test.AssertEquals(t, len(log.GetAllMatching(`ERR: \[AUDIT\] Added certificate `+certSerial+` is missing from the database`)), 1)
+	test.AssertContains(t, sum.String(), "orphansVerified=2 orphansMissing=1")
+}
+
+func TestVerifyMissingSorted(t *testing.T) {
+	v := newAddedVerifier()
+	for _, serial := range []string{"03", "01", "02"} {
+		v.record(precertOrphan, serial)
+	}
+	_, missing, err := v.verify(context.Background(), newTestReprocessor(&mockSA{}, &mockCA{}))
+	test.AssertNotError(t, err, "verify failed")
+	test.AssertDeepEquals(t, missing[precertOrphan], []string{"01", "02", "03"})
+}
+
+func TestVerifyRetried(t *testing.T) {
+	realSleepContext := sleepContext
+	sleepContext = func(context.Context, time.Duration) error { return nil }
+	defer func() { sleepContext = realSleepContext }()
+	v := newAddedVerifier()
+	v.record(certOrphan, "00ff")
+
+	// A lookup failing transiently is retried rather than failing the run
+	sa := &flakySA{failures: 1, err: status.Error(codes.Unavailable, "overloaded")}
+	rp := newTestReprocessor(sa, &mockCA{})
+	checked, missing, err := v.verify(context.Background(), rp)
+	test.AssertNotError(t, err, "verify failed on a transient error")
+	test.AssertEquals(t, checked, int64(1))
+	test.AssertDeepEquals(t, missing[certOrphan], []string{"00ff"})
+	test.AssertEquals(t, sa.calls, 2)
+	test.AssertEquals(t, rp.rpcRetries, int64(1))
+}
@@ -156,8 +156,16 @@ func StatsAndLogging(logConf SyslogConfig, addr string) (prometheus.Registerer,
 	return newStatsRegistry(addr, logger), logger
 }
 
+// NewLogger constructs a logger as NewLoggerWithTag does, tagging its syslog
+// entries with the name of the running binary.
 func NewLogger(logConf SyslogConfig) blog.Logger {
-	tag := path.Base(os.Args[0])
+	return NewLoggerWithTag(logConf, path.Base(os.Args[0]))
+}
+
+// NewLoggerWithTag constructs an AuditLogger whose syslog entries carry the
+// given tag, sets it as the default logger, and configures the cfssl, mysql,
+// and grpc packages to use it.
+func NewLoggerWithTag(logConf SyslogConfig, tag string) blog.Logger {
 	syslogger, err := syslog.Dial(
 		"",
 		"",
@@ -151,7 +151,9 @@ func PublicKeysEqual(a, b interface{}) (bool, error) {
 }
 
 // SerialToString converts a certificate serial number (big.Int) to a String
-// consistently.
+// consistently. The canonical form is the lower case hex encoding of the
+// serial, zero padded to 36 characters. Serials longer than 18 bytes are not
+// truncated.
 func SerialToString(serial *big.Int) string {
 	return fmt.Sprintf("%036x", serial)
 }
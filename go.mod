@@ -17,12 +17,14 @@ require (
 	github.com/jmhodges/clock v0.0.0-20160418191101-880ee4c33548
 	github.com/letsencrypt/challtestsrv v1.0.2
 	github.com/letsencrypt/pkcs11key/v4 v4.0.0
+	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/miekg/dns v1.1.30
 	github.com/miekg/pkcs11 v1.0.3
 	github.com/onsi/ginkgo v1.8.0 // indirect
 	github.com/onsi/gomega v1.5.0 // indirect
 	github.com/prometheus/client_golang v1.7.1
 	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.10.0
 	github.com/syndtr/goleveldb v0.0.0-20180331014930-714f901b98fd // indirect
 	github.com/titanous/rocacheck v0.0.0-20171023193734-afe73141d399
 	github.com/weppos/publicsuffix-go v0.13.1-0.20200721065424-2c0d957a7459